@@ -2,20 +2,21 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/0xElder/elder-wrap/pkg/admin"
 	"github.com/0xElder/elder-wrap/pkg/config"
 	"github.com/0xElder/elder-wrap/pkg/elder"
+	"github.com/0xElder/elder-wrap/pkg/journal"
 	"github.com/0xElder/elder-wrap/pkg/keystore"
 	"github.com/0xElder/elder-wrap/pkg/logging"
-	"github.com/0xElder/elder-wrap/pkg/middleware"
-	"github.com/0xElder/elder-wrap/pkg/rollapp"
-	"github.com/gorilla/mux"
+	"github.com/0xElder/elder-wrap/pkg/server"
+	"github.com/0xElder/elder-wrap/pkg/tracing"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
@@ -27,7 +28,10 @@ func main() {
 	defer ctxCancel()
 
 	cfg = config.NewConfig()
-	loggerOpts := &slog.HandlerOptions{Level: cfg.GetSlogLevel()}
+	// The log level follows config reloads
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(cfg.GetSlogLevel())
+	loggerOpts := &slog.HandlerOptions{Level: logLevel}
 	// Change to JSON/Text logger if needed
 	logger := logging.NewDevSlogger(loggerOpts)
 
@@ -37,7 +41,7 @@ func main() {
 	}
 
 	// Create keystore and client
-	store, err := keystore.NewPlainKeyStore(cfg.KeyStoreDir)
+	store, err := newKeyStore(cfg)
 	if err != nil {
 		logger.Error(ctx, "failed to create keystore", "error", err)
 		os.Exit(1)
@@ -52,7 +56,7 @@ func main() {
 		Use:   "server",
 		Short: "Start the HTTP server",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runServer(ctx, store, logger)
+			return runServer(ctx, store, keystoreClient, logLevel, logger)
 		},
 	}
 	rootCmd.AddCommand(serveCmd)
@@ -63,72 +67,145 @@ func main() {
 	}
 }
 
-// runServer contains the original HTTP server logic
-func runServer(ctx context.Context, keystore keystore.KeyStore, logger logging.Logger) error {
-	elderClient, err := elder.NewElderClient(cfg.ElderGrpcEndpoint, keystore, logger.With("component", "ElderClient"))
+// runServer serves the rollApps until SIGINT or SIGTERM, then drains the in-flight submissions
+func runServer(ctx context.Context, keystore keystore.KeyStore, keystoreClient *keystore.KeyStoreClient, logLevel *slog.LevelVar, logger logging.Logger) error {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Load every key up front, so an encrypted keystore is unlocked before serving requests
+	if _, err := keystore.ListByAlias(); err != nil {
+		logger.Error(ctx, "failed to unlock keystore", "error", err)
+		return errors.Wrap(err, "failed to unlock keystore")
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
+		logger.Error(ctx, "failed to set up tracing", "error", err)
+		return errors.Wrap(err, "failed to set up tracing")
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Error(ctx, "failed to flush traces", "error", err)
+		}
+	}()
+
+	elderClient, err := elder.NewElderClient(cfg.ElderGrpcEndpoints(), cfg.ElderGrpc, keystore, logger.With("component", "ElderClient"))
 	if err != nil {
 		logger.Error(ctx, "failed to create elder client", "error", err)
 		return errors.Wrap(err, "failed to create elder client")
 	}
-	defer elderClient.Conn.Close()
-
-	router := mux.NewRouter()
-	router.Use(func(next http.Handler) http.Handler {
-		return middleware.RestLoggingMiddleware(next, logger)
-	})
-
-	rollApps := cfg.ListRollApps()
-	for _, rollApp := range rollApps {
-		rollAppConfig, err := cfg.GetRollAppConfig(rollApp)
-		if err != nil {
-			logger.Error(ctx, "failed to get rollapp config", "rollapp", rollApp, "error", err)
-			return errors.Wrapf(err, "failed to get rollapp config for %s", rollApp)
+	elderClient.ConfigureTxs(cfg.ElderTx, cfg.ElderBatch)
+	defer func() {
+		if err := elderClient.Close(); err != nil {
+			logger.Error(ctx, "failed to close elder client", "error", err)
 		}
+	}()
 
-		logger.Info(ctx, "Creating rollapp handler", "rollapp", rollApp, "rpc", rollAppConfig.RPC, "elderId", rollAppConfig.ElderRegistrationId)
-		rollAppHandler, err := rollapp.NewRollApp(
-			rollAppConfig.RPC,
-			rollAppConfig.ElderRegistrationId,
-			keystore,
-			logger.With("rollapp", rollApp),
-			elderClient,
-		)
+	var submissionJournal *journal.Journal
+	if cfg.JournalDir != "" {
+		submissionJournal, err = journal.Open(cfg.JournalDir)
 		if err != nil {
-			logger.Error(ctx, "failed to create rollapp handler", "rollapp", rollApp, "error", err)
-			return errors.Wrapf(err, "failed to create rollapp handler for %s", rollApp)
+			logger.Error(ctx, "failed to open submission journal", "error", err)
+			return errors.Wrap(err, "failed to open submission journal")
 		}
+		defer func() {
+			if err := submissionJournal.Close(); err != nil {
+				logger.Error(ctx, "failed to close submission journal", "error", err)
+			}
+		}()
+	}
 
-		router.HandleFunc(fmt.Sprintf("/%s", rollApp), rollAppHandler.HandleRequest).Methods(http.MethodPost)
+	srv, err := server.New(cfg, keystore, elderClient, submissionJournal, logLevel, logger.With("component", "Server"))
+	if err != nil {
+		logger.Error(ctx, "failed to create server", "error", err)
+		return errors.Wrap(err, "failed to create server")
 	}
+	defer srv.Close()
 
-	router.HandleFunc("/", baseHandler).Methods(http.MethodGet)
+	go func() {
+		if err := srv.Watch(ctx, config.File); err != nil {
+			logger.Error(ctx, "hot reload disabled", "error", err)
+		}
+	}()
+
+	var adminServer *http.Server
+	if cfg.Admin.Listen != "" {
+		adminServer, err = startAdminServer(ctx, srv, keystoreClient, logger.With("component", "Admin"))
+		if err != nil {
+			return err
+		}
+	}
 
 	logger.Info(ctx, "Starting elder-wrap server", "port", cfg.ElderWrapPort)
-	addr := net.JoinHostPort("", cfg.ElderWrapPort)
-	return http.ListenAndServe(addr, router)
-}
+	httpServer := &http.Server{
+		Addr:    net.JoinHostPort("", cfg.ElderWrapPort),
+		Handler: srv,
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		logger.Error(ctx, "elder-wrap server failed", "error", err)
+		return errors.Wrap(err, "elder-wrap server failed")
+	case <-ctx.Done():
+	}
+	// A second signal kills the process
+	stop()
 
-func baseHandler(w http.ResponseWriter, r *http.Request) {
-	endpoints := make(map[string]interface{})
-	rollApps := cfg.ListRollApps()
+	shutdownTimeout := srv.Config().ShutdownTimeout
+	logger.Info(ctx, "Shutting down elder-wrap server", "timeout", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-	for _, rollApp := range rollApps {
-		rollAppConfig, err := cfg.GetRollAppConfig(rollApp)
-		if err != nil {
-			continue
-		}
-		endpoints[rollApp] = map[string]interface{}{
-			"endpoint":              fmt.Sprintf("/%s", rollApp),
-			"rpc":                   rollAppConfig.RPC,
-			"elder_registration_id": rollAppConfig.ElderRegistrationId,
+	// Stop accepting connections and wait for the in-flight requests, sync submissions included
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error(ctx, "failed to shut down elder-wrap server", "error", err)
+	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			logger.Error(ctx, "failed to shut down admin server", "error", err)
 		}
 	}
+	// Wait for the inclusion checks of async submissions and the websocket submissions
+	srv.Drain(shutdownCtx)
+
+	// The deferred calls close the rollApp clients, the journal, the elder connections and flush the traces
+	logger.Info(ctx, "Elder-wrap server stopped")
+	return nil
+}
 
-	response := map[string]interface{}{
-		"elder_grpc": cfg.ElderGrpcEndpoint,
-		"endpoints":  endpoints,
+// startAdminServer serves the admin API on admin.listen until it is shut down
+func startAdminServer(ctx context.Context, srv *server.Server, keystoreClient *keystore.KeyStoreClient, logger logging.Logger) (*http.Server, error) {
+	token, err := admin.ReadToken(cfg.Admin.TokenFile)
+	if err != nil {
+		logger.Error(ctx, "failed to read admin token", "error", err)
+		return nil, err
+	}
+	listener, err := admin.Listen(cfg.Admin.Listen)
+	if err != nil {
+		logger.Error(ctx, "failed to listen for admin API", "listen", cfg.Admin.Listen, "error", err)
+		return nil, errors.Wrap(err, "failed to listen for admin API")
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	adminServer := &http.Server{Handler: admin.NewAPI(srv, keystoreClient, token, logger).Handler()}
+	go func() {
+		logger.Info(ctx, "Starting admin API", "listen", cfg.Admin.Listen)
+		if err := adminServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Error(ctx, "admin API failed", "error", err)
+		}
+	}()
+	return adminServer, nil
+}
+
+// newKeyStore creates the keystore backend selected by key_store_type
+func newKeyStore(cfg *config.Config) (keystore.KeyStore, error) {
+	switch cfg.KeyStoreType {
+	case config.KeyStoreTypeEncrypted:
+		return keystore.NewEncryptedKeyStore(cfg.KeyStoreDir, keystore.NewPassphraseFunc(cfg.KeyStorePasswordFile))
+	default:
+		return keystore.NewPlainKeyStore(cfg.KeyStoreDir)
+	}
 }
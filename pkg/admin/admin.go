@@ -0,0 +1,419 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/0xElder/elder-wrap/pkg/keystore"
+	"github.com/0xElder/elder-wrap/pkg/logging"
+	"github.com/0xElder/elder-wrap/pkg/middleware"
+	"github.com/0xElder/elder-wrap/pkg/rollapp"
+	"github.com/0xElder/elder-wrap/pkg/server"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// maxBodySize bounds the request bodies of the admin API
+const maxBodySize = 1 << 20
+
+// reservedPaths are served by elder-wrap and can't be rollApp names
+var reservedPaths = map[string]bool{"metrics": true, "healthz": true, "readyz": true}
+
+// unixPrefix marks an admin listen address as a unix socket path
+const unixPrefix = "unix:"
+
+// API manages the rollApps, the keys and the log level of a running server. Changes to the rollApps
+// and the log level are saved to admin.overrides_file and merged over config.yaml on reload.
+type API struct {
+	server *server.Server
+	keys   *keystore.KeyStoreClient
+	token  []byte
+	logger logging.Logger
+}
+
+func NewAPI(srv *server.Server, keys *keystore.KeyStoreClient, token string, logger logging.Logger) *API {
+	return &API{server: srv, keys: keys, token: []byte(token), logger: logger}
+}
+
+// ReadToken reads the bearer token of the admin API from a file
+func ReadToken(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read admin token file")
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", errors.New("admin token file is empty")
+	}
+	return token, nil
+}
+
+// Listen opens the admin listener on a host:port, or on a unix socket only the owner can use
+func Listen(address string) (net.Listener, error) {
+	if !strings.HasPrefix(address, unixPrefix) {
+		return net.Listen("tcp", address)
+	}
+
+	path := strings.TrimPrefix(address, unixPrefix)
+	// A socket left by a previous run would make the listen fail
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to remove stale admin socket")
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, errors.Wrap(err, "failed to restrict admin socket")
+	}
+	return listener, nil
+}
+
+// Handler serves the admin API under /admin
+func (a *API) Handler() http.Handler {
+	router := mux.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return middleware.RestLoggingMiddleware(next, a.logger)
+	})
+	router.Use(a.authenticate)
+
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.HandleFunc("/rollapps", a.listRollApps).Methods(http.MethodGet)
+	admin.HandleFunc("/rollapps/{name}", a.getRollApp).Methods(http.MethodGet)
+	admin.HandleFunc("/rollapps/{name}", a.putRollApp).Methods(http.MethodPut)
+	admin.HandleFunc("/rollapps/{name}", a.deleteRollApp).Methods(http.MethodDelete)
+	admin.HandleFunc("/rollapps/{name}/pause", a.pauseRollApp).Methods(http.MethodPost)
+	admin.HandleFunc("/rollapps/{name}/resume", a.resumeRollApp).Methods(http.MethodPost)
+	admin.HandleFunc("/keys", a.listKeys).Methods(http.MethodGet)
+	admin.HandleFunc("/keys", a.importKey).Methods(http.MethodPost)
+	admin.HandleFunc("/keys/{alias}", a.deleteKey).Methods(http.MethodDelete)
+	admin.HandleFunc("/log-level", a.getLogLevel).Methods(http.MethodGet)
+	admin.HandleFunc("/log-level", a.putLogLevel).Methods(http.MethodPut)
+	return router
+}
+
+func (a *API) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), a.token) != 1 {
+			a.logger.Warn(r.Context(), "Rejected admin request", "path", r.URL.Path, "remote", r.RemoteAddr)
+			writeError(w, http.StatusUnauthorized, errors.New("invalid or missing bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RollApp is a rollApp as listed by the admin API
+type RollApp struct {
+	Name                string                   `json:"name"`
+	RPC                 string                   `json:"rpc"`
+	RPCs                []string                 `json:"rpcs,omitempty"`
+	WS                  string                   `json:"ws,omitempty"`
+	ElderRegistrationId uint64                   `json:"elder_registration_id"`
+	UpstreamSelection   string                   `json:"upstream_selection"`
+	HealthCheckInterval string                   `json:"health_check_interval"`
+	MaxBlockLag         uint64                   `json:"max_block_lag"`
+	NodeSigning         bool                     `json:"node_signing"`
+	SubmissionMode      string                   `json:"submission_mode"`
+	SponsorKey          string                   `json:"sponsor_key,omitempty"`
+	Paused              bool                     `json:"paused"`
+	Upstreams           []rollapp.UpstreamStatus `json:"upstreams,omitempty"`
+}
+
+func (a *API) rollApp(name string) (*RollApp, bool) {
+	rollAppConfig, err := a.server.Config().GetRollAppConfig(name)
+	if err != nil {
+		return nil, false
+	}
+	view := &RollApp{
+		Name:                name,
+		RPC:                 rollAppConfig.RPC,
+		RPCs:                rollAppConfig.RPCs,
+		WS:                  rollAppConfig.WS,
+		ElderRegistrationId: rollAppConfig.ElderRegistrationId,
+		UpstreamSelection:   rollAppConfig.UpstreamSelection,
+		HealthCheckInterval: rollAppConfig.HealthCheckInterval.String(),
+		MaxBlockLag:         rollAppConfig.MaxBlockLag,
+		NodeSigning:         rollAppConfig.NodeSigning,
+		SubmissionMode:      rollAppConfig.SubmissionMode,
+		SponsorKey:          rollAppConfig.SponsorKey,
+	}
+	if handler, ok := a.server.RollApp(name); ok {
+		view.Paused = handler.Paused()
+		view.Upstreams = handler.UpstreamStatus()
+	}
+	return view, true
+}
+
+func (a *API) listRollApps(w http.ResponseWriter, r *http.Request) {
+	names := a.server.Config().ListRollApps()
+	sort.Strings(names)
+
+	rollApps := make([]*RollApp, 0, len(names))
+	for _, name := range names {
+		if view, ok := a.rollApp(name); ok {
+			rollApps = append(rollApps, view)
+		}
+	}
+	writeJSON(w, http.StatusOK, rollApps)
+}
+
+func (a *API) getRollApp(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	view, ok := a.rollApp(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("rollapp %s not found", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+// putRollApp adds or replaces a rollApp, the body is its rollup_rpcs entry as JSON or YAML
+func (a *API) putRollApp(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.With("method", "putRollApp")
+	name := mux.Vars(r)["name"]
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// JSON is valid YAML, decoding as YAML accepts the field names and durations of config.yaml
+	var rollAppConfig config.RollAppConfig
+	if err := yaml.Unmarshal(body, &rollAppConfig); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "invalid rollapp config"))
+		return
+	}
+
+	if reservedPaths[name] {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%s is a reserved path", name))
+		return
+	}
+
+	_, exists := a.server.RollApp(name)
+	err = a.server.Update(r.Context(), func(cfg *config.Config) error {
+		cfg.RollAppConfigs[name] = rollAppConfig
+		return nil
+	})
+	if err != nil {
+		logger.Error(r.Context(), "Failed to put rollapp", "rollapp", name, "error", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	logger.Info(r.Context(), "Rollapp put through the admin API", "rollapp", name, "created", !exists)
+	view, _ := a.rollApp(name)
+	status := http.StatusOK
+	if !exists {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, view)
+}
+
+func (a *API) deleteRollApp(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.With("method", "deleteRollApp")
+	name := mux.Vars(r)["name"]
+
+	if _, ok := a.server.RollApp(name); !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("rollapp %s not found", name))
+		return
+	}
+	err := a.server.Update(r.Context(), func(cfg *config.Config) error {
+		delete(cfg.RollAppConfigs, name)
+		return nil
+	})
+	if err != nil {
+		logger.Error(r.Context(), "Failed to delete rollapp", "rollapp", name, "error", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	logger.Info(r.Context(), "Rollapp deleted through the admin API", "rollapp", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) pauseRollApp(w http.ResponseWriter, r *http.Request) {
+	a.setPaused(w, r, true)
+}
+
+func (a *API) resumeRollApp(w http.ResponseWriter, r *http.Request) {
+	a.setPaused(w, r, false)
+}
+
+func (a *API) setPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	name := mux.Vars(r)["name"]
+	handler, ok := a.server.RollApp(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("rollapp %s not found", name))
+		return
+	}
+
+	if paused {
+		handler.Pause()
+	} else {
+		handler.Resume()
+	}
+	view, _ := a.rollApp(name)
+	writeJSON(w, http.StatusOK, view)
+}
+
+// Key is a key as listed by the admin API, without its private key
+type Key struct {
+	Alias        string `json:"alias"`
+	EvmAddress   string `json:"evm_address"`
+	ElderAddress string `json:"elder_address"`
+}
+
+func newKey(alias string, key *keystore.Key) Key {
+	return Key{Alias: alias, EvmAddress: key.EvmAddress.Hex(), ElderAddress: key.ElderAddress}
+}
+
+func (a *API) listKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := a.keys.ListKeys()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	list := make([]Key, 0, len(keys))
+	for alias, key := range keys {
+		list = append(list, newKey(alias, key))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Alias < list[j].Alias })
+	writeJSON(w, http.StatusOK, list)
+}
+
+// importKeyRequest imports a private key, or the key derived from a mnemonic at hd_path
+type importKeyRequest struct {
+	Alias      string `json:"alias"`
+	PrivateKey string `json:"private_key"`
+	Mnemonic   string `json:"mnemonic"`
+	HDPath     string `json:"hd_path"`
+}
+
+func (a *API) importKey(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.With("method", "importKey")
+
+	var request importKeyRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "invalid request"))
+		return
+	}
+	if request.Alias == "" || (request.PrivateKey == "") == (request.Mnemonic == "") {
+		writeError(w, http.StatusBadRequest, errors.New("alias and one of private_key or mnemonic are required"))
+		return
+	}
+
+	var err error
+	if request.PrivateKey != "" {
+		err = a.keys.ImportPrivateKey(request.Alias, strings.TrimPrefix(request.PrivateKey, "0x"))
+	} else {
+		if request.HDPath == "" {
+			request.HDPath = keystore.ElderHDPath
+		}
+		path, pathErr := keystore.ParseHDPath(request.HDPath)
+		if pathErr != nil {
+			writeError(w, http.StatusBadRequest, errors.Wrap(pathErr, "invalid hd_path"))
+			return
+		}
+		_, err = a.keys.ImportMnemonic(request.Alias, request.Mnemonic, path)
+	}
+	if err != nil {
+		logger.Error(r.Context(), "Failed to import key", "alias", request.Alias, "error", err)
+		status := http.StatusBadRequest
+		if errors.Is(err, keystore.ErrKeyExists) {
+			status = http.StatusConflict
+		}
+		writeError(w, status, err)
+		return
+	}
+	// The watcher picks the key up as well, reloading now lets it submit right away
+	if err := a.server.ReloadKeys(r.Context()); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	key, err := a.keys.GetKeyByAlias(request.Alias)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	logger.Info(r.Context(), "Key imported through the admin API", "alias", request.Alias, "evmAddress", key.EvmAddress.Hex())
+	writeJSON(w, http.StatusCreated, newKey(request.Alias, key))
+}
+
+func (a *API) deleteKey(w http.ResponseWriter, r *http.Request) {
+	logger := a.logger.With("method", "deleteKey")
+	alias := mux.Vars(r)["alias"]
+
+	if err := a.keys.DeleteKey(alias); err != nil {
+		logger.Error(r.Context(), "Failed to delete key", "alias", alias, "error", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, keystore.ErrKeyNotFound) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return
+	}
+	if err := a.server.ReloadKeys(r.Context()); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	logger.Info(r.Context(), "Key deleted through the admin API", "alias", alias)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+func (a *API) getLogLevel(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, logLevel{Level: a.server.Config().LogLevel})
+}
+
+func (a *API) putLogLevel(w http.ResponseWriter, r *http.Request) {
+	var request logLevel
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "invalid request"))
+		return
+	}
+	switch request.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		writeError(w, http.StatusBadRequest, errors.New("level must be debug, info, warn or error"))
+		return
+	}
+
+	err := a.server.Update(r.Context(), func(cfg *config.Config) error {
+		cfg.LogLevel = request.Level
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	a.logger.Info(r.Context(), "Log level changed through the admin API", "level", request.Level)
+	writeJSON(w, http.StatusOK, request)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,195 @@
+package admin
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/0xElder/elder-wrap/pkg/elder"
+	"github.com/0xElder/elder-wrap/pkg/keystore"
+	"github.com/0xElder/elder-wrap/pkg/logging"
+	"github.com/0xElder/elder-wrap/pkg/server"
+)
+
+const testToken = "secret"
+
+func newTestAPI(t *testing.T, rpc string) (http.Handler, *server.Server) {
+	t.Helper()
+
+	keyStoreDir := t.TempDir()
+	keyStore, err := keystore.NewPlainKeyStore(keyStoreDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logging.NewDevSlogger(nil)
+	cfg := &config.Config{
+		ElderGrpcEndpoint: "localhost:9090",
+		KeyStoreDir:       keyStoreDir,
+		LogLevel:          "info",
+		RollAppConfigs: map[string]config.RollAppConfig{
+			"rollup1": {RPC: rpc, ElderRegistrationId: 1, HealthCheckInterval: time.Hour},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	elderClient, err := elder.NewElderClient(cfg.ElderGrpcEndpoints(), cfg.ElderGrpc, keyStore, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { elderClient.Close() })
+
+	srv, err := server.New(cfg, keyStore, elderClient, nil, new(slog.LevelVar), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+
+	return NewAPI(srv, keystore.NewKeyStoreClient(keyStore, logger), testToken, logger).Handler(), srv
+}
+
+func call(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestAPI_authenticate(t *testing.T) {
+	handler, _ := newTestAPI(t, "http://localhost:8545")
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{name: "valid token", authorization: "Bearer " + testToken, want: http.StatusOK},
+		{name: "wrong token", authorization: "Bearer wrong", want: http.StatusUnauthorized},
+		{name: "missing token", authorization: "", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/rollapps", nil)
+			req.Header.Set("Authorization", tt.authorization)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("GET /admin/rollapps = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPI_rollApps(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer upstream.Close()
+	handler, srv := newTestAPI(t, upstream.URL)
+
+	steps := []struct {
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{method: http.MethodPut, path: "/admin/rollapps/rollup2", body: `{"rpc":"` + upstream.URL + `","elder_registration_id":2,"health_check_interval":"1h"}`, want: http.StatusCreated},
+		{method: http.MethodPut, path: "/admin/rollapps/rollup2", body: `{"rpc":"` + upstream.URL + `","elder_registration_id":3,"health_check_interval":"1h"}`, want: http.StatusOK},
+		{method: http.MethodPut, path: "/admin/rollapps/rollup3", body: `{"rpc":"` + upstream.URL + `"}`, want: http.StatusBadRequest},
+		{method: http.MethodPut, path: "/admin/rollapps/metrics", body: `{"rpc":"` + upstream.URL + `","elder_registration_id":4}`, want: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/admin/rollapps/rollup2/pause", want: http.StatusOK},
+		{method: http.MethodDelete, path: "/admin/rollapps/rollup1", want: http.StatusNoContent},
+		{method: http.MethodDelete, path: "/admin/rollapps/rollup1", want: http.StatusNotFound},
+	}
+	for _, step := range steps {
+		if w := call(handler, step.method, step.path, step.body); w.Code != step.want {
+			t.Fatalf("%s %s = %d, want %d: %s", step.method, step.path, w.Code, step.want, w.Body.String())
+		}
+	}
+
+	w := call(handler, http.MethodGet, "/admin/rollapps", "")
+	var rollApps []RollApp
+	if err := json.Unmarshal(w.Body.Bytes(), &rollApps); err != nil {
+		t.Fatal(err)
+	}
+	if len(rollApps) != 1 || rollApps[0].Name != "rollup2" || rollApps[0].ElderRegistrationId != 3 || !rollApps[0].Paused {
+		t.Errorf("GET /admin/rollapps = %s, want rollup2 paused with elder_registration_id 3", w.Body.String())
+	}
+
+	// The rollApp routes follow the changes
+	rpcRequest := `{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["0x01"]}`
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/rollup2", strings.NewReader(rpcRequest)))
+	if !strings.Contains(w.Body.String(), "submissions are paused") {
+		t.Errorf("POST /rollup2 while paused = %s, want submissions are paused", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/rollup1", strings.NewReader(rpcRequest)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("POST /rollup1 after delete = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestAPI_keys(t *testing.T) {
+	handler, _ := newTestAPI(t, "http://localhost:8545")
+
+	steps := []struct {
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{method: http.MethodPost, path: "/admin/keys", body: `{"alias":"alice","private_key":"0x4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318"}`, want: http.StatusCreated},
+		{method: http.MethodPost, path: "/admin/keys", body: `{"alias":"alice","private_key":"4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318"}`, want: http.StatusConflict},
+		{method: http.MethodPost, path: "/admin/keys", body: `{"alias":"bob"}`, want: http.StatusBadRequest},
+		{method: http.MethodDelete, path: "/admin/keys/carol", want: http.StatusNotFound},
+	}
+	for _, step := range steps {
+		if w := call(handler, step.method, step.path, step.body); w.Code != step.want {
+			t.Fatalf("%s %s = %d, want %d: %s", step.method, step.path, w.Code, step.want, w.Body.String())
+		}
+	}
+
+	w := call(handler, http.MethodGet, "/admin/keys", "")
+	if strings.Contains(w.Body.String(), "private") {
+		t.Errorf("GET /admin/keys leaks private keys: %s", w.Body.String())
+	}
+	var keys []Key
+	if err := json.Unmarshal(w.Body.Bytes(), &keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Alias != "alice" {
+		t.Errorf("GET /admin/keys = %s, want alice", w.Body.String())
+	}
+
+	if w := call(handler, http.MethodDelete, "/admin/keys/alice", ""); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE /admin/keys/alice = %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
+
+func TestAPI_logLevel(t *testing.T) {
+	handler, srv := newTestAPI(t, "http://localhost:8545")
+
+	tests := []struct {
+		level string
+		want  int
+	}{
+		{level: "debug", want: http.StatusOK},
+		{level: "verbose", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := call(handler, http.MethodPut, "/admin/log-level", `{"level":"`+tt.level+`"}`); w.Code != tt.want {
+			t.Errorf("PUT /admin/log-level %s = %d, want %d", tt.level, w.Code, tt.want)
+		}
+	}
+	if got := srv.Config().LogLevel; got != "debug" {
+		t.Errorf("log_level = %s, want debug", got)
+	}
+}
@@ -4,29 +4,49 @@ import (
 	"fmt"
 	"log"
 	"log/slog"
+	"math/big"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
 
+	"github.com/ethereum/go-ethereum/common"
 	"gopkg.in/yaml.v3"
 )
 
+// File is the config file read from the working directory
+const File = "config.yaml"
+
 func NewConfig() *Config {
-	file, err := os.ReadFile("config.yaml")
+	c, err := Load(File)
 	if err != nil {
 		log.Fatal(err)
 	}
+	return c
+}
+
+// Load reads and validates a config file
+func Load(path string) (*Config, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
 
 	var c Config
 	err = yaml.Unmarshal(file, &c)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	err = c.validate()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	return &c
+	return &c, nil
 }
 
 func (c *Config) GetRollAppConfig(name string) (*RollAppConfig, error) {
@@ -37,11 +57,13 @@ func (c *Config) GetRollAppConfig(name string) (*RollAppConfig, error) {
 	return &r, nil
 }
 
+// ListRollApps returns the names of the rollApps in order
 func (c *Config) ListRollApps() []string {
 	var result []string
 	for k := range c.RollAppConfigs {
 		result = append(result, k)
 	}
+	sort.Strings(result)
 	return result
 }
 
@@ -64,8 +86,207 @@ func (r *RollAppConfig) validate() error {
 	if r.RPC == "" {
 		return fmt.Errorf("rpc is required")
 	}
+	switch r.UpstreamSelection {
+	case "":
+		r.UpstreamSelection = UpstreamSelectionRoundRobin
+	case UpstreamSelectionRoundRobin, UpstreamSelectionLowestLatency:
+	default:
+		return fmt.Errorf("upstream_selection must be %s or %s", UpstreamSelectionRoundRobin, UpstreamSelectionLowestLatency)
+	}
+	if r.HealthCheckInterval < 0 {
+		return fmt.Errorf("health_check_interval can't be negative")
+	}
+	if r.HealthCheckInterval == 0 {
+		r.HealthCheckInterval = DefaultHealthCheckInterval
+	}
+	if r.MaxBlockLag == 0 {
+		r.MaxBlockLag = DefaultMaxBlockLag
+	}
+	if r.WS != "" && !strings.HasPrefix(r.WS, "ws://") && !strings.HasPrefix(r.WS, "wss://") {
+		return fmt.Errorf("ws must be a ws:// or wss:// url")
+	}
+	for _, origin := range r.WSOrigins {
+		if origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("ws_origins entry %s must be * or a scheme://host[:port] origin", origin)
+		}
+	}
 	if r.ElderRegistrationId <= 0 {
 		return fmt.Errorf("elder_registration_id can't be negative or zero")
 	}
+	switch r.SubmissionMode {
+	case "":
+		r.SubmissionMode = SubmissionModeSync
+	case SubmissionModeSync, SubmissionModeAsync:
+	default:
+		return fmt.Errorf("submission_mode must be %s or %s", SubmissionModeSync, SubmissionModeAsync)
+	}
+	if r.SponsorKey == "" && !reflect.DeepEqual(r.SponsorPolicy, SponsorPolicyConfig{}) {
+		return fmt.Errorf("sponsor_policy requires sponsor_key")
+	}
+	for _, address := range append(r.SponsorPolicy.AllowedSenders, r.SponsorPolicy.AllowedContracts...) {
+		if !common.IsHexAddress(address) {
+			return fmt.Errorf("sponsor_policy address %s is invalid", address)
+		}
+	}
+	if len(r.Simulation.ABIFiles) > 0 && !r.Simulation.Enabled {
+		return fmt.Errorf("simulation.abi_files requires simulation.enabled")
+	}
+	return nil
+}
+
+// ElderGrpcEndpoints returns elder_grpc_endpoint followed by the elder_grpc endpoints, without duplicates
+func (c *Config) ElderGrpcEndpoints() []string {
+	endpoints := []string{c.ElderGrpcEndpoint}
+	seen := map[string]bool{c.ElderGrpcEndpoint: true}
+	for _, endpoint := range c.ElderGrpc.Endpoints {
+		if !seen[endpoint] {
+			seen[endpoint] = true
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
+
+// gasPricePattern matches a decimal amount followed by a denom, e.g. 0.025uelder
+var gasPricePattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[a-zA-Z][a-zA-Z0-9/:._-]{2,127}$`)
+
+func (t *ElderTxConfig) validate() error {
+	if t.GasMultiplier < 0 {
+		return fmt.Errorf("elder_tx.gas_multiplier can't be negative")
+	}
+	if t.GasPrice != "" && !gasPricePattern.MatchString(t.GasPrice) {
+		return fmt.Errorf("elder_tx.gas_price %s must be an amount and a denom, e.g. 0.025uelder", t.GasPrice)
+	}
+	if t.GasMultiplier == 0 {
+		t.GasMultiplier = DefaultElderTxGasMultiplier
+	}
+	return nil
+}
+
+func (b *ElderBatchConfig) validate() error {
+	if b.MaxSize < 0 || b.Window < 0 {
+		return fmt.Errorf("elder_batch settings can't be negative")
+	}
+	if b.Window == 0 {
+		b.Window = DefaultElderBatchWindow
+	}
+	return nil
+}
+
+func (g *ElderGrpcConfig) validate() error {
+	if (g.TLS.CertFile == "") != (g.TLS.KeyFile == "") {
+		return fmt.Errorf("elder_grpc.tls.cert_file and elder_grpc.tls.key_file must be set together")
+	}
+	if !g.TLS.Enabled && (g.TLS.CAFile != "" || g.TLS.CertFile != "") {
+		return fmt.Errorf("elder_grpc.tls.enabled must be set to use a CA or client certificate")
+	}
+	if g.KeepaliveTime < 0 || g.KeepaliveTimeout < 0 || g.DialTimeout < 0 || g.CallTimeout < 0 {
+		return fmt.Errorf("elder_grpc timeouts can't be negative")
+	}
+	if g.KeepaliveTime == 0 {
+		g.KeepaliveTime = DefaultElderKeepaliveTime
+	}
+	if g.KeepaliveTimeout == 0 {
+		g.KeepaliveTimeout = DefaultElderKeepaliveTimeout
+	}
+	if g.DialTimeout == 0 {
+		g.DialTimeout = DefaultElderDialTimeout
+	}
+	return nil
+}
+
+func (t *TracingConfig) validate() error {
+	switch t.Exporter {
+	case "":
+		t.Exporter = TracingExporterNone
+	case TracingExporterNone, TracingExporterOTLP, TracingExporterStdout:
+	default:
+		return fmt.Errorf("tracing.exporter must be %s, %s or %s", TracingExporterNone, TracingExporterOTLP, TracingExporterStdout)
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		return fmt.Errorf("tracing.sample_ratio must be between 0 and 1")
+	}
+	if t.SampleRatio == 0 {
+		t.SampleRatio = 1
+	}
+	if t.ServiceName == "" {
+		t.ServiceName = DefaultTracingServiceName
+	}
+	return nil
+}
+
+func (h *HealthConfig) validate() error {
+	if h.Timeout < 0 || h.MaxBlockAge < 0 {
+		return fmt.Errorf("health timeouts can't be negative")
+	}
+	if h.Timeout == 0 {
+		h.Timeout = DefaultHealthTimeout
+	}
+	if h.MaxBlockAge == 0 {
+		h.MaxBlockAge = DefaultHealthMaxBlockAge
+	}
+	if h.MinElderBalance != "" {
+		if _, ok := h.MinElderBalanceInt(); !ok {
+			return fmt.Errorf("health.min_elder_balance must be an integer amount")
+		}
+		if h.ElderDenom == "" {
+			return fmt.Errorf("health.elder_denom is required with health.min_elder_balance")
+		}
+	}
 	return nil
 }
+
+// MinElderBalanceInt returns the minimum Elder balance of the keys, false when it is not set or invalid
+func (h *HealthConfig) MinElderBalanceInt() (*big.Int, bool) {
+	if h.MinElderBalance == "" {
+		return nil, false
+	}
+	balance, ok := new(big.Int).SetString(h.MinElderBalance, 10)
+	if !ok || balance.Sign() < 0 {
+		return nil, false
+	}
+	return balance, true
+}
+
+// Upstreams returns rpc followed by the rpcs, without duplicates
+func (r *RollAppConfig) Upstreams() []string {
+	upstreams := []string{r.RPC}
+	seen := map[string]bool{r.RPC: true}
+	for _, rpc := range r.RPCs {
+		if !seen[rpc] {
+			seen[rpc] = true
+			upstreams = append(upstreams, rpc)
+		}
+	}
+	return upstreams
+}
+
+func (t *TxPoolConfig) validate() error {
+	if t.PriceBump == 0 {
+		t.PriceBump = DefaultTxPoolPriceBump
+	}
+	if t.MaxQueuedPerSender < 0 {
+		return fmt.Errorf("tx_pool.max_queued_per_sender can't be negative")
+	}
+	if t.MaxQueuedPerSender == 0 {
+		t.MaxQueuedPerSender = DefaultTxPoolMaxQueuedPerSender
+	}
+	if t.MaxQueued < 0 {
+		return fmt.Errorf("tx_pool.max_queued can't be negative")
+	}
+	if t.MaxQueued == 0 {
+		t.MaxQueued = DefaultTxPoolMaxQueued
+	}
+	return nil
+}
+
+// PersistPath returns the file the queued transactions of a rollApp are persisted to, empty when disabled
+func (t *TxPoolConfig) PersistPath(rollApp string) string {
+	if t.PersistDir == "" {
+		return ""
+	}
+	return filepath.Join(t.PersistDir, rollApp+".json")
+}
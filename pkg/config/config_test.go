@@ -3,7 +3,10 @@ package config
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestConfig_validate(t *testing.T) {
@@ -93,6 +96,526 @@ func TestConfig_validate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "async submission mode",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+						SubmissionMode:      SubmissionModeAsync,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid submission mode",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+						SubmissionMode:      "fire-and-forget",
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+			},
+			wantErr: true,
+		},
+		{
+			name: "encrypted keystore",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir:  "/tmp/keystore",
+				KeyStoreType: KeyStoreTypeEncrypted,
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid keystore type",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir:  "/tmp/keystore",
+				KeyStoreType: "vault",
+			},
+			wantErr: true,
+		},
+		{
+			name: "tx pool",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+				TxPool: TxPoolConfig{
+					PersistDir:         "/tmp/txpool",
+					PriceBump:          25,
+					MaxQueuedPerSender: 16,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid tx pool max queued",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+				TxPool: TxPoolConfig{
+					MaxQueuedPerSender: -1,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "rollapp websocket",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						WS:                  "ws://localhost:8546",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid rollapp websocket",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						WS:                  "http://localhost:8546",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid websocket origin",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						WSOrigins:           []string{"https://dapp.example", "dapp.example"},
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+			},
+			wantErr: true,
+		},
+		{
+			name: "multiple upstreams",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						RPCs:                []string{"http://localhost:9545"},
+						UpstreamSelection:   UpstreamSelectionLowestLatency,
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid upstream selection",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						UpstreamSelection:   "random",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+			},
+			wantErr: true,
+		},
+		{
+			name: "elder grpc mtls",
+			config: Config{
+				ElderGrpcEndpoint: "elder.example.com:443",
+				ElderGrpc: ElderGrpcConfig{
+					Endpoints: []string{"elder-backup.example.com:443"},
+					TLS: ElderGrpcTLSConfig{
+						Enabled:  true,
+						CAFile:   "/etc/elder/ca.pem",
+						CertFile: "/etc/elder/client.pem",
+						KeyFile:  "/etc/elder/client-key.pem",
+					},
+				},
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+			},
+			wantErr: false,
+		},
+		{
+			name: "elder grpc client certificate without key",
+			config: Config{
+				ElderGrpcEndpoint: "elder.example.com:443",
+				ElderGrpc: ElderGrpcConfig{
+					TLS: ElderGrpcTLSConfig{
+						Enabled:  true,
+						CertFile: "/etc/elder/client.pem",
+					},
+				},
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+			},
+			wantErr: true,
+		},
+		{
+			name: "otlp tracing",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+				Tracing: TracingConfig{
+					Exporter:    TracingExporterOTLP,
+					Endpoint:    "localhost:4317",
+					Insecure:    true,
+					SampleRatio: 0.1,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid tracing exporter",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+				Tracing: TracingConfig{
+					Exporter: "jaeger",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid tracing sample ratio",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+				Tracing: TracingConfig{
+					Exporter:    TracingExporterStdout,
+					SampleRatio: 2,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "health minimum elder balance",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+				Health: HealthConfig{
+					MinElderBalance: "1000000",
+					ElderDenom:      "uelder",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "health minimum elder balance without denom",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+				Health: HealthConfig{
+					MinElderBalance: "1000000",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid health minimum elder balance",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+				Health: HealthConfig{
+					MinElderBalance: "1.5",
+					ElderDenom:      "uelder",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative shutdown timeout",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir:     "/tmp/keystore",
+				ShutdownTimeout: -time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "sponsor key",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+						SponsorKey:          "relayer",
+						SponsorPolicy: SponsorPolicyConfig{
+							AllowedContracts: []string{"0x5FbDB2315678afecb367f032d93F642f64180aa3"},
+							MaxDailyTxs:      1000,
+						},
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+			},
+			wantErr: false,
+		},
+		{
+			name: "sponsor policy without sponsor key",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+						SponsorPolicy: SponsorPolicyConfig{
+							MaxDailyTxs: 1000,
+						},
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid sponsor policy address",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+						SponsorKey:          "relayer",
+						SponsorPolicy: SponsorPolicyConfig{
+							AllowedSenders: []string{"0x1234"},
+						},
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+			},
+			wantErr: true,
+		},
+		{
+			name: "sponsor fee budget without local signing",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+						SponsorKey:          "relayer",
+						SponsorPolicy:       SponsorPolicyConfig{MaxDailyElderFee: 50000000},
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+			},
+			wantErr: true,
+		},
+		{
+			name: "sponsor fee budget with local signing",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				ElderTx:           ElderTxConfig{LocalSigning: true, GasPrice: "0.025uelder"},
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+						SponsorKey:          "relayer",
+						SponsorPolicy:       SponsorPolicyConfig{MaxDailyElderFee: 50000000},
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+			},
+			wantErr: false,
+		},
+		{
+			name: "elder batch without local signing",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				ElderTx:           ElderTxConfig{GasPrice: "0.025uelder"},
+				ElderBatch:        ElderBatchConfig{MaxSize: 20},
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+			},
+			wantErr: true,
+		},
+		{
+			name: "elder batch without gas price",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				ElderTx:           ElderTxConfig{LocalSigning: true},
+				ElderBatch:        ElderBatchConfig{MaxSize: 20},
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+			},
+			wantErr: true,
+		},
+		{
+			name: "elder batch with local signing",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				ElderTx:           ElderTxConfig{LocalSigning: true, GasPrice: "0.025uelder"},
+				ElderBatch:        ElderBatchConfig{MaxSize: 20},
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+			},
+			wantErr: false,
+		},
+		{
+			name: "simulation abi files without simulation",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+						Simulation:          SimulationConfig{ABIFiles: []string{"Token.json"}},
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid elder tx gas price",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				ElderTx:           ElderTxConfig{GasPrice: "0.025"},
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+			},
+			wantErr: true,
+		},
+		{
+			name: "admin api without token file",
+			config: Config{
+				ElderGrpcEndpoint: "localhost:50051",
+				RollAppConfigs: map[string]RollAppConfig{
+					"rollup1": {
+						RPC:                 "http://localhost:8545",
+						ElderRegistrationId: 1,
+					},
+				},
+				KeyStoreDir: "/tmp/keystore",
+				Admin: AdminConfig{
+					Listen: "unix:/run/elder-wrap/admin.sock",
+				},
+			},
+			wantErr: true,
+		},
 		{
 			name: "default port",
 			config: Config{
@@ -196,3 +719,104 @@ func TestNewConfig(t *testing.T) {
 			config.KeyStoreDir, validConfig.KeyStoreDir)
 	}
 }
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name: "valid config",
+			content: `elder_grpc_endpoint: localhost:9090
+key_store_dir: /tmp/keystore
+rollup_rpcs:
+  rollup1:
+    rpc: http://localhost:8545
+    elder_registration_id: 1
+`,
+			wantErr: false,
+		},
+		{
+			name: "invalid config",
+			content: `elder_grpc_endpoint: localhost:9090
+key_store_dir: /tmp/keystore
+`,
+			wantErr: true,
+		},
+		{
+			name:    "malformed yaml",
+			content: "rollup_rpcs: [",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := Load(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.ElderWrapPort != DefaultElderWrapPort {
+				t.Errorf("Load() did not set defaults, elder_wrap_port = %v", cfg.ElderWrapPort)
+			}
+		})
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	newConfig := func(logLevel string, rollApps ...string) *Config {
+		c := &Config{ElderGrpcEndpoint: "localhost:50051", KeyStoreDir: "/tmp/keystore", LogLevel: logLevel, RollAppConfigs: make(map[string]RollAppConfig)}
+		for _, name := range rollApps {
+			c.RollAppConfigs[name] = RollAppConfig{RPC: "http://localhost:8545", ElderRegistrationId: 1, HealthCheckInterval: time.Minute}
+		}
+		if err := c.validate(); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name string
+		base *Config
+		cfg  *Config
+	}{
+		{name: "no change", base: newConfig("info", "rollup1"), cfg: newConfig("info", "rollup1")},
+		{name: "rollapp added", base: newConfig("info", "rollup1"), cfg: newConfig("info", "rollup1", "rollup2")},
+		{name: "rollapp removed", base: newConfig("info", "rollup1", "rollup2"), cfg: newConfig("info", "rollup2")},
+		{name: "log level", base: newConfig("info", "rollup1"), cfg: newConfig("debug", "rollup1")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "overrides.yaml")
+			if err := DiffOverrides(tt.base, tt.cfg).Save(path); err != nil {
+				t.Fatal(err)
+			}
+			overrides, err := LoadOverrides(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := len(tt.base.RollAppConfigs)
+			merged, err := overrides.Apply(tt.base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(merged.RollAppConfigs, tt.cfg.RollAppConfigs) || merged.LogLevel != tt.cfg.LogLevel {
+				t.Errorf("Apply() = %v %s, want %v %s", merged.RollAppConfigs, merged.LogLevel, tt.cfg.RollAppConfigs, tt.cfg.LogLevel)
+			}
+			if len(tt.base.RollAppConfigs) != before {
+				t.Error("Apply() changed the rollapps of base")
+			}
+		})
+	}
+
+	overrides, err := LoadOverrides(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err != nil || !reflect.DeepEqual(overrides, &Overrides{}) {
+		t.Errorf("LoadOverrides() of a missing file = %v, %v, want no overrides", overrides, err)
+	}
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Overrides are the rollApp and log level changes made through the admin API. They are saved to
+// admin.overrides_file and merged over config.yaml on startup and on every reload.
+type Overrides struct {
+	// RollApps are the rollApps put through the admin API, they replace the entries of config.yaml
+	RollApps map[string]RollAppConfig `yaml:"rollup_rpcs,omitempty"`
+	// RemovedRollApps are the rollApps deleted through the admin API
+	RemovedRollApps []string `yaml:"removed_rollapps,omitempty"`
+	LogLevel        string   `yaml:"log_level,omitempty"`
+}
+
+// LoadOverrides reads an overrides file, a missing file has no overrides
+func LoadOverrides(path string) (*Overrides, error) {
+	file, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Overrides{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var o Overrides
+	if err := yaml.Unmarshal(file, &o); err != nil {
+		return nil, fmt.Errorf("invalid overrides file %s: %w", path, err)
+	}
+	return &o, nil
+}
+
+// Save writes the overrides to path, the file is replaced at once so a crash leaves the previous one
+func (o *Overrides) Save(path string) error {
+	content, err := yaml.Marshal(o)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Apply returns a copy of cfg with the overrides merged and validated
+func (o *Overrides) Apply(cfg *Config) (*Config, error) {
+	merged := *cfg
+	merged.RollAppConfigs = make(map[string]RollAppConfig, len(cfg.RollAppConfigs)+len(o.RollApps))
+	for name, rollAppConfig := range cfg.RollAppConfigs {
+		merged.RollAppConfigs[name] = rollAppConfig
+	}
+	for name, rollAppConfig := range o.RollApps {
+		merged.RollAppConfigs[name] = rollAppConfig
+	}
+	for _, name := range o.RemovedRollApps {
+		delete(merged.RollAppConfigs, name)
+	}
+	if o.LogLevel != "" {
+		merged.LogLevel = o.LogLevel
+	}
+
+	if err := merged.validate(); err != nil {
+		return nil, fmt.Errorf("invalid overrides: %w", err)
+	}
+	return &merged, nil
+}
+
+// DiffOverrides returns the overrides turning the rollApps and the log level of base into those of cfg
+func DiffOverrides(base, cfg *Config) *Overrides {
+	o := &Overrides{}
+	for name, rollAppConfig := range cfg.RollAppConfigs {
+		if previous, ok := base.RollAppConfigs[name]; ok && reflect.DeepEqual(previous, rollAppConfig) {
+			continue
+		}
+		if o.RollApps == nil {
+			o.RollApps = make(map[string]RollAppConfig)
+		}
+		o.RollApps[name] = rollAppConfig
+	}
+	for name := range base.RollAppConfigs {
+		if _, ok := cfg.RollAppConfigs[name]; !ok {
+			o.RemovedRollApps = append(o.RemovedRollApps, name)
+		}
+	}
+	sort.Strings(o.RemovedRollApps)
+	if cfg.LogLevel != base.LogLevel {
+		o.LogLevel = cfg.LogLevel
+	}
+	return o
+}
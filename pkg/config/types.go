@@ -2,16 +2,107 @@ package config
 
 import (
 	"fmt"
+	"time"
 )
 
 const DefaultElderWrapPort = "8546"
 
+// DefaultShutdownTimeout is how long a shutdown waits for the in-flight requests and submissions
+const DefaultShutdownTimeout = 30 * time.Second
+
+const (
+	// UpstreamSelectionRoundRobin spreads calls evenly over the healthy rollApp RPCs
+	UpstreamSelectionRoundRobin = "round_robin"
+	// UpstreamSelectionLowestLatency sends calls to the healthy rollApp RPC with the lowest probe latency
+	UpstreamSelectionLowestLatency = "lowest_latency"
+
+	DefaultHealthCheckInterval = 10 * time.Second
+	DefaultMaxBlockLag         = 5
+)
+
+const (
+	DefaultElderKeepaliveTime    = 30 * time.Second
+	DefaultElderKeepaliveTimeout = 10 * time.Second
+	DefaultElderDialTimeout      = 10 * time.Second
+)
+
+const (
+	// DefaultElderBatchWindow is how long a message waits for more messages of the same key
+	DefaultElderBatchWindow = 50 * time.Millisecond
+	// DefaultElderTxGasMultiplier pads the simulated gas of the Elder transactions signed by elder-wrap
+	DefaultElderTxGasMultiplier = 2
+)
+
+const (
+	// DefaultTxPoolPriceBump is the minimum fee increase, in percent, to replace a queued transaction
+	DefaultTxPoolPriceBump = 10
+	// DefaultTxPoolMaxQueuedPerSender is the maximum number of queued transactions per sender
+	DefaultTxPoolMaxQueuedPerSender = 64
+	// DefaultTxPoolMaxQueued is the maximum number of queued transactions of a rollApp, all senders together
+	DefaultTxPoolMaxQueued = 4096
+)
+
+const (
+	// TracingExporterNone disables tracing
+	TracingExporterNone = "none"
+	// TracingExporterOTLP sends spans to an OpenTelemetry collector over OTLP gRPC
+	TracingExporterOTLP = "otlp"
+	// TracingExporterStdout prints spans to stdout, for local runs
+	TracingExporterStdout = "stdout"
+
+	DefaultTracingServiceName = "elder-wrap"
+)
+
+const (
+	// DefaultHealthTimeout bounds the readiness checks
+	DefaultHealthTimeout = 5 * time.Second
+	// DefaultHealthMaxBlockAge is how long Elder and the rollApps can go without a new block and stay ready
+	DefaultHealthMaxBlockAge = 5 * time.Minute
+)
+
+const (
+	KeyStoreTypePlain     = "plain"
+	KeyStoreTypeEncrypted = "encrypted"
+)
+
+const (
+	// SubmissionModeSync answers eth_sendRawTransaction once the transaction is in a rollApp block
+	SubmissionModeSync = "sync"
+	// SubmissionModeAsync answers eth_sendRawTransaction once the Elder transaction passed CheckTx
+	SubmissionModeAsync = "async"
+)
+
 type Config struct {
-	ElderGrpcEndpoint string                   `yaml:"elder_grpc_endpoint"`
-	ElderWrapPort     string                   `yaml:"elder_wrap_port"`
-	RollAppConfigs    map[string]RollAppConfig `yaml:"rollup_rpcs"`
-	KeyStoreDir       string                   `yaml:"key_store_dir"`
-	LogLevel          string                   `yaml:"log_level"`
+	ElderGrpcEndpoint    string                   `yaml:"elder_grpc_endpoint"`
+	ElderGrpc            ElderGrpcConfig          `yaml:"elder_grpc"`
+	ElderTx              ElderTxConfig            `yaml:"elder_tx"`
+	ElderBatch           ElderBatchConfig         `yaml:"elder_batch"`
+	ElderWrapPort        string                   `yaml:"elder_wrap_port"`
+	RollAppConfigs       map[string]RollAppConfig `yaml:"rollup_rpcs"`
+	KeyStoreDir          string                   `yaml:"key_store_dir"`
+	KeyStoreType         string                   `yaml:"key_store_type"`
+	KeyStorePasswordFile string                   `yaml:"key_store_password_file"`
+	LogLevel             string                   `yaml:"log_level"`
+	ShutdownTimeout      time.Duration            `yaml:"shutdown_timeout"`
+	TxPool               TxPoolConfig             `yaml:"tx_pool"`
+	// JournalDir keeps the submissions on disk to recover them after a restart, disabled when empty
+	JournalDir string        `yaml:"journal_dir"`
+	Tracing    TracingConfig `yaml:"tracing"`
+	Health     HealthConfig  `yaml:"health"`
+	Admin      AdminConfig   `yaml:"admin"`
+	// PolicyFile holds the rules evaluated before the transactions are submitted to Elder, none when empty
+	PolicyFile string `yaml:"policy_file"`
+}
+
+// AdminConfig configures the admin API, disabled when Listen is empty
+type AdminConfig struct {
+	// Listen is a host:port, or unix:<path> for a unix socket
+	Listen string `yaml:"listen"`
+	// TokenFile holds the bearer token of the admin API
+	TokenFile string `yaml:"token_file"`
+	// OverridesFile saves the rollApp and log level changes made through the admin API, they are kept
+	// in memory only when it is empty
+	OverridesFile string `yaml:"overrides_file"`
 }
 
 func (c *Config) validate() error {
@@ -21,21 +112,195 @@ func (c *Config) validate() error {
 	if c.ElderWrapPort == "" {
 		c.ElderWrapPort = DefaultElderWrapPort
 	}
+	if c.ShutdownTimeout < 0 {
+		return fmt.Errorf("shutdown_timeout can't be negative")
+	}
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = DefaultShutdownTimeout
+	}
 	if len(c.RollAppConfigs) == 0 {
 		return fmt.Errorf("rollup_rpcs is required")
 	}
-	for _, r := range c.RollAppConfigs {
+	for name, r := range c.RollAppConfigs {
 		if err := r.validate(); err != nil {
 			return err
 		}
+		// The Elder fees are only known when elder-wrap signs every Elder transaction
+		if r.SponsorPolicy.MaxDailyElderFee > 0 && !c.ElderTx.LocalSigning {
+			return fmt.Errorf("sponsor_policy.max_daily_elder_fee requires elder_tx.local_signing")
+		}
+		// validate sets defaults on its copy
+		c.RollAppConfigs[name] = r
 	}
 	if c.KeyStoreDir == "" {
 		return fmt.Errorf("key_store_dir is required")
 	}
+	switch c.KeyStoreType {
+	case "":
+		c.KeyStoreType = KeyStoreTypePlain
+	case KeyStoreTypePlain, KeyStoreTypeEncrypted:
+	default:
+		return fmt.Errorf("key_store_type must be %s or %s", KeyStoreTypePlain, KeyStoreTypeEncrypted)
+	}
+	if err := c.TxPool.validate(); err != nil {
+		return err
+	}
+	if err := c.ElderGrpc.validate(); err != nil {
+		return err
+	}
+	if err := c.ElderTx.validate(); err != nil {
+		return err
+	}
+	if err := c.ElderBatch.validate(); err != nil {
+		return err
+	}
+	// The batches are signed in elder-wrap, the Elder client helper only sends single messages
+	if c.ElderBatch.Enabled() && (!c.ElderTx.LocalSigning || c.ElderTx.GasPrice == "") {
+		return fmt.Errorf("elder_batch.max_size above 1 requires elder_tx.local_signing and elder_tx.gas_price")
+	}
+	if err := c.Tracing.validate(); err != nil {
+		return err
+	}
+	if err := c.Health.validate(); err != nil {
+		return err
+	}
+	if c.Admin.Listen != "" && c.Admin.TokenFile == "" {
+		return fmt.Errorf("admin.token_file is required with admin.listen")
+	}
 	return nil
 }
 
+// Validate checks a config built at runtime and sets its defaults
+func (c *Config) Validate() error {
+	return c.validate()
+}
+
 type RollAppConfig struct {
-	RPC                 string `yaml:"rpc"`
-	ElderRegistrationId uint64 `yaml:"elder_registration_id"`
+	RPC string `yaml:"rpc"`
+	// RPCs are more endpoints of the rollApp, calls fail over between rpc and rpcs
+	RPCs []string `yaml:"rpcs,omitempty"`
+	// UpstreamSelection is either round_robin (default) or lowest_latency
+	UpstreamSelection string `yaml:"upstream_selection"`
+	// HealthCheckInterval is how often the rollApp RPCs are probed
+	HealthCheckInterval time.Duration `yaml:"health_check_interval"`
+	// MaxBlockLag is how many blocks a rollApp RPC can be behind the others and stay in rotation
+	MaxBlockLag uint64 `yaml:"max_block_lag"`
+	// WS is the rollApp websocket endpoint subscriptions are proxied to, optional
+	WS string `yaml:"ws"`
+	// WSOrigins are the browser origins allowed to open websockets, "*" allows any. Cross-origin
+	// websockets are rejected when empty.
+	WSOrigins           []string `yaml:"ws_origins,omitempty"`
+	ElderRegistrationId uint64   `yaml:"elder_registration_id"`
+	// NodeSigning serves eth_accounts, eth_sendTransaction and the signing methods with the keystore keys
+	NodeSigning bool `yaml:"node_signing"`
+	// SubmissionMode is either sync (default) or async
+	SubmissionMode string `yaml:"submission_mode"`
+	// SponsorKey is the alias of the key paying the Elder fees of senders without a key in the keystore
+	SponsorKey    string              `yaml:"sponsor_key"`
+	SponsorPolicy SponsorPolicyConfig `yaml:"sponsor_policy"`
+	Simulation    SimulationConfig    `yaml:"simulation"`
+}
+
+// SimulationConfig replays transactions against the rollApp before they are submitted to Elder
+type SimulationConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// ABIFiles are contract ABIs, or Hardhat and Foundry artifacts, decoding the custom errors of reverts
+	ABIFiles []string `yaml:"abi_files,omitempty"`
+}
+
+// SponsorPolicyConfig limits the transactions the sponsor key pays for. A transaction is sponsored
+// when its sender or its contract is allowed, any transaction when both lists are empty.
+type SponsorPolicyConfig struct {
+	AllowedSenders   []string `yaml:"allowed_senders,omitempty"`
+	AllowedContracts []string `yaml:"allowed_contracts,omitempty"`
+	// MaxDailyTxs is the number of transactions sponsored per UTC day, unlimited when zero
+	MaxDailyTxs uint64 `yaml:"max_daily_txs"`
+	// MaxDailyElderFee is the Elder fee the sponsor key pays per UTC day, in the denom of elder_tx.gas_price,
+	// unlimited when zero
+	MaxDailyElderFee uint64 `yaml:"max_daily_elder_fee"`
+}
+
+// ElderTxConfig configures the Elder transactions signed by elder-wrap, with LocalSigning
+type ElderTxConfig struct {
+	// LocalSigning signs the Elder transactions in elder-wrap with locally tracked account sequences,
+	// instead of the Elder client helper. It is opt-in: without it the helper queries the sequence before
+	// every transaction and the transactions of a key are broadcast one at a time. It is required by batching.
+	LocalSigning bool `yaml:"local_signing"`
+	// GasPrice is the fee per unit of gas, e.g. 0.025uelder, no fee when empty. It is required by batching.
+	GasPrice      string  `yaml:"gas_price"`
+	GasMultiplier float64 `yaml:"gas_multiplier"`
+}
+
+// ElderBatchConfig packs the rollApp transactions submitted with the same key into multi-message
+// Elder transactions signed in elder-wrap, disabled when MaxSize is below 2
+type ElderBatchConfig struct {
+	// MaxSize is the most messages in an Elder transaction
+	MaxSize int           `yaml:"max_size"`
+	Window  time.Duration `yaml:"window"`
+}
+
+// Enabled returns whether the rollApp transactions are batched
+func (b *ElderBatchConfig) Enabled() bool {
+	return b.MaxSize > 1
+}
+
+// TxPoolConfig configures the pool holding transactions whose nonce is ahead of the sender's next nonce
+type TxPoolConfig struct {
+	// PersistDir keeps the queued transactions of each rollApp across restarts, disabled when empty
+	PersistDir         string `yaml:"persist_dir"`
+	PriceBump          uint64 `yaml:"price_bump"`
+	MaxQueuedPerSender int    `yaml:"max_queued_per_sender"`
+	// MaxQueued caps the queued transactions of a rollApp, throwaway senders can't fill the pool past it
+	MaxQueued int `yaml:"max_queued"`
+}
+
+// ElderGrpcConfig configures the connections to the Elder gRPC endpoints
+type ElderGrpcConfig struct {
+	// Endpoints are more Elder gRPC endpoints, calls fail over between elder_grpc_endpoint and them
+	Endpoints []string           `yaml:"endpoints"`
+	TLS       ElderGrpcTLSConfig `yaml:"tls"`
+	// Metadata is sent with every call, e.g. auth headers of a gateway in front of Elder
+	Metadata map[string]string `yaml:"metadata"`
+	// AuthTokenFile holds a token sent as "authorization: Bearer <token>" with every call
+	AuthTokenFile    string        `yaml:"auth_token_file"`
+	KeepaliveTime    time.Duration `yaml:"keepalive_time"`
+	KeepaliveTimeout time.Duration `yaml:"keepalive_timeout"`
+	DialTimeout      time.Duration `yaml:"dial_timeout"`
+	// CallTimeout bounds every call without a deadline, disabled when zero
+	CallTimeout time.Duration `yaml:"call_timeout"`
+}
+
+type ElderGrpcTLSConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// CAFile verifies the server certificate instead of the system roots
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile are the client certificate for mutual TLS
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	ServerName string `yaml:"server_name"`
+}
+
+// TracingConfig configures the export of OpenTelemetry traces
+type TracingConfig struct {
+	// Exporter is none (default), otlp or stdout
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the OTLP gRPC collector address, OTEL_EXPORTER_OTLP_ENDPOINT is used when empty
+	Endpoint string `yaml:"endpoint"`
+	// Insecure disables TLS to the collector
+	Insecure bool `yaml:"insecure"`
+	// Headers are sent with every export, e.g. the API key of a tracing backend
+	Headers map[string]string `yaml:"headers"`
+	// SampleRatio is the share of traces started by elder-wrap which are recorded, 1 when unset
+	SampleRatio float64 `yaml:"sample_ratio"`
+	ServiceName string  `yaml:"service_name"`
+}
+
+// HealthConfig configures the readiness checks served on /readyz
+type HealthConfig struct {
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxBlockAge is how old the latest Elder block, and the last new block of a rollApp, can be
+	MaxBlockAge time.Duration `yaml:"max_block_age"`
+	// MinElderBalance is the balance, in ElderDenom, every key needs on Elder. The check is skipped when empty.
+	MinElderBalance string `yaml:"min_elder_balance"`
+	ElderDenom      string `yaml:"elder_denom"`
 }
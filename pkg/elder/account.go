@@ -0,0 +1,140 @@
+package elder
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/0xElder/elder-wrap/pkg/tracing"
+	"github.com/0xElder/elder/utils"
+	"github.com/pkg/errors"
+)
+
+// sequenceMismatchPattern matches the error of a transaction signed with another sequence than its account's
+var sequenceMismatchPattern = regexp.MustCompile(`account sequence mismatch, expected (\d+), got (\d+)`)
+
+// account is the account number and the next sequence of an Elder address
+type account struct {
+	number   uint64
+	sequence uint64
+	// synced is false until the account is queried, and again after a broadcast left the sequence
+	// unknown. The number stays valid.
+	synced bool
+}
+
+// accountManager caches the Elder accounts of the keys. The account number never changes, the sequence
+// is counted locally from the transactions accepted in CheckTx, so a key can have several
+// transactions in the mempool without waiting for a block.
+type accountManager struct {
+	query func(ctx context.Context, elderAddress string) (number, sequence uint64, err error)
+
+	mu       sync.Mutex
+	accounts map[string]*account
+}
+
+func newAccountManager(query func(ctx context.Context, elderAddress string) (uint64, uint64, error)) *accountManager {
+	return &accountManager{query: query, accounts: make(map[string]*account)}
+}
+
+// get returns the account of an Elder address, querying it when it is not synced
+func (m *accountManager) get(ctx context.Context, elderAddress string) (account, error) {
+	m.mu.Lock()
+	cached, ok := m.accounts[elderAddress]
+	if ok && cached.synced {
+		defer m.mu.Unlock()
+		return *cached, nil
+	}
+	m.mu.Unlock()
+
+	number, sequence, err := m.query(ctx, elderAddress)
+	if err != nil {
+		return account{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	synced := &account{number: number, sequence: sequence, synced: true}
+	m.accounts[elderAddress] = synced
+	return *synced, nil
+}
+
+// number returns the account number of an Elder address, the account is only queried when it is not cached
+func (m *accountManager) number(ctx context.Context, elderAddress string) (uint64, error) {
+	m.mu.Lock()
+	cached, ok := m.accounts[elderAddress]
+	if ok {
+		defer m.mu.Unlock()
+		return cached.number, nil
+	}
+	m.mu.Unlock()
+
+	synced, err := m.get(ctx, elderAddress)
+	if err != nil {
+		return 0, err
+	}
+	return synced.number, nil
+}
+
+// accepted moves the sequence of an Elder address past a transaction accepted in CheckTx
+func (m *accountManager) accepted(elderAddress string, sequence uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cached, ok := m.accounts[elderAddress]; ok && cached.sequence <= sequence {
+		cached.sequence = sequence + 1
+	}
+}
+
+// resync sets the sequence of an Elder address from a sequence mismatch error and returns true. A
+// transaction rejected in CheckTx did not use its sequence, after any other error the account is
+// queried again.
+func (m *accountManager) resync(elderAddress string, err error) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cached, ok := m.accounts[elderAddress]
+	if !ok {
+		return false
+	}
+	if match := sequenceMismatchPattern.FindStringSubmatch(err.Error()); match != nil {
+		if expected, parseErr := strconv.ParseUint(match[1], 10, 64); parseErr == nil {
+			cached.sequence = expected
+			return true
+		}
+	}
+	var rejected *CheckTxError
+	if !errors.As(err, &rejected) {
+		cached.synced = false
+	}
+	return false
+}
+
+// forget marks the sequence of an Elder address to be queried again, after a transaction signed
+// outside of the account manager. The account number is kept.
+func (m *accountManager) forget(elderAddress string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cached, ok := m.accounts[elderAddress]; ok {
+		cached.synced = false
+	}
+}
+
+// queryAccount queries the account number and sequence of an Elder address
+func (e *ElderClient) queryAccount(ctx context.Context, elderAddress string) (_ uint64, _ uint64, err error) {
+	_, span := tracing.Start(ctx, "QueryElderAccount", tracing.ElderSenderKey.String(elderAddress))
+	defer func() { tracing.End(span, err) }()
+
+	number, sequence, err := utils.QueryElderAccount(utils.AuthClient(e.Conn()), elderAddress)
+	if err != nil {
+		e.logger.Error(ctx, "failed to query elder account", "address", elderAddress, "error", err)
+		return 0, 0, errors.Wrap(err, "failed to query elder account")
+	}
+	return number, sequence, nil
+}
+
+// AccountNumber returns the account number of an Elder address, queried once
+func (e *ElderClient) AccountNumber(ctx context.Context, elderAddress string) (uint64, error) {
+	return e.accounts.number(ctx, elderAddress)
+}
@@ -0,0 +1,144 @@
+package elder
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAccountManager_sequence(t *testing.T) {
+	const address = "elder1a"
+
+	tests := []struct {
+		name string
+		// run moves the account after it was queried at sequence 5
+		run          func(m *accountManager) bool
+		wantResync   bool
+		wantSequence uint64
+		wantQueries  int
+	}{
+		{
+			name: "accepted transactions move the sequence without queries",
+			run: func(m *accountManager) bool {
+				m.accepted(address, 5)
+				m.accepted(address, 6)
+				return false
+			},
+			wantSequence: 7,
+			wantQueries:  1,
+		},
+		{
+			name: "sequence mismatch sets the expected sequence",
+			run: func(m *accountManager) bool {
+				return m.resync(address, &CheckTxError{Code: 32, RawLog: "account sequence mismatch, expected 9, got 5: incorrect account sequence"})
+			},
+			wantResync:   true,
+			wantSequence: 9,
+			wantQueries:  1,
+		},
+		{
+			name: "rejected transaction keeps the sequence",
+			run: func(m *accountManager) bool {
+				return m.resync(address, &CheckTxError{Code: 5, RawLog: "insufficient funds"})
+			},
+			wantSequence: 5,
+			wantQueries:  1,
+		},
+		{
+			name: "rejected simulation keeps the sequence",
+			run: func(m *accountManager) bool {
+				return m.resync(address, simulationError(status.Error(codes.Unknown, "insufficient funds")))
+			},
+			wantSequence: 5,
+			wantQueries:  1,
+		},
+		{
+			name: "unreachable simulation queries the account again",
+			run: func(m *accountManager) bool {
+				return m.resync(address, simulationError(status.Error(codes.Unavailable, "connection refused")))
+			},
+			wantSequence: 5,
+			wantQueries:  2,
+		},
+		{
+			name: "failed broadcast queries the account again",
+			run: func(m *accountManager) bool {
+				return m.resync(address, errors.New("connection refused"))
+			},
+			wantSequence: 5,
+			wantQueries:  2,
+		},
+		{
+			name: "forgotten account is queried again",
+			run: func(m *accountManager) bool {
+				m.accepted(address, 5)
+				m.forget(address)
+				return false
+			},
+			wantSequence: 5,
+			wantQueries:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries := 0
+			m := newAccountManager(func(ctx context.Context, elderAddress string) (uint64, uint64, error) {
+				queries++
+				return 3, 5, nil
+			})
+			if _, err := m.get(context.Background(), address); err != nil {
+				t.Fatal(err)
+			}
+
+			if resync := tt.run(m); resync != tt.wantResync {
+				t.Errorf("resync() = %v, want %v", resync, tt.wantResync)
+			}
+			got, err := m.get(context.Background(), address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.number != 3 || got.sequence != tt.wantSequence {
+				t.Errorf("get() = number %d sequence %d, want number 3 sequence %d", got.number, got.sequence, tt.wantSequence)
+			}
+			if queries != tt.wantQueries {
+				t.Errorf("queried %d times, want %d", queries, tt.wantQueries)
+			}
+		})
+	}
+}
+
+func TestAccountManager_number(t *testing.T) {
+	const address = "elder1a"
+
+	queries := 0
+	m := newAccountManager(func(ctx context.Context, elderAddress string) (uint64, uint64, error) {
+		queries++
+		return 3, 5, nil
+	})
+
+	// The Elder client helper signs each transaction, its broadcasts only forget the sequence
+	for i := 0; i < 3; i++ {
+		number, err := m.number(context.Background(), address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if number != 3 {
+			t.Errorf("number() = %d, want 3", number)
+		}
+		m.forget(address)
+	}
+	if queries != 1 {
+		t.Errorf("queried %d times, want 1", queries)
+	}
+
+	if _, err := m.get(context.Background(), address); err != nil {
+		t.Fatal(err)
+	}
+	if queries != 2 {
+		t.Errorf("get() after forget() queried %d times, want 2", queries)
+	}
+}
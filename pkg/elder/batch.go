@@ -0,0 +1,192 @@
+package elder
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/0xElder/elder-wrap/pkg/keystore"
+	"github.com/0xElder/elder-wrap/pkg/metrics"
+	"github.com/0xElder/elder/x/router/types"
+	"github.com/pkg/errors"
+)
+
+// batcher collects the messages submitted with the same Elder key and broadcasts them together once
+// the window since the first message elapsed or the batch is full. The batches of a key are broadcast
+// one after the other, in the order their messages were enqueued.
+type batcher struct {
+	cfg config.ElderBatchConfig
+	// send broadcasts a batch and delivers the result to each of its messages
+	send func(b *batch)
+
+	mu      sync.Mutex
+	pending map[string]*batch
+	// last is the latest batch of each key handed to send, the next batch of the key waits for it
+	last map[string]*batch
+}
+
+// batch is the messages of an Elder key waiting to be broadcast
+type batch struct {
+	ctx   context.Context
+	key   *keystore.Key
+	items []*batchItem
+	timer *time.Timer
+	// previous is closed once the previous batch of the key was broadcast, nil when there is none
+	previous chan struct{}
+	// sent is closed once the batch was broadcast
+	sent chan struct{}
+}
+
+type batchItem struct {
+	msg  *types.MsgSubmitRollTx
+	done chan batchResult
+}
+
+type batchResult struct {
+	broadcast Broadcast
+	err       error
+}
+
+func newBatcher(cfg config.ElderBatchConfig, send func(b *batch)) *batcher {
+	return &batcher{cfg: cfg, send: send, pending: make(map[string]*batch), last: make(map[string]*batch)}
+}
+
+// enqueue adds msg to the batch of its key and returns the function waiting for the batch to be
+// broadcast. The wait does not end with ctx, the message may be broadcast with the rest of its batch
+// after ctx is done.
+func (b *batcher) enqueue(ctx context.Context, key *keystore.Key, msg *types.MsgSubmitRollTx) func() (Broadcast, error) {
+	item := &batchItem{msg: msg, done: make(chan batchResult, 1)}
+
+	b.mu.Lock()
+	pending, ok := b.pending[key.ElderAddress]
+	if !ok {
+		// The batch keeps the trace of its first message, not its cancellation
+		pending = &batch{ctx: context.WithoutCancel(ctx), key: key, sent: make(chan struct{})}
+		pending.timer = time.AfterFunc(b.cfg.Window, func() { b.flush(key.ElderAddress, pending) })
+		b.pending[key.ElderAddress] = pending
+	}
+	pending.items = append(pending.items, item)
+	full := len(pending.items) >= b.cfg.MaxSize
+	if full {
+		pending.timer.Stop()
+		b.dequeue(key.ElderAddress, pending)
+	}
+	b.mu.Unlock()
+
+	if full {
+		go b.run(key.ElderAddress, pending)
+	}
+	return func() (Broadcast, error) {
+		result := <-item.done
+		return result.broadcast, result.err
+	}
+}
+
+// flush sends the batch of an Elder key when its window elapsed, unless it was sent when it filled up
+func (b *batcher) flush(elderAddress string, pending *batch) {
+	b.mu.Lock()
+	if b.pending[elderAddress] != pending {
+		b.mu.Unlock()
+		return
+	}
+	b.dequeue(elderAddress, pending)
+	b.mu.Unlock()
+
+	b.run(elderAddress, pending)
+}
+
+// dequeue closes the batch of an Elder key to new messages and orders it after the previous batch of
+// the key, must be called with b.mu held
+func (b *batcher) dequeue(elderAddress string, pending *batch) {
+	delete(b.pending, elderAddress)
+	if previous, ok := b.last[elderAddress]; ok {
+		pending.previous = previous.sent
+	}
+	b.last[elderAddress] = pending
+}
+
+// run sends a batch once the previous batch of its key was broadcast
+func (b *batcher) run(elderAddress string, pending *batch) {
+	if pending.previous != nil {
+		<-pending.previous
+	}
+	b.send(pending)
+
+	b.mu.Lock()
+	if b.last[elderAddress] == pending {
+		delete(b.last, elderAddress)
+	}
+	b.mu.Unlock()
+	close(pending.sent)
+}
+
+// ConfigureTxs sets how elder-wrap signs Elder transactions and, when batching is enabled, packs the
+// messages submitted with the same key within the batch window into multi-message Elder transactions
+func (e *ElderClient) ConfigureTxs(txCfg config.ElderTxConfig, batchCfg config.ElderBatchConfig) {
+	e.txCfg = txCfg
+	if txCfg.LocalSigning {
+		e.logger.Info(nil, "Signing Elder transactions with local account sequences")
+	}
+	if !batchCfg.Enabled() {
+		return
+	}
+	e.batcher = newBatcher(batchCfg, e.sendBatch)
+	e.logger.Info(nil, "Batching Elder transactions", "maxSize", batchCfg.MaxSize, "window", batchCfg.Window)
+}
+
+// Enqueue submits msg to Elder signed with key and returns the function waiting for the Elder
+// transaction holding msg. The messages of a key reach Elder in the order they are enqueued:
+// when batching is enabled msg joins the next batch of key and Enqueue returns without waiting for
+// its broadcast, otherwise msg is broadcast before Enqueue returns.
+func (e *ElderClient) Enqueue(ctx context.Context, key *keystore.Key, msg *types.MsgSubmitRollTx) func() (Broadcast, error) {
+	if e.batcher == nil {
+		broadcast, err := e.BroadCastTxn(ctx, key, msg)
+		return func() (Broadcast, error) { return broadcast, err }
+	}
+	return e.batcher.enqueue(ctx, key, msg)
+}
+
+// sendBatch broadcasts a batch as one Elder transaction, its fee is split between the messages. When
+// Elder rejects the transaction, its messages are broadcast one by one, so a failing message does not
+// fail the others.
+func (e *ElderClient) sendBatch(b *batch) {
+	metrics.ElderBatchSize.Observe(float64(len(b.items)))
+
+	if len(b.items) == 1 {
+		broadcast, err := e.BroadCastTxn(b.ctx, b.key, b.items[0].msg)
+		b.items[0].done <- batchResult{broadcast: broadcast, err: err}
+		return
+	}
+
+	msgs := make([]*types.MsgSubmitRollTx, 0, len(b.items))
+	for _, item := range b.items {
+		msgs = append(msgs, item.msg)
+	}
+	broadcast, err := e.BroadCastTxns(b.ctx, b.key, msgs)
+
+	var rejected *CheckTxError
+	if errors.As(err, &rejected) {
+		e.logger.Warn(b.ctx, "Elder rejected batch, broadcasting its messages one by one", "key", b.key.ElderAddress, "size", len(msgs), "error", err)
+		for _, item := range b.items {
+			broadcast, err := e.BroadCastTxn(b.ctx, b.key, item.msg)
+			item.done <- batchResult{broadcast: broadcast, err: err}
+		}
+		return
+	}
+	for i, fee := range splitFee(broadcast.Fee, len(b.items)) {
+		b.items[i].done <- batchResult{broadcast: Broadcast{ElderTxHash: broadcast.ElderTxHash, Fee: fee}, err: err}
+	}
+}
+
+// splitFee splits the fee of a batch between its n messages, the first messages pay the remainder
+func splitFee(fee uint64, n int) []uint64 {
+	fees := make([]uint64, n)
+	for i := range fees {
+		fees[i] = fee / uint64(n)
+		if uint64(i) < fee%uint64(n) {
+			fees[i]++
+		}
+	}
+	return fees
+}
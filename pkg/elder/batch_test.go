@@ -0,0 +1,164 @@
+package elder
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/0xElder/elder-wrap/pkg/keystore"
+	"github.com/0xElder/elder/x/router/types"
+)
+
+func TestBatcher_enqueue(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.ElderBatchConfig
+		keys     []string
+		wantSize []int
+	}{
+		{
+			name:     "full batch is sent before the window",
+			cfg:      config.ElderBatchConfig{MaxSize: 3, Window: time.Hour},
+			keys:     []string{"elder1a", "elder1a", "elder1a"},
+			wantSize: []int{3},
+		},
+		{
+			name:     "window sends a partial batch",
+			cfg:      config.ElderBatchConfig{MaxSize: 10, Window: 10 * time.Millisecond},
+			keys:     []string{"elder1a", "elder1a"},
+			wantSize: []int{2},
+		},
+		{
+			name:     "batches by key",
+			cfg:      config.ElderBatchConfig{MaxSize: 10, Window: 10 * time.Millisecond},
+			keys:     []string{"elder1a", "elder1b", "elder1a"},
+			wantSize: []int{1, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var sizes []int
+			b := newBatcher(tt.cfg, func(sent *batch) {
+				mu.Lock()
+				sizes = append(sizes, len(sent.items))
+				mu.Unlock()
+				for _, item := range sent.items {
+					item.done <- batchResult{broadcast: Broadcast{ElderTxHash: fmt.Sprintf("%s-%d", sent.key.ElderAddress, len(sent.items))}}
+				}
+			})
+
+			var wg sync.WaitGroup
+			hashes := make([]string, len(tt.keys))
+			for i, elderAddress := range tt.keys {
+				wg.Add(1)
+				go func(i int, elderAddress string) {
+					defer wg.Done()
+					broadcast, err := b.enqueue(context.Background(), &keystore.Key{ElderAddress: elderAddress}, &types.MsgSubmitRollTx{RollId: uint64(i)})()
+					if err != nil {
+						t.Error(err)
+					}
+					hashes[i] = broadcast.ElderTxHash
+				}(i, elderAddress)
+			}
+			wg.Wait()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(sizes) != len(tt.wantSize) {
+				t.Fatalf("sent %d batches %v, want %v", len(sizes), sizes, tt.wantSize)
+			}
+			total := 0
+			for _, size := range sizes {
+				total += size
+			}
+			if total != len(tt.keys) {
+				t.Errorf("sent %d messages, want %d", total, len(tt.keys))
+			}
+			for i, elderAddress := range tt.keys {
+				if len(hashes[i]) <= len(elderAddress) || hashes[i][:len(elderAddress)] != elderAddress {
+					t.Errorf("enqueue() %d = %s, want the hash of the %s batch", i, hashes[i], elderAddress)
+				}
+			}
+		})
+	}
+}
+
+func TestBatcher_enqueueOrder(t *testing.T) {
+	var mu sync.Mutex
+	var sent [][]uint64
+	b := newBatcher(config.ElderBatchConfig{MaxSize: 2, Window: time.Millisecond}, func(sending *batch) {
+		if sending.items[0].msg.RollId == 0 {
+			// The first batch is slow, the next ones must not overtake it
+			time.Sleep(20 * time.Millisecond)
+		}
+		mu.Lock()
+		ids := make([]uint64, 0, len(sending.items))
+		for _, item := range sending.items {
+			ids = append(ids, item.msg.RollId)
+		}
+		sent = append(sent, ids)
+		mu.Unlock()
+		for _, item := range sending.items {
+			item.done <- batchResult{broadcast: Broadcast{ElderTxHash: fmt.Sprint(ids)}}
+		}
+	})
+
+	// The messages are enqueued without waiting for the broadcasts
+	key := &keystore.Key{ElderAddress: "elder1a"}
+	waits := make([]func() (Broadcast, error), 5)
+	for i := range waits {
+		waits[i] = b.enqueue(context.Background(), key, &types.MsgSubmitRollTx{RollId: uint64(i)})
+	}
+	for i, wait := range waits {
+		if _, err := wait(); err != nil {
+			t.Errorf("enqueue() %d error = %v", i, err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := fmt.Sprint(sent); got != "[[0 1] [2 3] [4]]" {
+		t.Errorf("sent batches %s, want [[0 1] [2 3] [4]]", got)
+	}
+
+	// The last batch is forgotten once sent, shortly after its messages got their result
+	deadline := time.Now().Add(time.Second)
+	for {
+		b.mu.Lock()
+		remaining := len(b.last)
+		b.mu.Unlock()
+		if remaining == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("batcher keeps %d sent batches, want 0", remaining)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSplitFee(t *testing.T) {
+	tests := []struct {
+		name string
+		fee  uint64
+		n    int
+		want string
+	}{
+		{name: "even", fee: 900, n: 3, want: "[300 300 300]"},
+		{name: "remainder", fee: 1001, n: 3, want: "[334 334 333]"},
+		{name: "unknown fee", fee: 0, n: 2, want: "[0 0]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fmt.Sprint(splitFee(tt.fee, tt.n)); got != tt.want {
+				t.Errorf("splitFee(%d, %d) = %s, want %s", tt.fee, tt.n, got, tt.want)
+			}
+		})
+	}
+}
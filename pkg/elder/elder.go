@@ -1,60 +1,204 @@
 package elder
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
 
+	"github.com/0xElder/elder-wrap/pkg/config"
 	"github.com/0xElder/elder-wrap/pkg/keystore"
 	"github.com/0xElder/elder-wrap/pkg/logging"
+	"github.com/0xElder/elder-wrap/pkg/tracing"
 	"github.com/0xElder/elder/utils"
 	"github.com/0xElder/elder/x/router/types"
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/connectivity"
 )
 
+// buildAndBroadcast signs and broadcasts msg with the Elder client helper, which queries the account of
+// the key first
+var buildAndBroadcast = func(conn *grpc.ClientConn, privateKey utils.Secp256k1PrivateKey, msg *types.MsgSubmitRollTx) (string, error) {
+	return utils.BuildElderTxFromMsgAndBroadcast(
+		utils.AuthClient(conn),
+		utils.TmClient(conn),
+		utils.TxClient(conn),
+		privateKey,
+		msg,
+		2)
+}
+
 type ElderClient struct {
-	Conn   *grpc.ClientConn
-	locks  map[string]*sync.Mutex
-	logger logging.Logger
+	endpoints []string
+	conns     []*grpc.ClientConn
+	logger    logging.Logger
+
+	locksMu sync.Mutex
+	// locks serialize the transactions of each Elder address, their account sequence is shared
+	locks map[string]*sync.Mutex
+
+	// accounts caches the account numbers and sequences of the keys signing in elder-wrap
+	accounts *accountManager
+	txCfg    config.ElderTxConfig
+	// batcher packs the submitted messages into multi-message transactions, nil when batching is disabled
+	batcher *batcher
+	// lastFee is the fee paid for a message of the last transaction signed in elder-wrap
+	lastFee atomic.Uint64
+
+	mu sync.Mutex
+	// active is the index of the endpoint calls are sent to, it moves to the next endpoint on failure
+	active int
+	// cachedChainID is the chain id of Elder once queried
+	cachedChainID string
 }
 
-func NewElderClient(endpoint string, keyStore keystore.KeyStore, logger logging.Logger) (*ElderClient, error) {
-	logger.Info(nil, "Connecting to elder gRPC endpoint", "endpoint", endpoint)
-	elderConn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		logger.Error(nil, "failed to connect to elder gRPC endpoint", "error", err)
-		return nil, errors.Wrap(err, "failed to connect to elder gRPC endpoint")
+func NewElderClient(endpoints []string, grpcCfg config.ElderGrpcConfig, keyStore keystore.KeyStore, logger logging.Logger) (*ElderClient, error) {
+	e := &ElderClient{endpoints: endpoints, logger: logger, txCfg: config.ElderTxConfig{GasMultiplier: config.DefaultElderTxGasMultiplier}}
+	e.accounts = newAccountManager(e.queryAccount)
+
+	for i, endpoint := range endpoints {
+		opts, err := e.dialOptions(i, grpcCfg)
+		if err != nil {
+			logger.Error(nil, "failed to configure elder gRPC connection", "error", err)
+			e.Close()
+			return nil, errors.Wrap(err, "failed to configure elder gRPC connection")
+		}
+
+		logger.Info(nil, "Connecting to elder gRPC endpoint", "endpoint", endpoint, "tls", grpcCfg.TLS.Enabled)
+		elderConn, err := grpc.NewClient(endpoint, opts...)
+		if err != nil {
+			logger.Error(nil, "failed to connect to elder gRPC endpoint", "endpoint", endpoint, "error", err)
+			e.Close()
+			return nil, errors.Wrap(err, "failed to connect to elder gRPC endpoint")
+		}
+		e.conns = append(e.conns, elderConn)
 	}
 
-	locks := make(map[string]*sync.Mutex)
+	if err := e.SyncKeys(keyStore); err != nil {
+		e.Close()
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// SyncKeys rebuilds the locks from the keys in the keystore. The locks of the addresses still in the
+// keystore are kept, so transactions being broadcast stay serialized.
+func (e *ElderClient) SyncKeys(keyStore keystore.KeyStore) error {
 	keyListByElderAddress, err := keyStore.ListByElderAddress()
 	if err != nil {
-		logger.Error(nil, "failed to list keys by elder address", "error", err)
-		return nil, errors.Wrap(err, "failed to list keys by elder address")
+		e.logger.Error(nil, "failed to list keys by elder address", "error", err)
+		return errors.Wrap(err, "failed to list keys by elder address")
 	}
 
-	for elderAddress, _ := range keyListByElderAddress {
-		locks[elderAddress] = &sync.Mutex{}
+	e.locksMu.Lock()
+	defer e.locksMu.Unlock()
+
+	locks := make(map[string]*sync.Mutex, len(keyListByElderAddress))
+	for elderAddress := range keyListByElderAddress {
+		if lock, ok := e.locks[elderAddress]; ok {
+			locks[elderAddress] = lock
+		} else {
+			locks[elderAddress] = &sync.Mutex{}
+		}
 	}
+	e.locks = locks
+	return nil
+}
+
+// lock returns the lock of an Elder address, keys imported since the last SyncKeys get one on first use
+func (e *ElderClient) lock(elderAddress string) *sync.Mutex {
+	e.locksMu.Lock()
+	defer e.locksMu.Unlock()
 
-	return &ElderClient{Conn: elderConn, locks: locks, logger: logger}, nil
+	lock, ok := e.locks[elderAddress]
+	if !ok {
+		lock = &sync.Mutex{}
+		e.locks[elderAddress] = lock
+	}
+	return lock
 }
 
-func (e *ElderClient) BroadCastTxn(key *keystore.Key, msg *types.MsgSubmitRollTx) (string, error) {
-	e.logger.Debug(nil, "Broadcasting transaction", "key", key.ElderAddress, "msg", msg)
-	e.locks[key.ElderAddress].Lock()
-	defer e.locks[key.ElderAddress].Unlock()
+// Conn returns the connection to the active Elder endpoint. When its connection is failing,
+// the next endpoint whose connection is not failing becomes the active one.
+func (e *ElderClient) Conn() *grpc.ClientConn {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 
-	elderTxHash, err := utils.BuildElderTxFromMsgAndBroadcast(
-		utils.AuthClient(e.Conn),
-		utils.TmClient(e.Conn),
-		utils.TxClient(e.Conn),
-		key.PrivateKey,
-		msg,
-		2)
+	for i := 0; i < len(e.conns); i++ {
+		index := (e.active + i) % len(e.conns)
+		state := e.conns[index].GetState()
+		if state == connectivity.TransientFailure || state == connectivity.Shutdown {
+			continue
+		}
+		if index != e.active {
+			e.logger.Warn(nil, "Failing over to elder gRPC endpoint", "from", e.endpoints[e.active], "to", e.endpoints[index])
+			e.active = index
+		}
+		return e.conns[index]
+	}
+	return e.conns[e.active]
+}
+
+// markFailed moves calls away from the endpoint at index after a call to it failed
+func (e *ElderClient) markFailed(index int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if index != e.active || len(e.conns) < 2 {
+		return
+	}
+	e.active = (index + 1) % len(e.conns)
+	e.logger.Warn(nil, "Failing over to elder gRPC endpoint", "from", e.endpoints[index], "to", e.endpoints[e.active])
+}
+
+// Close closes the connections to every Elder endpoint
+func (e *ElderClient) Close() error {
+	var result error
+	for _, conn := range e.conns {
+		if err := conn.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// BroadCastTxn broadcasts msg in an Elder transaction signed with key, by elder-wrap with local signing
+// and by the Elder client helper otherwise, the fee paid is only known with local signing. Only local
+// signing pipelines the transactions of a key: the helper queries the sequence of the key for every
+// transaction, so they are broadcast one at a time under the lock of the key.
+func (e *ElderClient) BroadCastTxn(ctx context.Context, key *keystore.Key, msg *types.MsgSubmitRollTx) (broadcast Broadcast, err error) {
+	if e.txCfg.LocalSigning {
+		return e.BroadCastTxns(ctx, key, []*types.MsgSubmitRollTx{msg})
+	}
+
+	ctx, span := tracing.Start(ctx, "BroadCastTxn", tracing.ElderSenderKey.String(key.ElderAddress))
+	defer func() {
+		span.SetAttributes(tracing.ElderTxHashKey.String(broadcast.ElderTxHash))
+		tracing.End(span, err)
+	}()
+
+	e.logger.Debug(ctx, "Broadcasting transaction", "key", key.ElderAddress, "msg", msg)
+	lock := e.lock(key.ElderAddress)
+	lock.Lock()
+	defer lock.Unlock()
+
+	elderTxHash, err := buildAndBroadcast(e.Conn(), key.PrivateKey, msg)
+	// The helper used the sequence of key, the sequence cached for the batches is stale
+	e.accounts.forget(key.ElderAddress)
 	if elderTxHash == "" || err != nil {
-		e.logger.Error(nil, "failed to broadcast transaction", "elderTxHash", elderTxHash, "error", err)
-		return elderTxHash, errors.Wrap(err, "failed to broadcast transaction")
+		e.logger.Error(ctx, "failed to broadcast transaction", "elderTxHash", elderTxHash, "error", err)
+		return Broadcast{ElderTxHash: elderTxHash}, errors.Wrap(err, "failed to broadcast transaction")
 	}
-	return elderTxHash, nil
+	return Broadcast{ElderTxHash: elderTxHash}, nil
+}
+
+// RollAppBlock returns the rollApp block the Elder transaction was included in, the Elder client helper
+// polls for the transaction until it is included or times out
+func (e *ElderClient) RollAppBlock(ctx context.Context, elderTxHash string) (rollAppBlock string, err error) {
+	_, span := tracing.Start(ctx, "GetElderTxFromHash", tracing.ElderTxHashKey.String(elderTxHash))
+	defer func() { tracing.End(span, err) }()
+
+	_, rollAppBlock, err = utils.GetElderTxFromHash(utils.TxClient(e.Conn()), elderTxHash)
+	return rollAppBlock, err
 }
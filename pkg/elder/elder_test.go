@@ -0,0 +1,94 @@
+package elder
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/0xElder/elder-wrap/pkg/keystore"
+	"github.com/0xElder/elder-wrap/pkg/logging"
+	"github.com/0xElder/elder/utils"
+	"github.com/0xElder/elder/x/router/types"
+	"google.golang.org/grpc"
+)
+
+func TestElderClient_BroadCastTxn_helper(t *testing.T) {
+	const address = "elder1a"
+
+	tests := []struct {
+		name    string
+		err     error
+		wantErr bool
+	}{
+		{name: "broadcast"},
+		{name: "failed broadcast", err: errors.New("connection refused"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// inFlight counts the helper calls running at once, maxInFlight the most of them
+			var inFlight, maxInFlight atomic.Int32
+			previous := buildAndBroadcast
+			t.Cleanup(func() { buildAndBroadcast = previous })
+			buildAndBroadcast = func(*grpc.ClientConn, utils.Secp256k1PrivateKey, *types.MsgSubmitRollTx) (string, error) {
+				n := inFlight.Add(1)
+				defer inFlight.Add(-1)
+				for {
+					previous := maxInFlight.Load()
+					if n <= previous || maxInFlight.CompareAndSwap(previous, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				if tt.err != nil {
+					return "", tt.err
+				}
+				return "ABCD", nil
+			}
+
+			endpoint, _ := newTestEndpoint(t, nil)
+			store, err := keystore.NewPlainKeyStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, err := NewElderClient([]string{endpoint}, config.ElderGrpcConfig{}, store, logging.NewDevSlogger(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer e.Close()
+			e.accounts.accounts[address] = &account{number: 7, sequence: 3, synced: true}
+
+			key := &keystore.Key{ElderAddress: address}
+			var wg sync.WaitGroup
+			for i := 0; i < 3; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					broadcast, err := e.BroadCastTxn(context.Background(), key, &types.MsgSubmitRollTx{RollId: uint64(i)})
+					if (err != nil) != tt.wantErr {
+						t.Errorf("BroadCastTxn() error = %v, wantErr %v", err, tt.wantErr)
+					}
+					if !tt.wantErr && broadcast.ElderTxHash != "ABCD" {
+						t.Errorf("BroadCastTxn() hash = %q, want ABCD", broadcast.ElderTxHash)
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			if maxInFlight.Load() != 1 {
+				t.Errorf("%d transactions of the key were broadcast at once, want 1", maxInFlight.Load())
+			}
+			cached := e.accounts.accounts[address]
+			if cached.synced {
+				t.Error("sequence is still cached after the helper signed, want it queried again")
+			}
+			if cached.number != 7 {
+				t.Errorf("account number = %d, want 7", cached.number)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package elder
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/health"
+	"github.com/0xElder/elder-wrap/pkg/keystore"
+	"github.com/cosmos/cosmos-sdk/client/grpc/cmtservice"
+	banktypes "github.com/cosmos/cosmos-sdk/x/bank/types"
+	"github.com/pkg/errors"
+)
+
+// ChainStatus is the state of Elder seen from the active endpoint
+type ChainStatus struct {
+	Endpoint        string    `json:"endpoint"`
+	ChainID         string    `json:"chain_id"`
+	LatestBlock     int64     `json:"latest_block"`
+	LatestBlockTime time.Time `json:"latest_block_time"`
+	Syncing         bool      `json:"syncing"`
+}
+
+// Status returns the latest block and syncing status of the active Elder endpoint
+func (e *ElderClient) Status(ctx context.Context) (*ChainStatus, error) {
+	conn := e.Conn()
+	client := cmtservice.NewServiceClient(conn)
+
+	syncing, err := client.GetSyncing(ctx, &cmtservice.GetSyncingRequest{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get elder syncing status")
+	}
+	latest, err := client.GetLatestBlock(ctx, &cmtservice.GetLatestBlockRequest{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get elder latest block")
+	}
+	if latest.SdkBlock == nil {
+		return nil, errors.New("elder latest block is empty")
+	}
+
+	return &ChainStatus{
+		Endpoint:        conn.Target(),
+		ChainID:         latest.SdkBlock.Header.ChainID,
+		LatestBlock:     latest.SdkBlock.Header.Height,
+		LatestBlockTime: latest.SdkBlock.Header.Time,
+		Syncing:         syncing.Syncing,
+	}, nil
+}
+
+// Balance returns the balance of an Elder address in denom
+func (e *ElderClient) Balance(ctx context.Context, address, denom string) (*big.Int, error) {
+	response, err := banktypes.NewQueryClient(e.Conn()).Balance(ctx, &banktypes.QueryBalanceRequest{Address: address, Denom: denom})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query elder balance")
+	}
+	if response.Balance == nil || response.Balance.Amount.IsNil() {
+		return new(big.Int), nil
+	}
+	return response.Balance.Amount.BigInt(), nil
+}
+
+// ReadinessCheck checks that Elder is reachable, done syncing and produced a block within maxBlockAge
+func (e *ElderClient) ReadinessCheck(maxBlockAge time.Duration) health.Check {
+	return health.Check{
+		Name: "elder",
+		Check: func(ctx context.Context) (interface{}, error) {
+			status, err := e.Status(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if status.Syncing {
+				return status, errors.New("elder node is syncing")
+			}
+			if age := time.Since(status.LatestBlockTime); age > maxBlockAge {
+				return status, fmt.Errorf("latest elder block is %s old", age.Round(time.Second))
+			}
+			return status, nil
+		},
+	}
+}
+
+// BalanceCheck checks that every key of the keystore holds at least minBalance of denom on Elder
+func (e *ElderClient) BalanceCheck(keyStore keystore.KeyStore, denom string, minBalance *big.Int) health.Check {
+	return health.Check{
+		Name: "elder_balance",
+		Check: func(ctx context.Context) (interface{}, error) {
+			keys, err := keyStore.ListByElderAddress()
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to list keys by elder address")
+			}
+
+			balances := make(map[string]string, len(keys))
+			var low []string
+			for elderAddress := range keys {
+				balance, err := e.Balance(ctx, elderAddress, denom)
+				if err != nil {
+					return balances, err
+				}
+				balances[elderAddress] = balance.String() + denom
+				if balance.Cmp(minBalance) < 0 {
+					low = append(low, elderAddress)
+				}
+			}
+			if len(low) > 0 {
+				sort.Strings(low)
+				return balances, fmt.Errorf("%d keys hold less than %s%s: %v", len(low), minBalance, denom, low)
+			}
+			return balances, nil
+		},
+	}
+}
@@ -0,0 +1,157 @@
+package elder
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"strings"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// dialOptions returns the options of the connection to the endpoint at index
+func (e *ElderClient) dialOptions(index int, grpcCfg config.ElderGrpcConfig) ([]grpc.DialOption, error) {
+	creds := insecure.NewCredentials()
+	if grpcCfg.TLS.Enabled {
+		tlsConfig, err := newTLSConfig(grpcCfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	md, err := callMetadata(grpcCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithChainUnaryInterceptor(e.callInterceptor(index, grpcCfg, md)),
+	}
+	if grpcCfg.KeepaliveTime > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                grpcCfg.KeepaliveTime,
+			Timeout:             grpcCfg.KeepaliveTimeout,
+			PermitWithoutStream: true,
+		}))
+	}
+	if grpcCfg.DialTimeout > 0 {
+		opts = append(opts, grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff:           backoff.DefaultConfig,
+			MinConnectTimeout: grpcCfg.DialTimeout,
+		}))
+	}
+	return opts, nil
+}
+
+// broadcastTxMethod is the only Elder call which is not idempotent, see retryable
+const broadcastTxMethod = "/cosmos.tx.v1beta1.Service/BroadcastTx"
+
+// retryKey marks the context of a call retried on another endpoint, it is not retried again
+type retryKey struct{}
+
+// callInterceptor applies the call timeout and metadata to every call to the endpoint at index,
+// and fails over to the next endpoint when the endpoint is unavailable. The failed call is retried
+// on the next healthy endpoint when it is retryable.
+func (e *ElderClient) callInterceptor(index int, grpcCfg config.ElderGrpcConfig, md metadata.MD) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		callCtx := ctx
+		if _, ok := callCtx.Deadline(); !ok && grpcCfg.CallTimeout > 0 {
+			var cancel context.CancelFunc
+			callCtx, cancel = context.WithTimeout(callCtx, grpcCfg.CallTimeout)
+			defer cancel()
+		}
+		if md.Len() > 0 {
+			callCtx = metadata.NewOutgoingContext(callCtx, metadata.Join(md, outgoingMetadata(callCtx)))
+		}
+
+		// The peer is only set once the call got a stream to the endpoint
+		var p peer.Peer
+		err := invoker(callCtx, method, req, reply, cc, append(opts, grpc.Peer(&p))...)
+		if code := status.Code(err); code != codes.Unavailable && code != codes.DeadlineExceeded {
+			return err
+		}
+		e.logger.Error(ctx, "elder gRPC call failed", "endpoint", e.endpoints[index], "method", method, "error", err)
+		e.markFailed(index)
+
+		if ctx.Value(retryKey{}) != nil || ctx.Err() != nil || !retryable(method, err, p.Addr != nil) {
+			return err
+		}
+		next := e.Conn()
+		if next == cc {
+			return err
+		}
+		e.logger.Warn(ctx, "Retrying elder gRPC call on the next endpoint", "method", method, "endpoint", next.Target())
+		// The call to the next endpoint goes through its own interceptor, it fails over again but is not retried
+		return next.Invoke(context.WithValue(ctx, retryKey{}, true), method, req, reply, opts...)
+	}
+}
+
+// retryable returns whether a call which failed with err can be sent to another endpoint. Queries,
+// simulations and GetTx are idempotent. A broadcast is only retried when the endpoint was unavailable
+// before the request was sent, otherwise the transaction may already be in the mempool.
+func retryable(method string, err error, sent bool) bool {
+	if method != broadcastTxMethod {
+		return true
+	}
+	return status.Code(err) == codes.Unavailable && !sent
+}
+
+func outgoingMetadata(ctx context.Context) metadata.MD {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return md
+}
+
+// callMetadata returns the metadata sent with every call, the auth token is sent as a bearer authorization header
+func callMetadata(grpcCfg config.ElderGrpcConfig) (metadata.MD, error) {
+	md := metadata.New(grpcCfg.Metadata)
+	if grpcCfg.AuthTokenFile != "" {
+		token, err := os.ReadFile(grpcCfg.AuthTokenFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read elder gRPC auth token file")
+		}
+		md.Set("authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	return md, nil
+}
+
+// newTLSConfig loads the CA and the client certificate of the Elder connection
+func newTLSConfig(tlsCfg config.ElderGrpcTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: tlsCfg.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if tlsCfg.CAFile != "" {
+		ca, err := os.ReadFile(tlsCfg.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read elder gRPC CA file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("no certificate found in elder gRPC CA file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if tlsCfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCfg.CertFile, tlsCfg.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load elder gRPC client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,97 @@
+package elder
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/0xElder/elder-wrap/pkg/keystore"
+	"github.com/0xElder/elder-wrap/pkg/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// newTestEndpoint starts a gRPC server answering every call with err, or with a health response when err is nil
+func newTestEndpoint(t *testing.T, err error) (string, *atomic.Int32) {
+	listener, listenErr := net.Listen("tcp", "127.0.0.1:0")
+	if listenErr != nil {
+		t.Fatal(listenErr)
+	}
+	calls := &atomic.Int32{}
+	server := grpc.NewServer(grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		calls.Add(1)
+		if err != nil {
+			return err
+		}
+		if err := stream.RecvMsg(&grpc_health_v1.HealthCheckRequest{}); err != nil {
+			return err
+		}
+		return stream.SendMsg(&grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING})
+	}))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().String(), calls
+}
+
+// downEndpoint returns the address of a closed port
+func downEndpoint(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+func TestElderClient_callRetry(t *testing.T) {
+	const queryMethod = "/cosmos.auth.v1beta1.Query/Account"
+	unavailable := status.Error(codes.Unavailable, "node is restarting")
+
+	tests := []struct {
+		name string
+		// first is the error of the first endpoint, it is down when nil
+		first     error
+		method    string
+		wantCode  codes.Code
+		wantCalls int32
+	}{
+		{name: "query to a down endpoint is retried", method: queryMethod, wantCode: codes.OK, wantCalls: 1},
+		{name: "unavailable query is retried", first: unavailable, method: queryMethod, wantCode: codes.OK, wantCalls: 1},
+		{name: "broadcast to a down endpoint is retried", method: broadcastTxMethod, wantCode: codes.OK, wantCalls: 1},
+		{name: "broadcast sent to an unavailable endpoint is not retried", first: unavailable, method: broadcastTxMethod, wantCode: codes.Unavailable, wantCalls: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := downEndpoint(t)
+			if tt.first != nil {
+				first, _ = newTestEndpoint(t, tt.first)
+			}
+			second, calls := newTestEndpoint(t, nil)
+
+			store, err := keystore.NewPlainKeyStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, err := NewElderClient([]string{first, second}, config.ElderGrpcConfig{}, store, logging.NewDevSlogger(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer e.Close()
+
+			reply := &grpc_health_v1.HealthCheckResponse{}
+			err = e.conns[0].Invoke(context.Background(), tt.method, &grpc_health_v1.HealthCheckRequest{}, reply)
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("Invoke() error = %v, want code %s", err, tt.wantCode)
+			}
+			if calls.Load() != tt.wantCalls {
+				t.Errorf("next endpoint got %d calls, want %d", calls.Load(), tt.wantCalls)
+			}
+		})
+	}
+}
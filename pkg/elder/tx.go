@@ -0,0 +1,226 @@
+package elder
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/0xElder/elder-wrap/pkg/keystore"
+	"github.com/0xElder/elder-wrap/pkg/tracing"
+	"github.com/0xElder/elder/x/router/types"
+	"github.com/cosmos/cosmos-sdk/client"
+	"github.com/cosmos/cosmos-sdk/client/grpc/cmtservice"
+	clienttx "github.com/cosmos/cosmos-sdk/client/tx"
+	"github.com/cosmos/cosmos-sdk/codec"
+	codectypes "github.com/cosmos/cosmos-sdk/codec/types"
+	"github.com/cosmos/cosmos-sdk/crypto/keys/secp256k1"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	txtypes "github.com/cosmos/cosmos-sdk/types/tx"
+	"github.com/cosmos/cosmos-sdk/types/tx/signing"
+	authsigning "github.com/cosmos/cosmos-sdk/x/auth/signing"
+	authtx "github.com/cosmos/cosmos-sdk/x/auth/tx"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CheckTxError is returned when Elder rejects a transaction in CheckTx, nothing was included
+type CheckTxError struct {
+	Code   uint32
+	RawLog string
+}
+
+func (e *CheckTxError) Error() string {
+	return fmt.Sprintf("elder rejected transaction with code %d: %s", e.Code, e.RawLog)
+}
+
+// simulationError returns a CheckTxError when Elder ran the simulation and rejected the transaction,
+// which the Cosmos SDK reports with codes Unknown and InvalidArgument. Any other error did not reach
+// the simulation, it leaves the state of the account unknown.
+func simulationError(err error) error {
+	if st, ok := status.FromError(err); ok && (st.Code() == codes.Unknown || st.Code() == codes.InvalidArgument) {
+		return &CheckTxError{RawLog: "simulation failed: " + st.Message()}
+	}
+	return errors.Wrap(err, "failed to simulate elder transaction")
+}
+
+// feeEstimateGas is the gas a message is assumed to use until elder-wrap paid the fee of one
+const feeEstimateGas = 100000
+
+// newTxConfig returns the config encoding and signing the Elder transactions of MsgSubmitRollTx
+func newTxConfig() client.TxConfig {
+	registry := codectypes.NewInterfaceRegistry()
+	types.RegisterInterfaces(registry)
+	return authtx.NewTxConfig(codec.NewProtoCodec(registry), authtx.DefaultSignModes)
+}
+
+// Broadcast is the Elder transaction a message was broadcast in
+type Broadcast struct {
+	ElderTxHash string
+	// Fee is the part of the Elder transaction fee paid for the message, in the denom of the gas price.
+	// It is zero when the fee is not known: the Elder client helper signed the transaction.
+	Fee uint64
+}
+
+// BroadCastTxns broadcasts msgs in a single Elder transaction signed with key. The gas is simulated
+// and padded by the gas multiplier, the fee is the gas at the gas price. The transaction is signed
+// with the sequence tracked for key, so it does not wait for the previous one to be included, and
+// is signed again once with the expected sequence when Elder reports a mismatch.
+func (e *ElderClient) BroadCastTxns(ctx context.Context, key *keystore.Key, msgs []*types.MsgSubmitRollTx) (broadcast Broadcast, err error) {
+	ctx, span := tracing.Start(ctx, "BroadCastTxns", tracing.ElderSenderKey.String(key.ElderAddress))
+	defer func() {
+		span.SetAttributes(tracing.ElderTxHashKey.String(broadcast.ElderTxHash))
+		tracing.End(span, err)
+	}()
+
+	e.logger.Debug(ctx, "Broadcasting batch", "key", key.ElderAddress, "size", len(msgs))
+	lock := e.lock(key.ElderAddress)
+	lock.Lock()
+	defer lock.Unlock()
+
+	broadcast, err = e.signAndBroadcast(ctx, key, msgs)
+	if err != nil && e.accounts.resync(key.ElderAddress, err) {
+		e.logger.Warn(ctx, "Elder account sequence mismatch, signing again", "key", key.ElderAddress, "error", err)
+		broadcast, err = e.signAndBroadcast(ctx, key, msgs)
+	}
+	return broadcast, err
+}
+
+// EstimateFee returns the Elder fee a message is expected to cost, in the denom of the gas price: the fee
+// paid for a message of the last transaction, or feeEstimateGas padded by the gas multiplier at the gas
+// price until then. It is zero when the fee is not known: without local signing or gas price.
+func (e *ElderClient) EstimateFee() uint64 {
+	if !e.txCfg.LocalSigning || e.txCfg.GasPrice == "" {
+		return 0
+	}
+	if fee := e.lastFee.Load(); fee > 0 {
+		return fee
+	}
+	gasPrice, err := sdk.ParseDecCoin(e.txCfg.GasPrice)
+	if err != nil {
+		return 0
+	}
+	amount := gasPrice.Amount.MulInt64(int64(math.Ceil(feeEstimateGas * e.txCfg.GasMultiplier))).Ceil().TruncateInt()
+	if !amount.BigInt().IsUint64() {
+		return 0
+	}
+	return amount.BigInt().Uint64()
+}
+
+// chainID returns the chain id of Elder, queried once
+func (e *ElderClient) chainID(ctx context.Context) (string, error) {
+	e.mu.Lock()
+	chainID := e.cachedChainID
+	e.mu.Unlock()
+	if chainID != "" {
+		return chainID, nil
+	}
+
+	latest, err := cmtservice.NewServiceClient(e.Conn()).GetLatestBlock(ctx, &cmtservice.GetLatestBlockRequest{})
+	if err != nil {
+		e.logger.Error(ctx, "failed to get elder chain id", "error", err)
+		return "", errors.Wrap(err, "failed to get elder chain id")
+	}
+	if latest.SdkBlock == nil {
+		return "", errors.New("elder latest block is empty")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cachedChainID = latest.SdkBlock.Header.ChainID
+	return e.cachedChainID, nil
+}
+
+// signAndBroadcast signs msgs with the account of key and broadcasts them, the sequence of key moves
+// on once Elder accepts the transaction in CheckTx
+func (e *ElderClient) signAndBroadcast(ctx context.Context, key *keystore.Key, msgs []*types.MsgSubmitRollTx) (Broadcast, error) {
+	chainID, err := e.chainID(ctx)
+	if err != nil {
+		return Broadcast{}, err
+	}
+	account, err := e.accounts.get(ctx, key.ElderAddress)
+	if err != nil {
+		return Broadcast{}, err
+	}
+	sequence := account.sequence
+
+	txConfig := newTxConfig()
+	builder := txConfig.NewTxBuilder()
+	sdkMsgs := make([]sdk.Msg, 0, len(msgs))
+	for _, msg := range msgs {
+		sdkMsgs = append(sdkMsgs, msg)
+	}
+	if err := builder.SetMsgs(sdkMsgs...); err != nil {
+		return Broadcast{}, errors.Wrap(err, "failed to build elder transaction")
+	}
+
+	privKey := &secp256k1.PrivKey{Key: key.PrivateKey.Bytes()}
+	// The transaction is simulated with an empty signature of the key
+	unsigned := signing.SignatureV2{
+		PubKey:   privKey.PubKey(),
+		Data:     &signing.SingleSignatureData{SignMode: signing.SignMode_SIGN_MODE_DIRECT},
+		Sequence: sequence,
+	}
+	if err := builder.SetSignatures(unsigned); err != nil {
+		return Broadcast{}, errors.Wrap(err, "failed to build elder transaction")
+	}
+	txBytes, err := txConfig.TxEncoder()(builder.GetTx())
+	if err != nil {
+		return Broadcast{}, errors.Wrap(err, "failed to encode elder transaction")
+	}
+
+	txClient := txtypes.NewServiceClient(e.Conn())
+	simulation, err := txClient.Simulate(ctx, &txtypes.SimulateRequest{TxBytes: txBytes})
+	if err != nil {
+		e.logger.Error(ctx, "failed to simulate elder transaction", "error", err)
+		return Broadcast{}, simulationError(err)
+	}
+	var fee uint64
+	gasLimit := uint64(math.Ceil(float64(simulation.GasInfo.GasUsed) * e.txCfg.GasMultiplier))
+	builder.SetGasLimit(gasLimit)
+	if e.txCfg.GasPrice != "" {
+		gasPrice, err := sdk.ParseDecCoin(e.txCfg.GasPrice)
+		if err != nil {
+			return Broadcast{}, errors.Wrap(err, "invalid elder_tx.gas_price")
+		}
+		amount := gasPrice.Amount.MulInt64(int64(gasLimit)).Ceil().TruncateInt()
+		builder.SetFeeAmount(sdk.NewCoins(sdk.NewCoin(gasPrice.Denom, amount)))
+		if amount.BigInt().IsUint64() {
+			fee = amount.BigInt().Uint64()
+		}
+	}
+
+	signerData := authsigning.SignerData{
+		Address:       key.ElderAddress,
+		ChainID:       chainID,
+		AccountNumber: account.number,
+		Sequence:      sequence,
+		PubKey:        privKey.PubKey(),
+	}
+	signature, err := clienttx.SignWithPrivKey(ctx, signing.SignMode_SIGN_MODE_DIRECT, signerData, builder, privKey, txConfig, sequence)
+	if err != nil {
+		return Broadcast{}, errors.Wrap(err, "failed to sign elder transaction")
+	}
+	if err := builder.SetSignatures(signature); err != nil {
+		return Broadcast{}, errors.Wrap(err, "failed to sign elder transaction")
+	}
+	txBytes, err = txConfig.TxEncoder()(builder.GetTx())
+	if err != nil {
+		return Broadcast{}, errors.Wrap(err, "failed to encode elder transaction")
+	}
+
+	response, err := txClient.BroadcastTx(ctx, &txtypes.BroadcastTxRequest{TxBytes: txBytes, Mode: txtypes.BroadcastMode_BROADCAST_MODE_SYNC})
+	if err != nil {
+		e.logger.Error(ctx, "failed to broadcast batch", "error", err)
+		return Broadcast{}, errors.Wrap(err, "failed to broadcast batch")
+	}
+	if response.TxResponse.Code != 0 {
+		e.logger.Error(ctx, "elder rejected batch", "code", response.TxResponse.Code, "log", response.TxResponse.RawLog)
+		return Broadcast{}, &CheckTxError{Code: response.TxResponse.Code, RawLog: response.TxResponse.RawLog}
+	}
+	e.accounts.accepted(key.ElderAddress, sequence)
+	if fee > 0 {
+		e.lastFee.Store((fee + uint64(len(msgs)) - 1) / uint64(len(msgs)))
+	}
+	return Broadcast{ElderTxHash: response.TxResponse.TxHash, Fee: fee}, nil
+}
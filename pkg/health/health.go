@@ -0,0 +1,124 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/keystore"
+	"github.com/0xElder/elder-wrap/pkg/logging"
+)
+
+const (
+	StatusOK      = "ok"
+	StatusFailing = "failing"
+)
+
+// CheckFunc checks a component, the details are reported next to its status
+type CheckFunc func(ctx context.Context) (details interface{}, err error)
+
+// Check is a named readiness check
+type Check struct {
+	Name  string
+	Check CheckFunc
+}
+
+// ComponentStatus is the outcome of the check of a component
+type ComponentStatus struct {
+	Status   string      `json:"status"`
+	Error    string      `json:"error,omitempty"`
+	Details  interface{} `json:"details,omitempty"`
+	Duration string      `json:"duration"`
+}
+
+// Report is the readiness of elder-wrap, it is ok when every component is
+type Report struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+// Checker runs the readiness checks
+type Checker struct {
+	checks  []Check
+	timeout time.Duration
+	logger  logging.Logger
+}
+
+func NewChecker(timeout time.Duration, logger logging.Logger, checks ...Check) *Checker {
+	return &Checker{checks: checks, timeout: timeout, logger: logger}
+}
+
+// Run runs every check concurrently, each bounded by the checker timeout
+func (c *Checker) Run(ctx context.Context) Report {
+	report := Report{
+		Status:     StatusOK,
+		Components: make(map[string]ComponentStatus, len(c.checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range c.checks {
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+
+			start := time.Now()
+			details, err := check.Check(checkCtx)
+			status := ComponentStatus{
+				Status:   StatusOK,
+				Details:  details,
+				Duration: time.Since(start).String(),
+			}
+			if err != nil {
+				c.logger.Warn(ctx, "Readiness check failed", "component", check.Name, "error", err)
+				status.Status = StatusFailing
+				status.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Components[check.Name] = status
+			if err != nil {
+				report.Status = StatusFailing
+			}
+		}(check)
+	}
+	wg.Wait()
+	return report
+}
+
+// HandleReadyz answers 200 when every component is ready and 503 otherwise, with the report of each component
+func (c *Checker) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := c.Run(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// HandleHealthz answers 200 as long as the process serves requests
+func HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": StatusOK})
+}
+
+// KeyStoreCheck checks that the keys can be read from the keystore
+func KeyStoreCheck(keyStore keystore.KeyStore) Check {
+	return Check{
+		Name: "keystore",
+		Check: func(ctx context.Context) (interface{}, error) {
+			keys, err := keyStore.ListByAlias()
+			if err != nil {
+				return nil, err
+			}
+			return map[string]int{"keys": len(keys)}, nil
+		},
+	}
+}
@@ -0,0 +1,76 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/logging"
+)
+
+func TestChecker_HandleReadyz(t *testing.T) {
+	ok := Check{Name: "ok", Check: func(ctx context.Context) (interface{}, error) {
+		return map[string]int{"height": 1}, nil
+	}}
+	failing := Check{Name: "failing", Check: func(ctx context.Context) (interface{}, error) {
+		return nil, errors.New("unreachable")
+	}}
+	slow := Check{Name: "slow", Check: func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+
+	tests := []struct {
+		name       string
+		checks     []Check
+		wantCode   int
+		wantStatus map[string]string
+	}{
+		{
+			name:       "all ready",
+			checks:     []Check{ok},
+			wantCode:   http.StatusOK,
+			wantStatus: map[string]string{"ok": StatusOK},
+		},
+		{
+			name:       "one failing",
+			checks:     []Check{ok, failing},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: map[string]string{"ok": StatusOK, "failing": StatusFailing},
+		},
+		{
+			name:       "timeout",
+			checks:     []Check{slow},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: map[string]string{"slow": StatusFailing},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewChecker(50*time.Millisecond, logging.NewDevSlogger(nil), tt.checks...)
+			recorder := httptest.NewRecorder()
+			checker.HandleReadyz(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if recorder.Code != tt.wantCode {
+				t.Errorf("HandleReadyz() code = %d, want %d", recorder.Code, tt.wantCode)
+			}
+			var report Report
+			if err := json.NewDecoder(recorder.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Components) != len(tt.wantStatus) {
+				t.Errorf("HandleReadyz() components = %v, want %v", report.Components, tt.wantStatus)
+			}
+			for name, want := range tt.wantStatus {
+				if got := report.Components[name].Status; got != want {
+					t.Errorf("component %s status = %s, want %s", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// fileName is the name of the bbolt database inside the journal dir
+const fileName = "journal.db"
+
+// openTimeout bounds the wait for the database file lock held by another elder-wrap process
+const openTimeout = time.Second
+
+// Journal is an on-disk record of the rollApp transactions submitted to Elder, with one bucket per rollApp
+type Journal struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the journal in dir
+func Open(dir string) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create journal dir")
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, fileName), 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open journal")
+	}
+	return &Journal{db: db}, nil
+}
+
+// Put stores value under key in the bucket of rollApp
+func (j *Journal) Put(rollApp string, key, value []byte) error {
+	return j.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(rollApp))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, value)
+	})
+}
+
+// Delete removes key from the bucket of rollApp
+func (j *Journal) Delete(rollApp string, key []byte) error {
+	return j.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(rollApp))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete(key)
+	})
+}
+
+// ForEach calls fn for every entry in the bucket of rollApp, stopping at the first error
+func (j *Journal) ForEach(rollApp string, fn func(key, value []byte) error) error {
+	return j.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(rollApp))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(fn)
+	})
+}
+
+// Close flushes and closes the journal
+func (j *Journal) Close() error {
+	return j.db.Close()
+}
@@ -0,0 +1,54 @@
+package journal
+
+import (
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+
+	j, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Put("rollup1", []byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Put("rollup1", []byte("b"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Put("rollup2", []byte("c"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Delete("rollup1", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	entries := make(map[string]string)
+	err = j.ForEach("rollup1", func(key, value []byte) error {
+		entries[string(key)] = string(value)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries["b"] != "2" {
+		t.Errorf("Journal.ForEach() = %v, want map[b:2]", entries)
+	}
+
+	if err := j.ForEach("unknown", func(key, value []byte) error {
+		t.Errorf("Journal.ForEach() on unknown rollapp returned %s", key)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
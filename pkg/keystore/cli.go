@@ -2,8 +2,11 @@ package keystore
 
 import (
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 // GetKeystoreCommands returns keystore commands that can be added to the main elder-wrap CLI
@@ -27,6 +30,150 @@ func GetKeystoreCommands(client *KeyStoreClient) *cobra.Command {
 		},
 	}
 
+	// Import V3 keystore JSON command
+	var importPasswordFile string
+	importJSONCmd := &cobra.Command{
+		Use:   "import-json [alias] [file]",
+		Short: "Import a key from a go-ethereum V3 keystore JSON file",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			keyJSON, err := os.ReadFile(args[1])
+			if err != nil {
+				return err
+			}
+			passphrase, err := keyFilePassphrase(importPasswordFile, false)
+			if err != nil {
+				return err
+			}
+			if err := client.ImportKeyJSON(args[0], keyJSON, passphrase); err != nil {
+				return err
+			}
+			fmt.Printf("Imported key with alias: %s\n", args[0])
+			return nil
+		},
+	}
+	importJSONCmd.Flags().StringVar(&importPasswordFile, "password-file", "", "file containing the passphrase of the keystore JSON file")
+
+	// Export V3 keystore JSON command
+	var exportPasswordFile string
+	exportJSONCmd := &cobra.Command{
+		Use:   "export-json [alias] [file]",
+		Short: "Export a key to a go-ethereum V3 keystore JSON file",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := os.Stat(args[1]); err == nil {
+				return fmt.Errorf("file %s already exists", args[1])
+			}
+			passphrase, err := keyFilePassphrase(exportPasswordFile, true)
+			if err != nil {
+				return err
+			}
+			keyJSON, err := client.ExportKeyJSON(args[0], passphrase)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(args[1], keyJSON, 0600); err != nil {
+				return err
+			}
+			fmt.Printf("Exported key with alias %s to: %s\n", args[0], args[1])
+			return nil
+		},
+	}
+	exportJSONCmd.Flags().StringVar(&exportPasswordFile, "password-file", "", "file containing the passphrase to encrypt the keystore JSON file with")
+
+	// Import mnemonic command
+	var (
+		importHDPath       string
+		importCount        int
+		importMnemonicFile string
+	)
+	importMnemonicCmd := &cobra.Command{
+		Use:   "import-mnemonic [alias]",
+		Short: "Import keys derived from a BIP-39 mnemonic",
+		Long: "Import keys derived from a BIP-39 mnemonic. With --count greater than 1 the last index of the HD path is " +
+			"incremented for every key and the index is appended to the alias, e.g. alias-0, alias-1.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if importCount < 1 {
+				return fmt.Errorf("count must be at least 1")
+			}
+			basePath, err := ParseHDPath(importHDPath)
+			if err != nil {
+				return err
+			}
+			paths, err := ConsecutivePaths(basePath, importCount)
+			if err != nil {
+				return err
+			}
+			mnemonic, err := readMnemonic(importMnemonicFile)
+			if err != nil {
+				return err
+			}
+
+			aliases := make([]string, len(paths))
+			for i := range paths {
+				aliases[i] = args[0]
+				if importCount > 1 {
+					aliases[i] = fmt.Sprintf("%s-%d", args[0], i)
+				}
+			}
+
+			keys, err := client.ImportMnemonicKeys(aliases, mnemonic, paths)
+			if err != nil {
+				return err
+			}
+			for i, key := range keys {
+				fmt.Printf("Imported key with alias: %s\n  HD Path: %s\n  EVM Address: %s\n  Elder Address: %s\n",
+					aliases[i], paths[i].String(), key.EvmAddress.Hex(), key.ElderAddress)
+			}
+			return nil
+		},
+	}
+	importMnemonicCmd.Flags().StringVar(&importHDPath, "hd-path", ElderHDPath, "HD derivation path of the first key, the elder and cosmos presets are accepted")
+	importMnemonicCmd.Flags().IntVar(&importCount, "count", 1, "number of consecutive keys to import")
+	importMnemonicCmd.Flags().StringVar(&importMnemonicFile, "mnemonic-file", "", "file containing the mnemonic, prompted for if not set")
+
+	// Generate key command
+	var (
+		generateMnemonic bool
+		generateHDPath   string
+	)
+	generateCmd := &cobra.Command{
+		Use:   "generate [alias]",
+		Short: "Generate a new key",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !generateMnemonic {
+				key, err := client.GenerateKey(args[0])
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Generated key with alias: %s\nEVM Address: %s\nElder Address: %s\n",
+					args[0], key.EvmAddress.Hex(), key.ElderAddress)
+				return nil
+			}
+
+			path, err := ParseHDPath(generateHDPath)
+			if err != nil {
+				return err
+			}
+			mnemonic, err := NewMnemonic()
+			if err != nil {
+				return err
+			}
+			key, err := client.ImportMnemonic(args[0], mnemonic, path)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Generated key with alias: %s\nHD Path: %s\nEVM Address: %s\nElder Address: %s\n",
+				args[0], path.String(), key.EvmAddress.Hex(), key.ElderAddress)
+			fmt.Printf("\nMnemonic, write it down and keep it safe, it won't be shown again:\n%s\n", mnemonic)
+			return nil
+		},
+	}
+	generateCmd.Flags().BoolVar(&generateMnemonic, "mnemonic", false, "derive the key from a new BIP-39 mnemonic")
+	generateCmd.Flags().StringVar(&generateHDPath, "hd-path", ElderHDPath, "HD derivation path used with --mnemonic, the elder and cosmos presets are accepted")
+
 	// List keys command
 	listCmd := &cobra.Command{
 		Use:   "list",
@@ -114,6 +261,10 @@ func GetKeystoreCommands(client *KeyStoreClient) *cobra.Command {
 
 	keyStoreCommand.AddCommand(
 		importCmd,
+		importJSONCmd,
+		exportJSONCmd,
+		importMnemonicCmd,
+		generateCmd,
 		listCmd,
 		getCmd,
 		deleteCmd,
@@ -123,3 +274,29 @@ func GetKeystoreCommands(client *KeyStoreClient) *cobra.Command {
 
 	return keyStoreCommand
 }
+
+// keyFilePassphrase returns the passphrase of a keystore JSON file, prompting for it when no password file is given
+func keyFilePassphrase(passwordFile string, confirm bool) (string, error) {
+	if passwordFile != "" {
+		return ReadPasswordFile(passwordFile)
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("stdin is not a terminal, set --password-file")
+	}
+	return PromptPassphrase("Key file passphrase: ", confirm)
+}
+
+// readMnemonic reads a mnemonic from a file, prompting for it when no file is given
+func readMnemonic(mnemonicFile string) (string, error) {
+	if mnemonicFile == "" {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return "", fmt.Errorf("stdin is not a terminal, set --mnemonic-file")
+		}
+		return PromptPassphrase("Mnemonic: ", false)
+	}
+	content, err := os.ReadFile(mnemonicFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read mnemonic file: %w", err)
+	}
+	return strings.TrimSpace(string(content)), nil
+}
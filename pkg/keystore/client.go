@@ -1,10 +1,17 @@
 package keystore
 
 import (
+	"crypto/ecdsa"
+	"encoding/hex"
+
 	"github.com/0xElder/elder-wrap/pkg/logging"
 	"github.com/0xElder/elder/app/constants"
 	"github.com/0xElder/elder/utils"
+	"github.com/ethereum/go-ethereum/accounts"
+	ethkeystore "github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
 
 	"github.com/pkg/errors"
 )
@@ -27,15 +34,158 @@ func (s *KeyStoreClient) ImportPrivateKey(alias string, privateKeyHex string) er
 		return errors.Wrap(err, "failed to import private key")
 	}
 
-	key := &Key{
-		EvmAddress:   common.BytesToAddress(privateKey.PubKey().Address()),
-		ElderAddress: privateKeyToElderAddress(privateKey),
-		PrivateKey:   privateKey,
+	return s.store.Store(alias, newKey(privateKey))
+}
+
+// ImportMnemonic derives the key at the HD path from a BIP-39 mnemonic and imports it with an alias
+func (s *KeyStoreClient) ImportMnemonic(alias string, mnemonic string, path accounts.DerivationPath) (*Key, error) {
+	s.logger.Debug(nil, "Importing key from mnemonic", "alias", alias, "path", path.String())
+	key, err := s.deriveMnemonicKey(mnemonic, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.store.Store(alias, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ImportMnemonicKeys derives the key at each HD path from a BIP-39 mnemonic and imports it with the alias
+// at the same index. Every alias is checked and every key derived before any is stored, the keys already
+// stored are deleted again when storing one fails, so the keys are imported all or none.
+func (s *KeyStoreClient) ImportMnemonicKeys(aliases []string, mnemonic string, paths []accounts.DerivationPath) ([]*Key, error) {
+	if len(aliases) != len(paths) {
+		return nil, errors.Errorf("%d aliases for %d HD paths", len(aliases), len(paths))
+	}
+	s.logger.Debug(nil, "Importing keys from mnemonic", "aliases", aliases)
+
+	existing, err := s.store.ListByAlias()
+	if err != nil {
+		s.logger.Error(nil, "Failed to list keys by alias", "error", err)
+		return nil, errors.Wrap(err, "failed to list keys by alias")
+	}
+	seen := make(map[string]bool, len(aliases))
+	for _, alias := range aliases {
+		if _, ok := existing[alias]; ok || seen[alias] {
+			return nil, errors.Wrapf(ErrKeyExists, "key %s", alias)
+		}
+		seen[alias] = true
+	}
+
+	keys := make([]*Key, len(paths))
+	for i, path := range paths {
+		if keys[i], err = s.deriveMnemonicKey(mnemonic, path); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, key := range keys {
+		if err := s.store.Store(aliases[i], key); err != nil {
+			for _, alias := range aliases[:i] {
+				if deleteErr := s.store.Delete(alias); deleteErr != nil {
+					s.logger.Error(nil, "Failed to delete partially imported key", "alias", alias, "error", deleteErr)
+				}
+			}
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// deriveMnemonicKey derives the key at the HD path from a BIP-39 mnemonic
+func (s *KeyStoreClient) deriveMnemonicKey(mnemonic string, path accounts.DerivationPath) (*Key, error) {
+	privateKey, err := DeriveKeyFromMnemonic(mnemonic, path)
+	if err != nil {
+		s.logger.Error(nil, "Failed to derive key from mnemonic", "error", err)
+		return nil, errors.Wrap(err, "failed to derive key from mnemonic")
+	}
+
+	key, err := newKeyFromECDSA(privateKey)
+	if err != nil {
+		s.logger.Error(nil, "Failed to import private key", "error", err)
+		return nil, errors.Wrap(err, "failed to import private key")
+	}
+	return key, nil
+}
+
+// GenerateKey generates a new random private key and stores it with an alias
+func (s *KeyStoreClient) GenerateKey(alias string) (*Key, error) {
+	s.logger.Debug(nil, "Generating key", "alias", alias)
+	privateKey, err := crypto.GenerateKey()
+	if err != nil {
+		s.logger.Error(nil, "Failed to generate private key", "error", err)
+		return nil, errors.Wrap(err, "failed to generate private key")
+	}
+
+	key, err := newKeyFromECDSA(privateKey)
+	if err != nil {
+		s.logger.Error(nil, "Failed to import private key", "error", err)
+		return nil, errors.Wrap(err, "failed to import private key")
+	}
+
+	if err := s.store.Store(alias, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ImportKeyJSON imports a key from a go-ethereum V3 (Web3 Secret Storage) keystore file
+func (s *KeyStoreClient) ImportKeyJSON(alias string, keyJSON []byte, passphrase string) error {
+	s.logger.Debug(nil, "Importing V3 keystore JSON", "alias", alias)
+	ethKey, err := ethkeystore.DecryptKey(keyJSON, passphrase)
+	if err != nil {
+		s.logger.Error(nil, "Failed to decrypt keystore JSON", "error", err)
+		return errors.Wrap(err, "failed to decrypt keystore JSON")
+	}
+
+	key, err := newKeyFromECDSA(ethKey.PrivateKey)
+	if err != nil {
+		s.logger.Error(nil, "Failed to import private key", "error", err)
+		return errors.Wrap(err, "failed to import private key")
+	}
+
+	if key.EvmAddress != ethKey.Address {
+		s.logger.Error(nil, "Keystore JSON address mismatch", "expected", ethKey.Address.Hex(), "got", key.EvmAddress.Hex())
+		return errors.New("keystore JSON address does not match private key")
 	}
 
 	return s.store.Store(alias, key)
 }
 
+// ExportKeyJSON exports a key as a go-ethereum V3 (Web3 Secret Storage) keystore file encrypted with passphrase
+func (s *KeyStoreClient) ExportKeyJSON(alias string, passphrase string) ([]byte, error) {
+	s.logger.Debug(nil, "Exporting V3 keystore JSON", "alias", alias)
+	key, err := s.store.Load(alias)
+	if err != nil {
+		s.logger.Error(nil, "Failed to load key", "alias", alias, "error", err)
+		return nil, errors.Wrap(err, "failed to load key")
+	}
+
+	privateKey, err := key.ECDSA()
+	if err != nil {
+		s.logger.Error(nil, "Failed to convert private key", "error", err)
+		return nil, errors.Wrap(err, "failed to convert private key")
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate key id")
+	}
+
+	ethKey := &ethkeystore.Key{
+		Id:         id,
+		Address:    key.EvmAddress,
+		PrivateKey: privateKey,
+	}
+	keyJSON, err := ethkeystore.EncryptKey(ethKey, passphrase, ethkeystore.StandardScryptN, ethkeystore.StandardScryptP)
+	if err != nil {
+		s.logger.Error(nil, "Failed to encrypt keystore JSON", "error", err)
+		return nil, errors.Wrap(err, "failed to encrypt keystore JSON")
+	}
+	return keyJSON, nil
+}
+
 // DeleteKey removes a key by its alias
 func (s *KeyStoreClient) DeleteKey(alias string) error {
 	s.logger.Debug(nil, "Deleting key", "alias", alias)
@@ -101,6 +251,23 @@ func (s *KeyStoreClient) GetKeyByElderAddress(address string) (*Key, error) {
 	return key, nil
 }
 
+// newKey derives the EVM and Elder addresses of a private key
+func newKey(privateKey utils.Secp256k1PrivateKey) *Key {
+	return &Key{
+		EvmAddress:   common.BytesToAddress(privateKey.PubKey().Address()),
+		ElderAddress: privateKeyToElderAddress(privateKey),
+		PrivateKey:   privateKey,
+	}
+}
+
+func newKeyFromECDSA(privateKey *ecdsa.PrivateKey) (*Key, error) {
+	secp256k1Key, err := utils.PrivateKeyStringToSecp256k1PrivKey(hex.EncodeToString(crypto.FromECDSA(privateKey)))
+	if err != nil {
+		return nil, err
+	}
+	return newKey(secp256k1Key), nil
+}
+
 func privateKeyToElderAddress(privateKey utils.Secp256k1PrivateKey) string {
 	return utils.CosmosPublicKeyToBech32Address(constants.Bech32PrefixAccAddr, privateKey.PubKey())
 }
@@ -0,0 +1,141 @@
+package keystore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0xElder/elder-wrap/pkg/logging"
+	ethkeystore "github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// The Web3 Secret Storage test vector, testdata/v3_scrypt.json is encrypted with its password
+const (
+	v3TestPassword   = "testpassword"
+	v3TestPrivateKey = "0x7a28b5ba57c53603b0b07b56bba752f7784bf506fa95edc395f5cf6c7514fe9d"
+	v3TestAddress    = "0x008AeEda4D805471dF9b2A5B0f38A0C3bCBA786b"
+)
+
+func TestKeyStoreClient_KeyJSONRoundTrip(t *testing.T) {
+	store, err := NewPlainKeyStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewKeyStoreClient(store, logging.NewDevSlogger(nil))
+
+	fixture, err := os.ReadFile(filepath.Join("testdata", "v3_scrypt.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ImportKeyJSON("alice", fixture, "wrong password"); err == nil {
+		t.Error("ImportKeyJSON() expected error for wrong password")
+	}
+	if err := client.ImportKeyJSON("alice", fixture, v3TestPassword); err != nil {
+		t.Fatalf("ImportKeyJSON() error = %v", err)
+	}
+
+	imported, err := client.GetKeyByAlias("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.EvmAddress != common.HexToAddress(v3TestAddress) {
+		t.Errorf("ImportKeyJSON() address = %s, want %s", imported.EvmAddress.Hex(), v3TestAddress)
+	}
+	if got := hexutil.Encode(imported.PrivateKey.Bytes()); got != v3TestPrivateKey {
+		t.Errorf("ImportKeyJSON() private key = %s, want %s", got, v3TestPrivateKey)
+	}
+
+	exported, err := client.ExportKeyJSON("alice", "export password")
+	if err != nil {
+		t.Fatalf("ExportKeyJSON() error = %v", err)
+	}
+	ethKey, err := ethkeystore.DecryptKey(exported, "export password")
+	if err != nil {
+		t.Fatalf("go-ethereum can't decrypt the exported key: %v", err)
+	}
+	if ethKey.Address != imported.EvmAddress || !bytes.Equal(crypto.FromECDSA(ethKey.PrivateKey), imported.PrivateKey.Bytes()) {
+		t.Errorf("ExportKeyJSON() = %s, want the key of %s", ethKey.Address.Hex(), imported.EvmAddress.Hex())
+	}
+
+	if err := client.ImportKeyJSON("bob", exported, "export password"); err != nil {
+		t.Fatalf("ImportKeyJSON() of the exported key error = %v", err)
+	}
+	reimported, err := client.GetKeyByAlias("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reimported.EvmAddress != imported.EvmAddress || reimported.ElderAddress != imported.ElderAddress {
+		t.Errorf("re-imported key = %+v, want %+v", reimported, imported)
+	}
+}
+
+func TestKeyStoreClient_ImportMnemonicKeys(t *testing.T) {
+	const mnemonic = "test test test test test test test test test test test junk"
+
+	tests := []struct {
+		name string
+		// existing is imported before the keys
+		existing string
+		aliases  []string
+		mnemonic string
+		wantErr  bool
+	}{
+		{name: "all keys imported", aliases: []string{"key-0", "key-1", "key-2"}, mnemonic: mnemonic},
+		{name: "later alias exists", existing: "key-2", aliases: []string{"key-0", "key-1", "key-2"}, mnemonic: mnemonic, wantErr: true},
+		{name: "duplicate alias", aliases: []string{"key-0", "key-1", "key-0"}, mnemonic: mnemonic, wantErr: true},
+		{name: "invalid mnemonic", aliases: []string{"key-0", "key-1", "key-2"}, mnemonic: "test test junk", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewPlainKeyStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := NewKeyStoreClient(store, logging.NewDevSlogger(nil))
+			if tt.existing != "" {
+				if err := client.ImportPrivateKey(tt.existing, v3TestPrivateKey[2:]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			base, err := ParseHDPath(ElderHDPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paths, err := ConsecutivePaths(base, len(tt.aliases))
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys, err := client.ImportMnemonicKeys(tt.aliases, tt.mnemonic, paths)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ImportMnemonicKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			stored, err := client.ListKeys()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr {
+				if len(keys) != 0 {
+					t.Errorf("ImportMnemonicKeys() = %d keys, want none", len(keys))
+				}
+				for alias := range stored {
+					if alias != tt.existing {
+						t.Errorf("key %s was stored by a failed import", alias)
+					}
+				}
+				return
+			}
+			for i, alias := range tt.aliases {
+				if stored[alias] == nil || stored[alias].EvmAddress != keys[i].EvmAddress {
+					t.Errorf("key %s = %+v, want %s", alias, stored[alias], keys[i].EvmAddress.Hex())
+				}
+			}
+		})
+	}
+}
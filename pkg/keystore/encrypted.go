@@ -0,0 +1,430 @@
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	encryptedKeyVersion = 1
+
+	kdfScrypt     = "scrypt"
+	cipherAESGCM  = "aes-256-gcm"
+	scryptDKLen   = 32
+	scryptSaltLen = 32
+
+	// Same cost parameters go-ethereum uses for its "standard" keystore
+	defaultScryptN = 1 << 18
+	defaultScryptP = 1
+	scryptR        = 8
+)
+
+var ErrInvalidPassphrase = errors.New("invalid passphrase")
+
+type encryptedKeyJSON struct {
+	EvmAddress   common.Address `json:"evmAddress"`
+	ElderAddress string         `json:"elderAddress"`
+	Crypto       cryptoJSON     `json:"crypto"`
+	Version      int            `json:"version"`
+}
+
+type cryptoJSON struct {
+	Cipher     string       `json:"cipher"`
+	CipherText string       `json:"ciphertext"`
+	Nonce      string       `json:"nonce"`
+	KDF        string       `json:"kdf"`
+	KDFParams  scryptParams `json:"kdfparams"`
+}
+
+type scryptParams struct {
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	DKLen int    `json:"dklen"`
+	Salt  string `json:"salt"`
+}
+
+type cachedKey struct {
+	cipherText string
+	key        *Key
+}
+
+// EncryptedKeyStore implements the KeyStore interface on the file system, encrypting
+// every key file with an scrypt derived AES-256-GCM key.
+// The passphrase is requested lazily on first use and kept in memory afterwards.
+type EncryptedKeyStore struct {
+	baseDir    string
+	passphrase PassphraseFunc
+	scryptN    int
+	scryptP    int
+
+	mu       sync.Mutex
+	secret   string
+	unlocked bool
+	// decrypted keys by alias, decryption is expensive so keys are only
+	// decrypted again when their file content changes
+	cache map[string]cachedKey
+}
+
+func NewEncryptedKeyStore(baseDir string, passphrase PassphraseFunc) (*EncryptedKeyStore, error) {
+	if passphrase == nil {
+		return nil, fmt.Errorf("passphrase source cannot be nil")
+	}
+	if err := os.MkdirAll(baseDir, 0700); err != nil {
+		return nil, err
+	}
+	return &EncryptedKeyStore{
+		baseDir:    baseDir,
+		passphrase: passphrase,
+		scryptN:    defaultScryptN,
+		scryptP:    defaultScryptP,
+		cache:      make(map[string]cachedKey),
+	}, nil
+}
+
+// Unlock resolves the passphrase and verifies it against the keys already in the store.
+// It is called implicitly by every other method, calling it directly only allows to fail fast.
+// An empty store stays locked, the passphrase is chosen when the first key is stored.
+func (ks *EncryptedKeyStore) Unlock() error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	return ks.unlock(false)
+}
+
+func (ks *EncryptedKeyStore) Store(alias string, key *Key) error {
+	if len(alias) == 0 {
+		return fmt.Errorf("alias cannot be empty")
+	}
+	if key == nil {
+		return fmt.Errorf("key cannot be nil")
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if err := ks.unlock(true); err != nil {
+		return err
+	}
+
+	keyPath := ks.joinPath(alias)
+
+	// Check if file exists
+	if _, err := os.Stat(keyPath); err == nil {
+		return fmt.Errorf("key %s: %w", alias, ErrKeyExists)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to check key file: %w", err)
+	}
+
+	encrypted, err := ks.encryptKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt key: %w", err)
+	}
+
+	content, err := json.Marshal(encrypted)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key data: %w", err)
+	}
+
+	if err := writeContentToFile(keyPath, content); err != nil {
+		return err
+	}
+
+	ks.cache[alias] = cachedKey{cipherText: encrypted.Crypto.CipherText, key: key}
+	return nil
+}
+
+func (ks *EncryptedKeyStore) Load(alias string) (*Key, error) {
+	if len(alias) == 0 {
+		return nil, fmt.Errorf("alias cannot be empty")
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if err := ks.unlock(false); err != nil {
+		return nil, err
+	}
+	if !ks.unlocked {
+		return nil, fmt.Errorf("key %s: %w", alias, ErrKeyNotFound)
+	}
+
+	return ks.loadKey(alias)
+}
+
+func (ks *EncryptedKeyStore) Delete(alias string) error {
+	if len(alias) == 0 {
+		return fmt.Errorf("alias cannot be empty")
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	keyPath := ks.joinPath(alias)
+	if err := os.Remove(keyPath); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("key %s: %w", alias, ErrKeyNotFound)
+		}
+		return fmt.Errorf("failed to delete key file: %w", err)
+	}
+	delete(ks.cache, alias)
+	return nil
+}
+
+func (ks *EncryptedKeyStore) ListByAlias() (map[string]*Key, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if err := ks.unlock(false); err != nil {
+		return nil, err
+	}
+	if !ks.unlocked {
+		return map[string]*Key{}, nil
+	}
+
+	aliases, err := ks.aliases()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*Key)
+	for _, alias := range aliases {
+		key, err := ks.loadKey(alias)
+		if err != nil {
+			return nil, err
+		}
+		result[alias] = key
+	}
+	return result, nil
+}
+
+func (ks *EncryptedKeyStore) ListByEvmAddress() (map[common.Address]*Key, error) {
+	keys, err := ks.ListByAlias()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[common.Address]*Key)
+	for _, key := range keys {
+		result[key.EvmAddress] = key
+	}
+	return result, nil
+}
+
+func (ks *EncryptedKeyStore) ListByElderAddress() (map[string]*Key, error) {
+	keys, err := ks.ListByAlias()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]*Key)
+	for _, key := range keys {
+		result[key.ElderAddress] = key
+	}
+	return result, nil
+}
+
+// unlock must be called with ks.mu held. An empty store is left locked unless a key
+// is about to be written, read-only calls have nothing to decrypt.
+func (ks *EncryptedKeyStore) unlock(write bool) error {
+	if ks.unlocked {
+		return nil
+	}
+
+	aliases, err := ks.aliases()
+	if err != nil {
+		return err
+	}
+	if len(aliases) == 0 && !write {
+		return nil
+	}
+
+	// A new passphrase is being chosen when the store is still empty
+	secret, err := ks.passphrase(len(aliases) == 0)
+	if err != nil {
+		return fmt.Errorf("failed to get keystore passphrase: %w", err)
+	}
+	if len(secret) == 0 {
+		return fmt.Errorf("keystore passphrase cannot be empty")
+	}
+	ks.secret = secret
+
+	// Verify the passphrase against an existing key, so that a typo does not
+	// end up with keys encrypted under different passphrases
+	if len(aliases) > 0 {
+		if _, err := ks.loadKey(aliases[0]); err != nil {
+			ks.secret = ""
+			return err
+		}
+	}
+
+	ks.unlocked = true
+	return nil
+}
+
+// loadKey must be called with ks.mu held and the store unlocked
+func (ks *EncryptedKeyStore) loadKey(alias string) (*Key, error) {
+	content, err := os.ReadFile(ks.joinPath(alias))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("key %s: %w", alias, ErrKeyNotFound)
+		}
+		return nil, fmt.Errorf("failed to open key file: %w", err)
+	}
+
+	var encrypted encryptedKeyJSON
+	if err := json.Unmarshal(content, &encrypted); err != nil {
+		return nil, fmt.Errorf("failed to decode key %s: %w", alias, err)
+	}
+
+	if cached, ok := ks.cache[alias]; ok && cached.cipherText == encrypted.Crypto.CipherText {
+		return cached.key, nil
+	}
+
+	key, err := ks.decryptKey(&encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt key %s: %w", alias, err)
+	}
+
+	ks.cache[alias] = cachedKey{cipherText: encrypted.Crypto.CipherText, key: key}
+	return key, nil
+}
+
+func (ks *EncryptedKeyStore) encryptKey(key *Key) (*encryptedKeyJSON, error) {
+	plainText, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, scryptSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	params := scryptParams{
+		N:     ks.scryptN,
+		R:     scryptR,
+		P:     ks.scryptP,
+		DKLen: scryptDKLen,
+		Salt:  hex.EncodeToString(salt),
+	}
+
+	aead, err := newAEAD(ks.secret, params)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	// The plain text addresses are authenticated so they can't be swapped between files
+	cipherText := aead.Seal(nil, nonce, plainText, additionalData(key.EvmAddress, key.ElderAddress))
+
+	return &encryptedKeyJSON{
+		EvmAddress:   key.EvmAddress,
+		ElderAddress: key.ElderAddress,
+		Crypto: cryptoJSON{
+			Cipher:     cipherAESGCM,
+			CipherText: hex.EncodeToString(cipherText),
+			Nonce:      hex.EncodeToString(nonce),
+			KDF:        kdfScrypt,
+			KDFParams:  params,
+		},
+		Version: encryptedKeyVersion,
+	}, nil
+}
+
+func (ks *EncryptedKeyStore) decryptKey(encrypted *encryptedKeyJSON) (*Key, error) {
+	if encrypted.Version != encryptedKeyVersion {
+		return nil, fmt.Errorf("unsupported key version %d", encrypted.Version)
+	}
+	if encrypted.Crypto.Cipher != cipherAESGCM {
+		return nil, fmt.Errorf("unsupported cipher %q", encrypted.Crypto.Cipher)
+	}
+	if encrypted.Crypto.KDF != kdfScrypt {
+		return nil, fmt.Errorf("unsupported kdf %q", encrypted.Crypto.KDF)
+	}
+
+	nonce, err := hex.DecodeString(encrypted.Crypto.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce: %w", err)
+	}
+	cipherText, err := hex.DecodeString(encrypted.Crypto.CipherText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext: %w", err)
+	}
+
+	aead, err := newAEAD(ks.secret, encrypted.Crypto.KDFParams)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce length %d", len(nonce))
+	}
+
+	plainText, err := aead.Open(nil, nonce, cipherText, additionalData(encrypted.EvmAddress, encrypted.ElderAddress))
+	if err != nil {
+		return nil, ErrInvalidPassphrase
+	}
+
+	var key Key
+	if err := json.Unmarshal(plainText, &key); err != nil {
+		return nil, fmt.Errorf("failed to decode key data: %w", err)
+	}
+	return &key, nil
+}
+
+func (ks *EncryptedKeyStore) aliases() ([]string, error) {
+	files, err := os.ReadDir(ks.baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	var result []string
+	for _, file := range files {
+		if filepath.Ext(file.Name()) == ".json" {
+			result = append(result, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+	return result, nil
+}
+
+func (ks *EncryptedKeyStore) joinPath(alias string) string {
+	return filepath.Join(ks.baseDir, alias+".json")
+}
+
+func newAEAD(secret string, params scryptParams) (cipher.AEAD, error) {
+	salt, err := hex.DecodeString(params.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid salt: %w", err)
+	}
+
+	derivedKey, err := scrypt.Key([]byte(secret), salt, params.N, params.R, params.P, params.DKLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+
+	block, err := aes.NewCipher(derivedKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func additionalData(evmAddress common.Address, elderAddress string) []byte {
+	return append(evmAddress.Bytes(), []byte(elderAddress)...)
+}
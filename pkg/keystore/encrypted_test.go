@@ -0,0 +1,141 @@
+package keystore
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0xElder/elder/utils"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const testPrivateKeyHex = "ac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80"
+
+func newTestEncryptedKeyStore(t *testing.T, dir, passphrase string) *EncryptedKeyStore {
+	t.Helper()
+	ks, err := NewEncryptedKeyStore(dir, func(bool) (string, error) { return passphrase, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keep the tests fast
+	ks.scryptN = 1 << 4
+	return ks
+}
+
+func newTestKey(t *testing.T) *Key {
+	t.Helper()
+	privateKey, err := utils.PrivateKeyStringToSecp256k1PrivKey(testPrivateKeyHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Key{
+		EvmAddress:   common.HexToAddress("0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"),
+		ElderAddress: "elder1test",
+		PrivateKey:   privateKey,
+	}
+}
+
+func TestEncryptedKeyStore_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	key := newTestKey(t)
+
+	ks := newTestEncryptedKeyStore(t, dir, "correct horse")
+	if err := ks.Store("alice", key); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if err := ks.Store("alice", key); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("Store() duplicate error = %v, want %v", err, ErrKeyExists)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "alice.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "privateKey") {
+		t.Errorf("key file contains the plain private key: %s", content)
+	}
+
+	// A fresh store has to decrypt the key from disk
+	loaded, err := newTestEncryptedKeyStore(t, dir, "correct horse").Load("alice")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if loaded.EvmAddress != key.EvmAddress || loaded.ElderAddress != key.ElderAddress {
+		t.Errorf("Load() = %+v, want %+v", loaded, key)
+	}
+	want, _ := json.Marshal(key.PrivateKey)
+	got, _ := json.Marshal(loaded.PrivateKey)
+	if string(got) != string(want) {
+		t.Errorf("Load() private key mismatch")
+	}
+
+	byAddress, err := ks.ListByEvmAddress()
+	if err != nil {
+		t.Fatalf("ListByEvmAddress() error = %v", err)
+	}
+	if _, ok := byAddress[key.EvmAddress]; !ok {
+		t.Errorf("ListByEvmAddress() missing %s", key.EvmAddress.Hex())
+	}
+
+	if err := ks.Delete("alice"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := ks.Load("alice"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Load() after delete error = %v, want %v", err, ErrKeyNotFound)
+	}
+}
+
+func TestEncryptedKeyStore_WrongPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	if err := newTestEncryptedKeyStore(t, dir, "correct horse").Store("alice", newTestKey(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	ks := newTestEncryptedKeyStore(t, dir, "battery staple")
+	if _, err := ks.ListByAlias(); !errors.Is(err, ErrInvalidPassphrase) {
+		t.Errorf("ListByAlias() error = %v, want %v", err, ErrInvalidPassphrase)
+	}
+	if err := ks.Store("bob", newTestKey(t)); !errors.Is(err, ErrInvalidPassphrase) {
+		t.Errorf("Store() error = %v, want %v", err, ErrInvalidPassphrase)
+	}
+}
+
+func TestEncryptedKeyStore_EmptyStoreReadsWithoutPassphrase(t *testing.T) {
+	var calls []bool
+	ks, err := NewEncryptedKeyStore(t.TempDir(), func(confirm bool) (string, error) {
+		calls = append(calls, confirm)
+		return "correct horse", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks.scryptN = 1 << 4
+
+	if err := ks.Unlock(); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	keys, err := ks.ListByAlias()
+	if err != nil {
+		t.Fatalf("ListByAlias() error = %v", err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("ListByAlias() = %v, want empty", keys)
+	}
+	if _, err := ks.Load("alice"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Load() error = %v, want %v", err, ErrKeyNotFound)
+	}
+	if len(calls) != 0 {
+		t.Fatalf("passphrase requested %d times by read-only calls", len(calls))
+	}
+
+	// The passphrase is chosen, with confirmation, when the first key is written
+	if err := ks.Store("alice", newTestKey(t)); err != nil {
+		t.Fatalf("Store() error = %v", err)
+	}
+	if len(calls) != 1 || !calls[0] {
+		t.Errorf("passphrase calls = %v, want a single confirmed prompt", calls)
+	}
+}
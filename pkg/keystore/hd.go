@@ -0,0 +1,86 @@
+package keystore
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cosmos/cosmos-sdk/crypto/hd"
+	"github.com/cosmos/go-bip39"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	// ElderHDPath is the BIP-44 path of the first EVM account, as used by Elder, Hardhat and Foundry
+	ElderHDPath = "m/44'/60'/0'/0/0"
+	// CosmosHDPath is the BIP-44 path of the first Cosmos SDK account
+	CosmosHDPath = "m/44'/118'/0'/0/0"
+
+	mnemonicEntropyBits = 256
+	// hardenedOffset is the first index of the hardened BIP-32 children
+	hardenedOffset = 0x80000000
+)
+
+var hdPathPresets = map[string]string{
+	"elder":  ElderHDPath,
+	"cosmos": CosmosHDPath,
+}
+
+// ParseHDPath parses a BIP-32 derivation path, the elder and cosmos presets are accepted as well
+func ParseHDPath(path string) (accounts.DerivationPath, error) {
+	if preset, ok := hdPathPresets[strings.ToLower(path)]; ok {
+		path = preset
+	}
+	return accounts.ParseDerivationPath(path)
+}
+
+// NewMnemonic generates a new 24 words BIP-39 mnemonic
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(mnemonicEntropyBits)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// DeriveKeyFromMnemonic derives the secp256k1 private key at path from a BIP-39 mnemonic
+func DeriveKeyFromMnemonic(mnemonic string, path accounts.DerivationPath) (*ecdsa.PrivateKey, error) {
+	mnemonic = strings.Join(strings.Fields(mnemonic), " ")
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid mnemonic: %w", err)
+	}
+
+	master, chainCode := hd.ComputeMastersFromSeed(seed)
+	key, err := hd.DerivePrivateKeyForPath(master, chainCode, path.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive %s: %w", path, err)
+	}
+	return crypto.ToECDSA(key)
+}
+
+// ConsecutivePaths returns count paths starting at base, with the last index incremented for every
+// path. The last index can't cross from the normal into the hardened range nor overflow.
+func ConsecutivePaths(base accounts.DerivationPath, count int) ([]accounts.DerivationPath, error) {
+	if len(base) == 0 {
+		return nil, errors.New("empty derivation path")
+	}
+	last := uint64(base[len(base)-1])
+	limit := uint64(hardenedOffset)
+	if last >= hardenedOffset {
+		limit = 1 << 32
+	}
+	if last+uint64(count) > limit {
+		return nil, fmt.Errorf("%d keys from %s overflow the last index of the path", count, base)
+	}
+
+	paths := make([]accounts.DerivationPath, 0, count)
+	for i := 0; i < count; i++ {
+		path := append(accounts.DerivationPath{}, base...)
+		path[len(path)-1] += uint32(i)
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
@@ -0,0 +1,114 @@
+package keystore
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestDeriveKeyFromMnemonic(t *testing.T) {
+	// Default Hardhat and Foundry test accounts
+	const mnemonic = "test test test test test test test test test test test junk"
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "m/44'/60'/0'/0/0", want: "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"},
+		{path: "m/44'/60'/0'/0/1", want: "0x70997970C51812dc3A010C7d01b50e0d17dc79C8"},
+		{path: "elder", want: "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			path, err := ParseHDPath(tt.path)
+			if err != nil {
+				t.Fatalf("ParseHDPath() error = %v", err)
+			}
+			privateKey, err := DeriveKeyFromMnemonic(mnemonic, path)
+			if err != nil {
+				t.Fatalf("DeriveKeyFromMnemonic() error = %v", err)
+			}
+			if got := crypto.PubkeyToAddress(privateKey.PublicKey).Hex(); got != tt.want {
+				t.Errorf("DeriveKeyFromMnemonic() address = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeriveKeyFromMnemonic_Vectors(t *testing.T) {
+	// Fundraiser test vector of the Cosmos SDK
+	const mnemonic = "barrel original fuel morning among eternal filter ball stove pluck matrix mechanic"
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "cosmos", want: "bfcb217c058d8bbafd5e186eae936106ca3e943889b0b4a093ae13822fd3170c"},
+		{path: CosmosHDPath, want: "bfcb217c058d8bbafd5e186eae936106ca3e943889b0b4a093ae13822fd3170c"},
+		{path: ElderHDPath, want: "7fc4d8a8146dea344ba04c593517d3f377fa6cded36cd55aee0a0bb968e651bc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			path, err := ParseHDPath(tt.path)
+			if err != nil {
+				t.Fatalf("ParseHDPath() error = %v", err)
+			}
+			privateKey, err := DeriveKeyFromMnemonic(mnemonic, path)
+			if err != nil {
+				t.Fatalf("DeriveKeyFromMnemonic() error = %v", err)
+			}
+			if got := hex.EncodeToString(crypto.FromECDSA(privateKey)); got != tt.want {
+				t.Errorf("DeriveKeyFromMnemonic() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsecutivePaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     string
+		count    int
+		wantLast string
+		wantErr  bool
+	}{
+		{name: "increments the last index", base: ElderHDPath, count: 3, wantLast: "m/44'/60'/0'/0/2"},
+		{name: "last normal index", base: "m/44'/60'/0'/0/2147483646", count: 2, wantLast: "m/44'/60'/0'/0/2147483647"},
+		{name: "normal index crossing into hardened", base: "m/44'/60'/0'/0/2147483647", count: 2, wantErr: true},
+		{name: "hardened index", base: "m/44'/60'/0'", count: 2, wantLast: "m/44'/60'/1'"},
+		{name: "hardened index overflow", base: "m/44'/60'/2147483647'", count: 2, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, err := accounts.ParseDerivationPath(tt.base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paths, err := ConsecutivePaths(base, tt.count)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ConsecutivePaths() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(paths) != tt.count || paths[0].String() != base.String() || paths[len(paths)-1].String() != tt.wantLast {
+				t.Errorf("ConsecutivePaths() = %v, want %d paths from %s to %s", paths, tt.count, tt.base, tt.wantLast)
+			}
+		})
+	}
+}
+
+func TestDeriveKeyFromMnemonic_Invalid(t *testing.T) {
+	path, err := ParseHDPath(CosmosHDPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DeriveKeyFromMnemonic("test test test", path); err == nil {
+		t.Error("DeriveKeyFromMnemonic() expected error for invalid mnemonic")
+	}
+}
@@ -1,6 +1,7 @@
 package keystore
 
 import (
+	"crypto/ecdsa"
 	"errors"
 	"fmt"
 	"os"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/0xElder/elder/utils"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
 var (
@@ -21,7 +23,11 @@ type Key struct {
 	PrivateKey   utils.Secp256k1PrivateKey `json:"privateKey"`
 }
 
-// TODO : Update password param and implement password based keystore
+// ECDSA returns the private key in the form used by go-ethereum for signing
+func (k *Key) ECDSA() (*ecdsa.PrivateKey, error) {
+	return crypto.ToECDSA(k.PrivateKey.Bytes())
+}
+
 // KeyStore defines the interface for managing private keys
 type KeyStore interface {
 	// Store saves a key with an alias
@@ -0,0 +1,76 @@
+package keystore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// PassphraseEnvVar is the environment variable checked for the keystore passphrase
+const PassphraseEnvVar = "ELDER_WRAP_KEYSTORE_PASSWORD"
+
+// PassphraseFunc returns the keystore passphrase, confirm is true when a new passphrase is being chosen
+type PassphraseFunc func(confirm bool) (string, error)
+
+// NewPassphraseFunc returns a PassphraseFunc that reads the passphrase, in order of precedence,
+// from the PassphraseEnvVar environment variable, from passwordFile if set, or from an interactive prompt.
+func NewPassphraseFunc(passwordFile string) PassphraseFunc {
+	return func(confirm bool) (string, error) {
+		if passphrase, ok := os.LookupEnv(PassphraseEnvVar); ok {
+			return passphrase, nil
+		}
+		if passwordFile != "" {
+			return ReadPasswordFile(passwordFile)
+		}
+		return PromptPassphrase("Keystore passphrase: ", confirm)
+	}
+}
+
+// ReadPasswordFile reads a passphrase from the first line of a file
+func ReadPasswordFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password file: %w", err)
+	}
+	lines := strings.Split(string(content), "\n")
+	return strings.TrimRight(lines[0], "\r"), nil
+}
+
+// PromptPassphrase reads a passphrase from the terminal without echoing it,
+// asking for it a second time when confirm is set
+func PromptPassphrase(prompt string, confirm bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("stdin is not a terminal, set %s or a password file", PassphraseEnvVar)
+	}
+
+	passphrase, err := readPassword(fd, prompt)
+	if err != nil {
+		return "", err
+	}
+	if !confirm {
+		return passphrase, nil
+	}
+
+	repeated, err := readPassword(fd, "Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase != repeated {
+		return "", errors.New("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+func readPassword(fd int, prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return string(password), nil
+}
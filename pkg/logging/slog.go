@@ -3,6 +3,8 @@ package logging
 import (
 	"context"
 	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // slogLogger implements the Logger interface using slog.
@@ -13,8 +15,33 @@ type slogLogger struct {
 // NewLogger creates a new slogLogger with a specified handler.
 func NewLogger(handler slog.Handler) Logger {
 	return &slogLogger{
-		logger: slog.New(handler),
+		logger: slog.New(traceHandler{handler}),
+	}
+}
+
+// traceHandler adds the ids of the span in the context to the records, to find the logs of a trace
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, record slog.Record) error {
+	if ctx != nil {
+		if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+			record.AddAttrs(
+				slog.String("trace_id", spanContext.TraceID().String()),
+				slog.String("span_id", spanContext.SpanID().String()),
+			)
+		}
 	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
 }
 
 // Debug logs a message at Debug level.
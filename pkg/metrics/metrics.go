@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "elder_wrap"
+
+// Statuses counted by Submissions
+const (
+	StatusQueued    = "queued"
+	StatusBroadcast = "broadcast"
+	StatusIncluded  = "included"
+)
+
+// Reasons counted by SubmissionFailures
+const (
+	ReasonDecode       = "decode"
+	ReasonVerify       = "verify"
+	ReasonNonce        = "nonce"
+	ReasonPool         = "pool"
+	ReasonAccountQuery = "account_query"
+	ReasonBroadcast    = "broadcast"
+	ReasonInclusion    = "inclusion"
+	ReasonSponsor      = "sponsor"
+	ReasonPolicy       = "policy"
+	ReasonSimulation   = "simulation"
+)
+
+var (
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "JSON-RPC calls received, by rollapp and method.",
+	}, []string{"rollapp", "method"})
+
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Time to answer a JSON-RPC call or batch, by rollapp and method.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"rollapp", "method"})
+
+	Submissions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "submissions_total",
+		Help:      "Rollapp transactions reaching a submission status (queued, broadcast, included), by rollapp and status.",
+	}, []string{"rollapp", "status"})
+
+	SubmissionFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "submission_failures_total",
+		Help:      "Rollapp transactions which failed to be submitted or included, by rollapp and reason.",
+	}, []string{"rollapp", "reason"})
+
+	BroadcastDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "elder_broadcast_duration_seconds",
+		Help:      "Time to build and broadcast an Elder transaction, by rollapp.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"rollapp"})
+
+	InclusionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "inclusion_duration_seconds",
+		Help:      "Time from Elder broadcast to inclusion in a rollapp block, by rollapp.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"rollapp"})
+
+	UpstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Time of the calls relayed to the rollapp RPCs, by rollapp, upstream host and status.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"rollapp", "upstream", "status"})
+
+	UpstreamHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_healthy",
+		Help:      "Whether a rollapp RPC is in rotation, by rollapp and upstream host.",
+	}, []string{"rollapp", "upstream"})
+
+	UpstreamBlockNumber = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_block_number",
+		Help:      "Latest block number of a rollapp RPC, by rollapp and upstream host.",
+	}, []string{"rollapp", "upstream"})
+
+	Sponsored = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sponsored_total",
+		Help:      "Rollapp transactions whose Elder fees the sponsor key pays, by rollapp.",
+	}, []string{"rollapp"})
+
+	ElderBatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "elder_batch_size",
+		Help:      "Messages packed in each batched Elder transaction.",
+		Buckets:   prometheus.LinearBuckets(1, 4, 8),
+	})
+
+	TxPoolQueued = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tx_pool_queued",
+		Help:      "Transactions waiting in the pool for a nonce gap to be filled, by rollapp.",
+	}, []string{"rollapp"})
+
+	TxPoolPending = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tx_pool_pending",
+		Help:      "Transactions submitted to Elder, or being broadcast, and waiting for rollapp inclusion, by rollapp.",
+	}, []string{"rollapp"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		Requests,
+		RequestDuration,
+		Submissions,
+		SubmissionFailures,
+		BroadcastDuration,
+		InclusionDuration,
+		UpstreamDuration,
+		UpstreamHealthy,
+		UpstreamBlockNumber,
+		Sponsored,
+		ElderBatchSize,
+		TxPoolQueued,
+		TxPoolPending,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// knownNamespaces are the JSON-RPC namespaces whose methods are used as label values
+var knownNamespaces = []string{"eth_", "net_", "web3_", "debug_", "txpool_", "personal_", "elder_"}
+
+// MethodLabel returns the method as a label value, malformed methods and methods outside the
+// known namespaces are reported as "other" to bound the number of series clients can create
+func MethodLabel(method string) string {
+	if len(method) > 64 || strings.IndexFunc(method, func(c rune) bool {
+		return !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9')
+	}) >= 0 {
+		return "other"
+	}
+	for _, prefix := range knownNamespaces {
+		if strings.HasPrefix(method, prefix) {
+			return method
+		}
+	}
+	return "other"
+}
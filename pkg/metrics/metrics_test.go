@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMethodLabel(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		want   string
+	}{
+		{name: "eth method", method: "eth_sendRawTransaction", want: "eth_sendRawTransaction"},
+		{name: "elder method", method: "elder_getSubmission", want: "elder_getSubmission"},
+		{name: "unknown namespace", method: "foo_bar", want: "other"},
+		{name: "invalid characters", method: "eth_call\n", want: "other"},
+		{name: "too long", method: "eth_" + strings.Repeat("a", 64), want: "other"},
+		{name: "empty", method: "", want: "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MethodLabel(tt.method); got != tt.want {
+				t.Errorf("MethodLabel(%q) = %q, want %q", tt.method, got, tt.want)
+			}
+		})
+	}
+}
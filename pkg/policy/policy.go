@@ -0,0 +1,443 @@
+package policy
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Actions of a rule
+const (
+	ActionAllow      = "allow"
+	ActionDeny       = "deny"
+	ActionRequireKey = "require_key"
+)
+
+// File is the policy file, its rules are evaluated in order and the first matching rule decides.
+// Transactions no rule matches get the default action, allow when empty.
+type File struct {
+	Default string `yaml:"default"`
+	Rules   []Rule `yaml:"rules"`
+}
+
+// Rule matches transactions on every condition it sets, a condition left empty matches any transaction.
+// The limits apply to the transactions an allow or require_key rule matches.
+type Rule struct {
+	Name      string   `yaml:"name"`
+	RollApps  []string `yaml:"rollapps"`
+	Senders   []string `yaml:"senders"`
+	To        []string `yaml:"to"`
+	Selectors []string `yaml:"selectors"`
+	Action    string   `yaml:"action"`
+	// Key is the alias of the key which must submit the transactions of a require_key rule
+	Key string `yaml:"key"`
+	// MaxValue is in wei
+	MaxValue   string `yaml:"max_value"`
+	MaxGas     uint64 `yaml:"max_gas"`
+	SenderRate Rate   `yaml:"sender_rate"`
+	// MaxDailyElderFee is the Elder fee budget of the transactions the rule matches per UTC day, in the
+	// denom of the Elder gas price
+	MaxDailyElderFee uint64 `yaml:"max_daily_elder_fee"`
+}
+
+// Rate allows Txs transactions per sender within Interval, unlimited when Txs is zero
+type Rate struct {
+	Txs      int           `yaml:"txs"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Keys loads the keys named by require_key rules, keystore.KeyStore implements it
+type Keys interface {
+	Load(alias string) (*keystore.Key, error)
+}
+
+// Request is a transaction about to be submitted to Elder
+type Request struct {
+	RollApp string
+	Sender  common.Address
+	// Key is the key submitting the transaction to Elder
+	Key *keystore.Key
+	Tx  *types.Transaction
+	// Fee is the estimated Elder fee of the transaction, reserved in the fee budget until it is broadcast
+	Fee uint64
+}
+
+// DeniedError is returned by Evaluate for the transactions the policy rejects
+type DeniedError struct {
+	// Rule is the name of the rule which rejected the transaction, empty for the default action
+	Rule   string
+	Reason string
+}
+
+func (e *DeniedError) Error() string {
+	if e.Rule == "" {
+		return e.Reason
+	}
+	return fmt.Sprintf("rule %s: %s", e.Rule, e.Reason)
+}
+
+// Engine evaluates the rules of a policy file
+type Engine struct {
+	file  File
+	rules []*rule
+	keys  Keys
+	now   func() time.Time
+}
+
+// rule is a Rule with its conditions parsed and its usage
+type rule struct {
+	Rule
+	rollApps  map[string]bool
+	senders   map[common.Address]bool
+	to        map[common.Address]bool
+	selectors map[[4]byte]bool
+	maxValue  *big.Int
+	usage     *usage
+}
+
+// usage is the sender rate and fee budget used by the transactions a rule matched, the transactions
+// being broadcast are counted with their reservation
+type usage struct {
+	mu sync.Mutex
+	// sent holds the times of the recent transactions of each sender, for the sender rate
+	sent   map[common.Address][]time.Time
+	pruned time.Time
+	// day is the UTC day fees are counted for
+	day  time.Time
+	fees uint64
+}
+
+// Reservation is the sender rate slot and the estimated Elder fee held by Evaluate for a transaction.
+// It is settled with the fee paid once the transaction is broadcast, or released when it is not.
+type Reservation struct {
+	rule   *rule
+	sender common.Address
+	// sent is the time of the transaction in the sender rate, zero when the rule has none
+	sent time.Time
+	day  time.Time
+	fee  uint64
+	now  func() time.Time
+	done bool
+}
+
+// Load reads and checks the policy file at path
+func Load(path string, keys Keys) (*Engine, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read policy file")
+	}
+
+	var file File
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, errors.Wrap(err, "failed to parse policy file")
+	}
+	return New(file, keys)
+}
+
+// New checks the policy file and returns its engine
+func New(file File, keys Keys) (*Engine, error) {
+	switch file.Default {
+	case "":
+		file.Default = ActionAllow
+	case ActionAllow, ActionDeny:
+	default:
+		return nil, fmt.Errorf("default must be %s or %s", ActionAllow, ActionDeny)
+	}
+
+	e := &Engine{file: file, keys: keys, now: time.Now}
+	names := make(map[string]bool, len(file.Rules))
+	for i, r := range file.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule%d", i+1)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("rule %s is defined twice", r.Name)
+		}
+		names[r.Name] = true
+
+		parsed, err := parseRule(r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid rule %s", r.Name)
+		}
+		e.rules = append(e.rules, parsed)
+	}
+	return e, nil
+}
+
+func parseRule(r Rule) (*rule, error) {
+	switch r.Action {
+	case ActionAllow, ActionDeny:
+		if r.Key != "" {
+			return nil, fmt.Errorf("key is only used by %s", ActionRequireKey)
+		}
+	case ActionRequireKey:
+		if r.Key == "" {
+			return nil, fmt.Errorf("key is required with %s", ActionRequireKey)
+		}
+	default:
+		return nil, fmt.Errorf("action must be %s, %s or %s", ActionAllow, ActionDeny, ActionRequireKey)
+	}
+
+	parsed := &rule{
+		Rule:      r,
+		rollApps:  make(map[string]bool, len(r.RollApps)),
+		senders:   make(map[common.Address]bool, len(r.Senders)),
+		to:        make(map[common.Address]bool, len(r.To)),
+		selectors: make(map[[4]byte]bool, len(r.Selectors)),
+		usage:     &usage{sent: make(map[common.Address][]time.Time)},
+	}
+	for _, rollApp := range r.RollApps {
+		parsed.rollApps[rollApp] = true
+	}
+	for _, sender := range r.Senders {
+		if !common.IsHexAddress(sender) {
+			return nil, fmt.Errorf("invalid sender %s", sender)
+		}
+		parsed.senders[common.HexToAddress(sender)] = true
+	}
+	for _, to := range r.To {
+		if !common.IsHexAddress(to) {
+			return nil, fmt.Errorf("invalid to address %s", to)
+		}
+		parsed.to[common.HexToAddress(to)] = true
+	}
+	for _, selector := range r.Selectors {
+		decoded, err := hex.DecodeString(strings.TrimPrefix(selector, "0x"))
+		if err != nil || len(decoded) != 4 {
+			return nil, fmt.Errorf("invalid selector %s, expected 4 bytes in hex", selector)
+		}
+		parsed.selectors[[4]byte(decoded)] = true
+	}
+	if r.MaxValue != "" {
+		maxValue, ok := new(big.Int).SetString(r.MaxValue, 10)
+		if !ok || maxValue.Sign() < 0 {
+			return nil, fmt.Errorf("invalid max_value %s, expected an amount of wei", r.MaxValue)
+		}
+		parsed.maxValue = maxValue
+	}
+	if r.SenderRate.Txs < 0 || r.SenderRate.Interval < 0 {
+		return nil, fmt.Errorf("sender_rate can't be negative")
+	}
+	if r.SenderRate.Txs > 0 && r.SenderRate.Interval == 0 {
+		return nil, fmt.Errorf("sender_rate.interval is required with sender_rate.txs")
+	}
+	return parsed, nil
+}
+
+// Continue takes over the sender rates and fee budgets counted by the rules of previous which are
+// defined the same, so reloading the policy file does not reset them. The usage is shared with
+// previous, the reservations it made are settled in it. Continue must be called before Evaluate.
+func (e *Engine) Continue(previous *Engine) {
+	if previous == nil {
+		return
+	}
+	for _, r := range e.rules {
+		for _, old := range previous.rules {
+			if old.Name == r.Name && reflect.DeepEqual(old.Rule, r.Rule) {
+				r.usage = old.usage
+			}
+		}
+	}
+}
+
+// FeeBudgets returns whether a rule limits the Elder fees, they are only known when elder-wrap signs
+// the Elder transactions
+func (e *Engine) FeeBudgets() bool {
+	for _, r := range e.rules {
+		if r.MaxDailyElderFee > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate returns a DeniedError when the policy rejects the transaction. A transaction it allows is
+// reserved in the sender rate and fee budget of its rule, with req.Fee as its Elder fee, in the same
+// step as the limits are checked, so concurrent transactions can't get past them together. The
+// reservation has to be settled or released, it is nil when no rule matched.
+func (e *Engine) Evaluate(ctx context.Context, req Request) (*Reservation, error) {
+	for _, r := range e.rules {
+		if !r.matches(req) {
+			continue
+		}
+		switch r.Action {
+		case ActionDeny:
+			return nil, &DeniedError{Rule: r.Name, Reason: "transaction denied"}
+		case ActionRequireKey:
+			key, err := e.keys.Load(r.Key)
+			if err != nil {
+				return nil, errors.Wrapf(err, "rule %s: failed to load key %s", r.Name, r.Key)
+			}
+			if key.ElderAddress != req.Key.ElderAddress {
+				return nil, &DeniedError{Rule: r.Name, Reason: fmt.Sprintf("transaction must be submitted with key %s", r.Key)}
+			}
+		}
+		return r.reserve(req, e.now)
+	}
+
+	if e.file.Default == ActionDeny {
+		return nil, &DeniedError{Reason: "no rule allows the transaction"}
+	}
+	return nil, nil
+}
+
+// Settle counts the reserved transaction with the Elder fee paid for it, once it is broadcast.
+// A transaction reserved on a previous UTC day is counted on the current one.
+func (res *Reservation) Settle(fee uint64) {
+	if res == nil {
+		return
+	}
+	u := res.rule.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if res.done {
+		return
+	}
+	res.done = true
+	if res.rule.MaxDailyElderFee > 0 {
+		if u.rollDay(res.now()) || !u.day.Equal(res.day) {
+			u.fees += fee
+		} else {
+			u.fees = u.fees - res.fee + fee
+		}
+	}
+}
+
+// Release gives back the rate slot and the fee of a transaction which was not broadcast
+func (res *Reservation) Release() {
+	if res == nil {
+		return
+	}
+	u := res.rule.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if res.done {
+		return
+	}
+	res.done = true
+	if !res.sent.IsZero() {
+		sent := u.sent[res.sender]
+		for i := range sent {
+			if sent[i].Equal(res.sent) {
+				u.sent[res.sender] = append(sent[:i:i], sent[i+1:]...)
+				break
+			}
+		}
+	}
+	if res.rule.MaxDailyElderFee > 0 && !u.rollDay(res.now()) && u.day.Equal(res.day) {
+		u.fees -= res.fee
+	}
+}
+
+func (r *rule) matches(req Request) bool {
+	if len(r.rollApps) > 0 && !r.rollApps[req.RollApp] {
+		return false
+	}
+	if len(r.senders) > 0 && !r.senders[req.Sender] {
+		return false
+	}
+	if len(r.to) > 0 {
+		if to := req.Tx.To(); to == nil || !r.to[*to] {
+			return false
+		}
+	}
+	if len(r.selectors) > 0 {
+		data := req.Tx.Data()
+		if len(data) < 4 || !r.selectors[[4]byte(data[:4])] {
+			return false
+		}
+	}
+	return true
+}
+
+// reserve checks the limits of the rule and reserves the transaction in its sender rate and fee budget
+func (r *rule) reserve(req Request, now func() time.Time) (*Reservation, error) {
+	if r.maxValue != nil && req.Tx.Value().Cmp(r.maxValue) > 0 {
+		return nil, &DeniedError{Rule: r.Name, Reason: fmt.Sprintf("value %s above max_value %s", req.Tx.Value(), r.maxValue)}
+	}
+	if r.MaxGas > 0 && req.Tx.Gas() > r.MaxGas {
+		return nil, &DeniedError{Rule: r.Name, Reason: fmt.Sprintf("gas %d above max_gas %d", req.Tx.Gas(), r.MaxGas)}
+	}
+
+	u := r.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	t := now()
+	var recent []time.Time
+	if r.SenderRate.Txs > 0 {
+		recent = u.recent(req.Sender, t, r.SenderRate.Interval)
+		if len(recent) >= r.SenderRate.Txs {
+			return nil, &DeniedError{Rule: r.Name, Reason: fmt.Sprintf("sender rate of %d transactions per %s exceeded", r.SenderRate.Txs, r.SenderRate.Interval)}
+		}
+	}
+	if r.MaxDailyElderFee > 0 {
+		u.rollDay(t)
+		if u.fees >= r.MaxDailyElderFee {
+			return nil, &DeniedError{Rule: r.Name, Reason: fmt.Sprintf("daily Elder fee budget of %d spent", r.MaxDailyElderFee)}
+		}
+	}
+
+	res := &Reservation{rule: r, sender: req.Sender, day: u.day, now: now}
+	if r.SenderRate.Txs > 0 {
+		res.sent = t
+		u.sent[req.Sender] = append(recent, t)
+	}
+	if r.MaxDailyElderFee > 0 {
+		res.fee = req.Fee
+		u.fees += req.Fee
+	}
+	return res, nil
+}
+
+// recent returns the times of the transactions of sender within interval and forgets the older ones,
+// must be called with u.mu held
+func (u *usage) recent(sender common.Address, now time.Time, interval time.Duration) []time.Time {
+	u.prune(now, interval)
+	var recent []time.Time
+	for _, sent := range u.sent[sender] {
+		if now.Sub(sent) < interval {
+			recent = append(recent, sent)
+		}
+	}
+	if len(recent) > 0 {
+		u.sent[sender] = recent
+	}
+	return recent
+}
+
+// rollDay resets the fee budget on a new UTC day and returns true, must be called with u.mu held
+func (u *usage) rollDay(now time.Time) bool {
+	if day := now.UTC().Truncate(24 * time.Hour); !day.Equal(u.day) {
+		u.day, u.fees = day, 0
+		return true
+	}
+	return false
+}
+
+// prune forgets the senders without a transaction within interval, at most once per interval
+func (u *usage) prune(now time.Time, interval time.Duration) {
+	if now.Sub(u.pruned) < interval {
+		return
+	}
+	u.pruned = now
+	for sender, sent := range u.sent {
+		if len(sent) == 0 || now.Sub(sent[len(sent)-1]) >= interval {
+			delete(u.sent, sender)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package policy
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+)
+
+// testKeys are the keys of the keystore by alias
+type testKeys map[string]*keystore.Key
+
+func (k testKeys) Load(alias string) (*keystore.Key, error) {
+	key, ok := k[alias]
+	if !ok {
+		return nil, errors.New("key not found")
+	}
+	return key, nil
+}
+
+var (
+	alice    = common.HexToAddress("0x00000000000000000000000000000000000000a1")
+	bob      = common.HexToAddress("0x00000000000000000000000000000000000000b0")
+	token    = common.HexToAddress("0x00000000000000000000000000000000000000c1")
+	treasury = &keystore.Key{ElderAddress: "elder1treasury"}
+	hotKey   = &keystore.Key{ElderAddress: "elder1hot"}
+)
+
+func tx(to *common.Address, data []byte, value int64, gas uint64) *types.Transaction {
+	return types.NewTx(&types.LegacyTx{To: to, Data: data, Value: big.NewInt(value), Gas: gas, GasPrice: big.NewInt(1)})
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name: "valid policy",
+			content: `
+default: deny
+rules:
+  - name: transfers
+    to: [0x00000000000000000000000000000000000000c1]
+    selectors: ["0xa9059cbb"]
+    action: allow
+    max_value: "1000000000000000000"
+    sender_rate: {txs: 10, interval: 1m}
+    max_daily_elder_fee: 1000000
+`,
+			wantErr: false,
+		},
+		{
+			name:    "invalid action",
+			content: "rules:\n  - action: relay\n",
+			wantErr: true,
+		},
+		{
+			name:    "require_key without key",
+			content: "rules:\n  - action: require_key\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid selector",
+			content: "rules:\n  - action: deny\n    selectors: [\"0xa9059c\"]\n",
+			wantErr: true,
+		},
+		{
+			name:    "duplicate rule names",
+			content: "rules:\n  - name: a\n    action: allow\n  - name: a\n    action: deny\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policy.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Load(path, testKeys{}); (err != nil) != tt.wantErr {
+				t.Errorf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEngine_Evaluate(t *testing.T) {
+	transfer := []byte{0xa9, 0x05, 0x9c, 0xbb, 0x01}
+
+	// fee is the Elder fee spent when the submission is allowed
+	type submission struct {
+		req      Request
+		fee      uint64
+		wantRule string
+		wantErr  bool
+	}
+	tests := []struct {
+		name        string
+		file        File
+		submissions []submission
+	}{
+		{
+			name: "first matching rule decides",
+			file: File{
+				Default: ActionDeny,
+				Rules: []Rule{
+					{Name: "block-bob", Senders: []string{bob.Hex()}, Action: ActionDeny},
+					{Name: "transfers", To: []string{token.Hex()}, Selectors: []string{"0xa9059cbb"}, Action: ActionAllow},
+				},
+			},
+			submissions: []submission{
+				{req: Request{Sender: alice, Key: hotKey, Tx: tx(&token, transfer, 0, 21000)}, wantErr: false},
+				{req: Request{Sender: bob, Key: hotKey, Tx: tx(&token, transfer, 0, 21000)}, wantRule: "block-bob", wantErr: true},
+				{req: Request{Sender: alice, Key: hotKey, Tx: tx(&token, []byte{0x01}, 0, 21000)}, wantErr: true},
+				{req: Request{Sender: alice, Key: hotKey, Tx: tx(nil, transfer, 0, 21000)}, wantErr: true},
+			},
+		},
+		{
+			name: "rollapps",
+			file: File{Rules: []Rule{{Name: "frozen", RollApps: []string{"rollup2"}, Action: ActionDeny}}},
+			submissions: []submission{
+				{req: Request{RollApp: "rollup1", Sender: alice, Key: hotKey, Tx: tx(&token, nil, 0, 21000)}, wantErr: false},
+				{req: Request{RollApp: "rollup2", Sender: alice, Key: hotKey, Tx: tx(&token, nil, 0, 21000)}, wantRule: "frozen", wantErr: true},
+			},
+		},
+		{
+			name: "value and gas caps",
+			file: File{Rules: []Rule{{Name: "caps", Action: ActionAllow, MaxValue: "100", MaxGas: 50000}}},
+			submissions: []submission{
+				{req: Request{Sender: alice, Key: hotKey, Tx: tx(&token, nil, 100, 50000)}, wantErr: false},
+				{req: Request{Sender: alice, Key: hotKey, Tx: tx(&token, nil, 101, 21000)}, wantRule: "caps", wantErr: true},
+				{req: Request{Sender: alice, Key: hotKey, Tx: tx(&token, nil, 0, 50001)}, wantRule: "caps", wantErr: true},
+			},
+		},
+		{
+			name: "required key",
+			file: File{Rules: []Rule{{Name: "treasury", To: []string{token.Hex()}, Action: ActionRequireKey, Key: "treasury"}}},
+			submissions: []submission{
+				{req: Request{Sender: alice, Key: treasury, Tx: tx(&token, nil, 0, 21000)}, wantErr: false},
+				{req: Request{Sender: alice, Key: hotKey, Tx: tx(&token, nil, 0, 21000)}, wantRule: "treasury", wantErr: true},
+			},
+		},
+		{
+			name: "sender rate",
+			file: File{Rules: []Rule{{Name: "rate", Action: ActionAllow, SenderRate: Rate{Txs: 2, Interval: time.Minute}}}},
+			submissions: []submission{
+				{req: Request{Sender: alice, Key: hotKey, Tx: tx(&token, nil, 0, 21000)}, wantErr: false},
+				{req: Request{Sender: alice, Key: hotKey, Tx: tx(&token, nil, 0, 21000)}, wantErr: false},
+				{req: Request{Sender: alice, Key: hotKey, Tx: tx(&token, nil, 0, 21000)}, wantRule: "rate", wantErr: true},
+				{req: Request{Sender: bob, Key: hotKey, Tx: tx(&token, nil, 0, 21000)}, wantErr: false},
+			},
+		},
+		{
+			name: "daily Elder fee budget",
+			file: File{Rules: []Rule{{Name: "budget", Action: ActionAllow, MaxDailyElderFee: 1000}}},
+			submissions: []submission{
+				{req: Request{Sender: alice, Key: hotKey, Tx: tx(&token, nil, 0, 21000)}, fee: 600, wantErr: false},
+				{req: Request{Sender: bob, Key: hotKey, Tx: tx(&token, nil, 0, 21000)}, fee: 600, wantErr: false},
+				{req: Request{Sender: alice, Key: hotKey, Tx: tx(&token, nil, 0, 21000)}, fee: 600, wantRule: "budget", wantErr: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := New(tt.file, testKeys{"treasury": treasury})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, s := range tt.submissions {
+				res, err := engine.Evaluate(context.Background(), s.req)
+				if (err != nil) != s.wantErr {
+					t.Fatalf("Evaluate() submission %d error = %v, wantErr %v", i, err, s.wantErr)
+				}
+				var denied *DeniedError
+				if err != nil && (!errors.As(err, &denied) || denied.Rule != s.wantRule) {
+					t.Errorf("Evaluate() submission %d error = %v, want rule %q", i, err, s.wantRule)
+				}
+				if err == nil {
+					res.Settle(s.fee)
+				}
+			}
+		})
+	}
+}
+
+func TestEngine_Continue(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	file := File{Rules: []Rule{{Name: "rate", Action: ActionAllow, SenderRate: Rate{Txs: 1, Interval: time.Hour}}}}
+	req := Request{Sender: alice, Key: hotKey, Tx: tx(&token, nil, 0, 21000)}
+
+	previous, err := New(file, testKeys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous.now = func() time.Time { return now }
+	res, err := previous.Evaluate(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Settle(0)
+
+	reloaded, err := New(file, testKeys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded.now = func() time.Time { return now }
+	reloaded.Continue(previous)
+	if _, err := reloaded.Evaluate(context.Background(), req); err == nil {
+		t.Error("Evaluate() after Continue did not keep the sender rate")
+	}
+
+	file.Rules[0].SenderRate.Txs = 2
+	changed, err := New(file, testKeys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed.now = func() time.Time { return now }
+	changed.Continue(previous)
+	if _, err := changed.Evaluate(context.Background(), req); err != nil {
+		t.Errorf("Evaluate() after Continue with a changed rule error = %v", err)
+	}
+}
+
+func TestReservation(t *testing.T) {
+	file := File{Rules: []Rule{
+		{Name: "rate", Senders: []string{alice.Hex()}, Action: ActionAllow, SenderRate: Rate{Txs: 1, Interval: time.Hour}},
+		{Name: "budget", Action: ActionAllow, MaxDailyElderFee: 1000},
+	}}
+	engine, err := New(file, testKeys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !engine.FeeBudgets() {
+		t.Error("FeeBudgets() = false with max_daily_elder_fee")
+	}
+
+	for _, req := range []Request{
+		{Sender: alice, Key: hotKey, Tx: tx(&token, nil, 0, 21000)},
+		{Sender: bob, Key: hotKey, Tx: tx(&token, nil, 0, 21000), Fee: 1000},
+	} {
+		// Transactions failing after Evaluate, before their broadcast, give their reservation back
+		for i := 0; i < 3; i++ {
+			res, err := engine.Evaluate(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Evaluate() of %s after Release error = %v", req.Sender.Hex(), err)
+			}
+			res.Release()
+		}
+
+		res, err := engine.Evaluate(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := engine.Evaluate(context.Background(), req); err == nil {
+			t.Errorf("Evaluate() of %s with a reservation error = nil, want the limit of its rule", req.Sender.Hex())
+		}
+		res.Settle(1000)
+		res.Release()
+		if _, err := engine.Evaluate(context.Background(), req); err == nil {
+			t.Errorf("Evaluate() of %s after Settle error = nil, want the limit of its rule", req.Sender.Hex())
+		}
+	}
+}
+
+func TestReservation_settleActualFee(t *testing.T) {
+	engine, err := New(File{Rules: []Rule{{Name: "budget", Action: ActionAllow, MaxDailyElderFee: 1000}}}, testKeys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := Request{Sender: alice, Key: hotKey, Tx: tx(&token, nil, 0, 21000), Fee: 1000}
+
+	res, err := engine.Evaluate(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The estimate was above the fee paid, the rest of the budget is available again
+	res.Settle(400)
+	if _, err := engine.Evaluate(context.Background(), req); err != nil {
+		t.Errorf("Evaluate() after settling below the estimate error = %v", err)
+	}
+}
+
+func TestEngine_Evaluate_concurrent(t *testing.T) {
+	const limit, attempts = 3, 20
+	file := File{Rules: []Rule{
+		{Name: "rate", Senders: []string{alice.Hex()}, Action: ActionAllow, SenderRate: Rate{Txs: limit, Interval: time.Hour}},
+		{Name: "budget", Action: ActionAllow, MaxDailyElderFee: limit * 100},
+	}}
+	engine, err := New(file, testKeys{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, req := range []Request{
+		{Sender: alice, Key: hotKey, Tx: tx(&token, nil, 0, 21000)},
+		{Sender: bob, Key: hotKey, Tx: tx(&token, nil, 0, 21000), Fee: 100},
+	} {
+		var wg sync.WaitGroup
+		var allowed atomic.Int32
+		start := make(chan struct{})
+		for i := 0; i < attempts; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				if _, err := engine.Evaluate(context.Background(), req); err == nil {
+					allowed.Add(1)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		if allowed.Load() != limit {
+			t.Errorf("Evaluate() allowed %d of %d concurrent transactions of %s, want %d", allowed.Load(), attempts, req.Sender.Hex(), limit)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package rollapp
+
+import (
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/pkg/errors"
+)
+
+// Standard JSON-RPC 2.0 error codes
+const (
+	ParseErrorCode     = -32700
+	InvalidRequestCode = -32600
+	MethodNotFoundCode = -32601
+	InvalidParamsCode  = -32602
+	InternalErrorCode  = -32603
+)
+
+// elder-wrap error codes, they use -32010 to -32049 in the server error range of JSON-RPC 2.0
+const (
+	// NonceMismatchCode is returned when the transaction nonce was already used
+	NonceMismatchCode = -32010
+	// UnknownKeyCode is returned when the keystore has no key for the sender
+	UnknownKeyCode = -32011
+	// ChainIdMismatchCode is returned when the transaction is signed for another chain than the rollApp
+	ChainIdMismatchCode = -32012
+	// BroadcastFailedCode is returned when the Elder transaction could not be broadcast
+	BroadcastFailedCode = -32013
+	// InclusionTimeoutCode is returned when the Elder transaction was not included in a rollApp block
+	InclusionTimeoutCode = -32014
+	// TxPoolRejectedCode is returned when a transaction with a future nonce can't be queued
+	TxPoolRejectedCode = -32015
+	// ShuttingDownCode is returned when elder-wrap is shutting down and no longer accepts transactions
+	ShuttingDownCode = -32016
+	// SubmissionPausedCode is returned when submissions to the rollApp were paused by an operator
+	SubmissionPausedCode = -32017
+	// SponsorRejectedCode is returned when the sponsor policy does not pay for the transaction
+	SponsorRejectedCode = -32018
+	// PolicyRejectedCode is returned when a rule of the policy file rejects the transaction
+	PolicyRejectedCode = -32019
+	// SimulationFailedCode is returned when the transaction would revert or can't be paid for on the rollApp
+	SimulationFailedCode = -32020
+)
+
+var (
+	ErrUnknownKey       = NewRPCError(UnknownKeyCode, "key not found in keystore", nil)
+	ErrShuttingDown     = NewRPCError(ShuttingDownCode, "elder-wrap is shutting down", nil)
+	ErrSubmissionPaused = NewRPCError(SubmissionPausedCode, "submissions are paused", nil)
+)
+
+// RPCError is a JSON-RPC 2.0 error object
+type RPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+	cause   error
+}
+
+func NewRPCError(code int, message string, data interface{}) *RPCError {
+	return &RPCError{Code: code, Message: message, Data: data}
+}
+
+// withCode attaches a JSON-RPC error code to err, keeping its message
+func withCode(code int, err error, data interface{}) *RPCError {
+	return &RPCError{Code: code, Message: err.Error(), Data: data, cause: err}
+}
+
+// chainIdMismatch is the error of a transaction signed for chain got on a rollApp with chain id expected
+func chainIdMismatch(expected, got uint64) *RPCError {
+	return NewRPCError(ChainIdMismatchCode, "chain id mismatch", map[string]hexutil.Uint64{
+		"expected": hexutil.Uint64(expected),
+		"got":      hexutil.Uint64(got),
+	})
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+func (e *RPCError) Unwrap() error {
+	return e.cause
+}
+
+// toRPCError converts an error returned by a method handler to a JSON-RPC error object.
+// The code of a wrapped RPCError is kept with the message of the whole chain, other errors are internal errors.
+func toRPCError(err error) *RPCError {
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		if rpcErr == err {
+			return rpcErr
+		}
+		return &RPCError{Code: rpcErr.Code, Message: err.Error(), Data: rpcErr.Data}
+	}
+	return &RPCError{Code: InternalErrorCode, Message: err.Error()}
+}
@@ -0,0 +1,51 @@
+package rollapp
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestToRPCError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantCode    int
+		wantMessage string
+	}{
+		{
+			name:        "plain error",
+			err:         errors.New("boom"),
+			wantCode:    InternalErrorCode,
+			wantMessage: "boom",
+		},
+		{
+			name:        "rpc error",
+			err:         ErrUnknownKey,
+			wantCode:    UnknownKeyCode,
+			wantMessage: "key not found in keystore",
+		},
+		{
+			name:        "wrapped rpc error",
+			err:         errors.Wrap(fmt.Errorf("%w: next nonce 2, tx nonce 1", ErrNonceTooLow), "failed to submit"),
+			wantCode:    NonceMismatchCode,
+			wantMessage: "failed to submit: nonce too low: next nonce 2, tx nonce 1",
+		},
+		{
+			name:        "invalid params",
+			err:         errors.Wrap(decodeParam(nil, 0, new(string)), "invalid address"),
+			wantCode:    InvalidParamsCode,
+			wantMessage: "invalid address: missing value for required argument 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := toRPCError(tt.err)
+			if got.Code != tt.wantCode || got.Message != tt.wantMessage {
+				t.Errorf("toRPCError() = {%d %q}, want {%d %q}", got.Code, got.Message, tt.wantCode, tt.wantMessage)
+			}
+		})
+	}
+}
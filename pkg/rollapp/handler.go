@@ -1,156 +1,744 @@
 package rollapp
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
+	"time"
 
-	"github.com/0xElder/elder/utils"
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/0xElder/elder-wrap/pkg/keystore"
+	"github.com/0xElder/elder-wrap/pkg/metrics"
+	"github.com/0xElder/elder-wrap/pkg/tracing"
 	"github.com/0xElder/elder/x/router/types"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
 )
 
 type JsonRPCRequest struct {
 	JsonRPC string        `json:"jsonrpc"`
 	Method  string        `json:"method"`
 	Params  []interface{} `json:"params"`
-	ID      interface{}   `json:"id"`
+	// ID is kept as sent, numbers are not rounded through float64
+	ID json.RawMessage `json:"id"`
 }
 
 // JSON-RPC response structure
 type JsonRPCResponse struct {
 	JsonRPC string      `json:"jsonrpc"`
 	Result  interface{} `json:"result,omitempty"`
-	Error   interface{} `json:"error,omitempty"`
+	Error   *RPCError   `json:"error,omitempty"`
 	ID      interface{} `json:"id"`
 }
 
+// methodHandler serves a JSON-RPC method in elder-wrap instead of relaying it to the rollApp RPC
+type methodHandler func(ctx context.Context, params []interface{}) (interface{}, error)
+
+// startHandler serves a JSON-RPC method in two steps, it admits the call and returns the function
+// waiting for its result. A batch admits its transactions in request order and waits for them together.
+type startHandler func(ctx context.Context, params []interface{}) (func() (interface{}, error), error)
+
+// waitFor serves a two-step method in a single call
+func waitFor(start startHandler) methodHandler {
+	return func(ctx context.Context, params []interface{}) (interface{}, error) {
+		wait, err := start(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		return wait()
+	}
+}
+
+// nodeSigningMethods sign with the keystore keys, they are only served with node signing
+var nodeSigningMethods = []string{"eth_sign", "personal_sign", "eth_signTypedData_v4", "eth_signTransaction", "eth_sendTransaction"}
+
+// methodNotFound rejects a method elder-wrap does not serve with the current config
+func methodNotFound(name string) methodHandler {
+	return func(ctx context.Context, params []interface{}) (interface{}, error) {
+		return nil, NewRPCError(MethodNotFoundCode, fmt.Sprintf("the method %s does not exist/is not available", name), nil)
+	}
+}
+
+// registerMethods sets up the JSON-RPC methods served by elder-wrap
+func (r *RollApp) registerMethods() {
+	starts := map[string]startHandler{
+		"eth_sendRawTransaction": r.startSendRawTransaction,
+	}
+	methods := map[string]methodHandler{
+		"elder_getSubmission":      r.getSubmission,
+		"elder_getElderTxHash":     r.getElderTxHash,
+		"elder_getRollAppBlock":    r.getRollAppBlock,
+		"elder_pendingSubmissions": r.pendingSubmissions,
+	}
+
+	if r.cfg.NodeSigning {
+		methods["eth_accounts"] = r.accounts
+		methods["eth_requestAccounts"] = r.accounts
+		methods["eth_sign"] = r.sign
+		methods["personal_sign"] = r.personalSign
+		methods["eth_signTypedData_v4"] = r.signTypedData
+		methods["eth_signTransaction"] = r.signTransaction
+		starts["eth_sendTransaction"] = r.startSendTransaction
+	} else {
+		// The signing methods are not relayed either, the rollApp RPC must not sign transactions bypassing Elder
+		for _, name := range nodeSigningMethods {
+			methods[name] = methodNotFound(name)
+		}
+	}
+	for name, start := range starts {
+		methods[name] = waitFor(start)
+	}
+	r.methods = methods
+	r.starts = starts
+}
+
+// method returns the handler of a JSON-RPC method served by elder-wrap
+func (r *RollApp) method(name string) (methodHandler, bool) {
+	r.settingsMu.RLock()
+	defer r.settingsMu.RUnlock()
+
+	handler, ok := r.methods[name]
+	return handler, ok
+}
+
+// start returns the two-step handler of a JSON-RPC method served by elder-wrap, if it has one
+func (r *RollApp) start(name string) (startHandler, bool) {
+	r.settingsMu.RLock()
+	defer r.settingsMu.RUnlock()
+
+	start, ok := r.starts[name]
+	return start, ok
+}
+
 func (r *RollApp) HandleRequest(w http.ResponseWriter, req *http.Request) {
 	logger := r.logger.With("method", "HandleRequest")
 	w.Header().Set("Content-Type", "application/json")
 
+	ctx, span := tracing.Start(tracing.Extract(req.Context(), req.Header), "HandleRequest", tracing.RollAppKey.String(r.Name))
+	defer span.End()
+	// The state reads of the request are answered by the same rollApp RPC
+	ctx = withUpstreamPin(ctx)
+
 	body, err := io.ReadAll(req.Body)
 	if err != nil {
-		logger.Error(req.Context(), "Failed to read request body", "error", err)
-		http.Error(w, "Failed to read request", http.StatusBadRequest)
+		logger.Error(ctx, "Failed to read request body", "error", err)
+		r.writeResponse(w, errorResponse(nil, NewRPCError(ParseErrorCode, "failed to read request", nil)))
 		return
 	}
 	defer req.Body.Close()
 
 	// Handle batch requests
 	if isBatch(body) {
-		var rpcRequests []JsonRPCRequest
-		err = json.Unmarshal(body, &rpcRequests)
+		var rawRequests []json.RawMessage
+		err = json.Unmarshal(body, &rawRequests)
 		if err != nil {
-			logger.Error(req.Context(), "Failed to unmarshal batch request", "error", err)
-			http.Error(w, "Invalid JSON-RPC request", http.StatusBadRequest)
+			logger.Error(ctx, "Failed to unmarshal batch request", "error", err)
+			r.writeResponse(w, errorResponse(nil, withCode(ParseErrorCode, err, nil)))
+			return
+		}
+		if len(rawRequests) == 0 {
+			logger.Error(ctx, "Empty batch request")
+			r.writeResponse(w, errorResponse(nil, NewRPCError(InvalidRequestCode, "empty batch", nil)))
 			return
 		}
 
-		for _, rpcRequest := range rpcRequests {
-			if rpcRequest.Method == "eth_sendRawTransaction" {
-				logger.Error(req.Context(), "Batch request contains eth_sendRawTransaction, not supported")
-				http.Error(w, "Batch request contains eth_sendRawTransaction, not supported", http.StatusBadRequest)
-				return
+		span.SetAttributes(tracing.MethodKey.String("batch"), tracing.BatchSizeKey.Int(len(rawRequests)))
+		defer func(start time.Time) {
+			metrics.RequestDuration.WithLabelValues(r.Name, "batch").Observe(time.Since(start).Seconds())
+		}(time.Now())
+
+		rpcRequests := make([]*JsonRPCRequest, len(rawRequests))
+		local := false
+		for i, rawRequest := range rawRequests {
+			var rpcRequest JsonRPCRequest
+			if err := json.Unmarshal(rawRequest, &rpcRequest); err != nil || rpcRequest.Method == "" {
+				logger.Error(ctx, "Invalid batch element", "index", i, "error", err)
+				local = true
+				continue
+			}
+			metrics.Requests.WithLabelValues(r.Name, metrics.MethodLabel(rpcRequest.Method)).Inc()
+			rpcRequests[i] = &rpcRequest
+			if _, ok := r.method(rpcRequest.Method); ok {
+				local = true
 			}
 		}
 
-		// Relay batch requests to rollApp RPC if there are no eth_sendRawTransaction
-		r.ForwardtoRollAppRPC(w, body)
+		if !local {
+			// Relay batch requests to rollApp RPC as is if there are no methods served by elder-wrap
+			r.ForwardtoRollAppRPC(ctx, w, body)
+			return
+		}
+
+		responses := r.handleBatch(ctx, rawRequests, rpcRequests)
+		if len(responses) == 0 {
+			// A batch of notifications is answered with nothing at all
+			return
+		}
+		r.writeResponse(w, responses)
 		return
 	}
 
 	var rpcRequest JsonRPCRequest
 	err = json.Unmarshal(body, &rpcRequest)
 	if err != nil {
-		logger.Error(req.Context(), "Failed to unmarshal request", "error", err)
-		http.Error(w, "Invalid JSON-RPC request", http.StatusBadRequest)
+		logger.Error(ctx, "Failed to unmarshal request", "error", err)
+		r.writeResponse(w, errorResponse(nil, withCode(ParseErrorCode, err, nil)))
+		return
+	}
+	if rpcRequest.Method == "" {
+		logger.Error(ctx, "Request without method")
+		r.writeResponse(w, errorResponse(rpcRequest.ID, NewRPCError(InvalidRequestCode, "missing method", nil)))
 		return
 	}
 
-	logger.Debug(req.Context(), "Received JSON-RPC request", "method", rpcRequest.Method, "params", rpcRequest.Params)
+	logger.Debug(ctx, "Received JSON-RPC request", "method", rpcRequest.Method, "params", rpcRequest.Params)
+	span.SetAttributes(tracing.MethodKey.String(rpcRequest.Method))
+	defer r.observeRequest(rpcRequest.Method, time.Now())
 
-	// Check if the method is `eth_sendRawTransaction` (signed transaction)
-	if rpcRequest.Method == "eth_sendRawTransaction" {
-		logger.Debug(req.Context(), "Received eth_sendRawTransaction request")
-		response := JsonRPCResponse{
-			JsonRPC: rpcRequest.JsonRPC,
-			ID:      rpcRequest.ID,
+	handler, ok := r.method(rpcRequest.Method)
+	if !ok {
+		// Relay all other calls to rollApp RPC
+		r.ForwardtoRollAppRPC(ctx, w, body)
+		return
+	}
+
+	response := r.handleCall(ctx, handler, rpcRequest)
+	if isNotification(body) {
+		// A notification is served but not answered
+		return
+	}
+	r.writeResponse(w, response)
+}
+
+// observeRequest records a JSON-RPC call answered since start
+func (r *RollApp) observeRequest(method string, start time.Time) {
+	label := metrics.MethodLabel(method)
+	metrics.Requests.WithLabelValues(r.Name, label).Inc()
+	metrics.RequestDuration.WithLabelValues(r.Name, label).Observe(time.Since(start).Seconds())
+}
+
+// writeResponse sends a JSON-RPC response or batch response back
+func (r *RollApp) writeResponse(w http.ResponseWriter, response interface{}) {
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		r.logger.Error(nil, "Failed to encode response", "error", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// errorResponse is the response to a request which failed with err
+func errorResponse(id interface{}, err *RPCError) JsonRPCResponse {
+	return JsonRPCResponse{
+		JsonRPC: "2.0",
+		Error:   err,
+		ID:      id,
+	}
+}
+
+// handleCall serves a JSON-RPC call with one of the methods served by elder-wrap
+func (r *RollApp) handleCall(ctx context.Context, handler methodHandler, rpcRequest JsonRPCRequest) JsonRPCResponse {
+	result, err := handler(ctx, rpcRequest.Params)
+	return callResponse(ctx, rpcRequest, result, err)
+}
+
+// callResponse is the response to a JSON-RPC call which returned result or err
+func callResponse(ctx context.Context, rpcRequest JsonRPCRequest, result interface{}, err error) JsonRPCResponse {
+	response := JsonRPCResponse{
+		JsonRPC: "2.0",
+		ID:      rpcRequest.ID,
+	}
+	if err != nil {
+		tracing.RecordError(ctx, err)
+		response.Error = toRPCError(err)
+	} else if result == nil {
+		// A null result still has to be sent, omitempty would drop it
+		response.Result = json.RawMessage("null")
+	} else {
+		response.Result = result
+	}
+	return response
+}
+
+// handleBatch serves a batch request element by element. Methods served by elder-wrap are handled in
+// request order, the other calls are relayed to the rollApp RPC as a sub-batch. Transactions are admitted
+// in request order, so the nonces of a sender follow each other, and their outcomes are waited for together.
+// The responses are returned in request order without the notifications, invalid elements are answered
+// with an invalid request error.
+func (r *RollApp) handleBatch(ctx context.Context, rawRequests []json.RawMessage, rpcRequests []*JsonRPCRequest) []interface{} {
+	logger := r.logger.With("method", "handleBatch")
+
+	responses := make([]interface{}, len(rpcRequests))
+	var forwarded []int
+	for i, rpcRequest := range rpcRequests {
+		if rpcRequest == nil {
+			responses[i] = errorResponse(nil, NewRPCError(InvalidRequestCode, "invalid request", nil))
+			continue
+		}
+		if _, ok := r.method(rpcRequest.Method); !ok {
+			forwarded = append(forwarded, i)
 		}
+	}
 
-		// Send the response back
-		defer func() {
-			err := json.NewEncoder(w).Encode(response)
-			if err != nil {
-				logger.Error(req.Context(), "Failed to encode response", "error", err)
-				http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-			}
-		}()
+	if len(forwarded) > 0 {
+		subBatch := make([]json.RawMessage, len(forwarded))
+		for j, i := range forwarded {
+			subBatch[j] = rawRequests[i]
+		}
+		for i, response := range r.relayBatch(ctx, subBatch, forwarded, rpcRequests) {
+			responses[i] = response
+		}
+	}
 
-		internalTx, ok := rpcRequest.Params[0].(string)
+	var wg sync.WaitGroup
+	for i, rpcRequest := range rpcRequests {
+		if rpcRequest == nil {
+			continue
+		}
+		handler, ok := r.method(rpcRequest.Method)
 		if !ok {
-			logger.Error(req.Context(), "Invalid transaction format", "params", rpcRequest.Params)
-			response.Error = fmt.Errorf("invalid transaction format")
-			return
+			continue
 		}
+		logger.Debug(ctx, "Serving batch element", "index", i, "method", rpcRequest.Method)
+		start, ok := r.start(rpcRequest.Method)
+		if !ok {
+			responses[i] = r.handleCall(ctx, handler, *rpcRequest)
+			continue
+		}
+		wait, err := start(ctx, rpcRequest.Params)
+		if err != nil {
+			responses[i] = callResponse(ctx, *rpcRequest, nil, err)
+			continue
+		}
+		wg.Add(1)
+		go func(i int, rpcRequest JsonRPCRequest) {
+			defer wg.Done()
+			result, err := wait()
+			responses[i] = callResponse(ctx, rpcRequest, result, err)
+		}(i, *rpcRequest)
+	}
+	wg.Wait()
+
+	// Notifications are served but not answered
+	answered := responses[:0]
+	for i, response := range responses {
+		if rpcRequests[i] == nil || !isNotification(rawRequests[i]) {
+			answered = append(answered, response)
+		}
+	}
+	return answered
+}
+
+// isNotification returns true when the JSON-RPC request has no id member, its response is not sent
+func isNotification(rawRequest json.RawMessage) bool {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(rawRequest, &members); err != nil {
+		return false
+	}
+	_, ok := members["id"]
+	return !ok
+}
+
+// relayBatch relays a sub-batch to the rollApp RPC and matches the responses to the request indexes by ID
+func (r *RollApp) relayBatch(ctx context.Context, subBatch []json.RawMessage, indexes []int, rpcRequests []*JsonRPCRequest) map[int]interface{} {
+	logger := r.logger.With("method", "relayBatch")
+	responses := make(map[int]interface{}, len(indexes))
 
-		if internalTx[0:2] != "0x" {
-			internalTx = "0x" + internalTx
+	errorResponses := func(err error) map[int]interface{} {
+		for _, i := range indexes {
+			responses[i] = errorResponse(rpcRequests[i].ID, toRPCError(err))
 		}
+		return responses
+	}
+
+	body, err := json.Marshal(subBatch)
+	if err != nil {
+		logger.Error(ctx, "Failed to encode sub-batch", "error", err)
+		return errorResponses(err)
+	}
+
+	spanCtx, span := tracing.Start(ctx, "ForwardtoRollAppRPC", tracing.RollAppKey.String(r.Name), tracing.BatchSizeKey.Int(len(subBatch)))
+	responseBody, err := r.relay(spanCtx, body)
+	tracing.End(span, err)
+	if err != nil {
+		return errorResponses(err)
+	}
+
+	var rawResponses []json.RawMessage
+	if err := json.Unmarshal(responseBody, &rawResponses); err != nil {
+		logger.Error(ctx, "Invalid batch response from rollApp RPC", "response", string(responseBody), "error", err)
+		return errorResponses(errors.New("invalid batch response from rollApp RPC"))
+	}
 
-		tx, key, err := r.VerifyRollAppTx(context.Background(), internalTx[2:])
+	// Responses of a batch can come in any order, they are matched to requests by ID
+	byID := make(map[string][]json.RawMessage)
+	for _, rawResponse := range rawResponses {
+		var response struct {
+			ID json.RawMessage `json:"id"`
+		}
+		if err := json.Unmarshal(rawResponse, &response); err != nil {
+			continue
+		}
+		id := canonicalID(response.ID)
+		byID[id] = append(byID[id], rawResponse)
+	}
+
+	for _, i := range indexes {
+		id := canonicalID(rpcRequests[i].ID)
+		if matched := byID[id]; len(matched) > 0 {
+			responses[i] = matched[0]
+			byID[id] = matched[1:]
+			continue
+		}
+		responses[i] = errorResponse(rpcRequests[i].ID, NewRPCError(InternalErrorCode, "missing response from rollApp RPC", nil))
+	}
+	return responses
+}
+
+// startSendRawTransaction serves eth_sendRawTransaction by submitting the signed transaction through Elder
+func (r *RollApp) startSendRawTransaction(ctx context.Context, params []interface{}) (func() (interface{}, error), error) {
+	logger := r.logger.With("method", "startSendRawTransaction")
+	logger.Debug(ctx, "Received eth_sendRawTransaction request")
+
+	var internalTx string
+	if err := decodeParam(params, 0, &internalTx); err != nil {
+		logger.Error(ctx, "Invalid transaction format", "params", params)
+		metrics.SubmissionFailures.WithLabelValues(r.Name, metrics.ReasonDecode).Inc()
+		return nil, withCode(InvalidParamsCode, errors.Wrap(err, "invalid transaction format"), nil)
+	}
+
+	wait, err := r.startRawTransaction(ctx, internalTx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return txHashResult(wait), nil
+}
+
+// txHashResult waits for a submission and returns its transaction hash as a JSON-RPC result
+func txHashResult(wait func() (common.Hash, error)) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		txHash, err := wait()
 		if err != nil {
-			logger.Error(req.Context(), "Failed to verify transaction", "error", err)
-			response.Error = err.Error()
-			return
+			return nil, err
 		}
+		return txHash.String(), nil
+	}
+}
+
+// submitRawTransaction verifies a signed rollApp transaction and submits it to Elder with the sender's key.
+// Transactions with a nonce ahead of the sender's next nonce are held in the tx pool until the gap is filled.
+// In sync submission mode it returns once the transaction is included in a rollApp block, in async mode
+// as soon as the Elder transaction passed CheckTx while inclusion is confirmed in the background.
+// unlock is set when the caller locked the sender, it is called once the transaction is admitted or rejected.
+func (r *RollApp) submitRawTransaction(ctx context.Context, internalTx string, unlock func()) (common.Hash, error) {
+	wait, err := r.startRawTransaction(ctx, internalTx, unlock)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return wait()
+}
 
-		internalTxBytes, err := hexutil.Decode(internalTx)
+// startRawTransaction admits a signed rollApp transaction like submitRawTransaction. It returns once the
+// transaction is broadcast to Elder or queued, with the function waiting for the outcome of the submission.
+func (r *RollApp) startRawTransaction(ctx context.Context, internalTx string, unlock func()) (_ func() (common.Hash, error), err error) {
+	logger := r.logger.With("method", "startRawTransaction")
+	defer func() {
+		if unlock != nil {
+			unlock()
+		}
+	}()
+
+	if !r.track() {
+		logger.Warn(ctx, "Rejecting transaction, shutting down")
+		return nil, ErrShuttingDown
+	}
+	// The submission is in flight until its outcome was waited for
+	defer func() {
 		if err != nil {
-			logger.Error(req.Context(), "Failed to decode transaction", "error", err)
-			response.Error = err.Error()
-			return
+			r.inflight.Done()
+		}
+	}()
+
+	if r.Paused() {
+		logger.Warn(ctx, "Rejecting transaction, submissions are paused")
+		return nil, ErrSubmissionPaused
+	}
+
+	if len(internalTx) < 2 || internalTx[0:2] != "0x" {
+		internalTx = "0x" + internalTx
+	}
+
+	tx, key, sponsored, err := r.VerifyRollAppTx(ctx, internalTx[2:])
+	if err != nil {
+		logger.Error(ctx, "Failed to verify transaction", "error", err)
+		reason := metrics.ReasonVerify
+		if toRPCError(err).Code == InvalidParamsCode {
+			reason = metrics.ReasonDecode
 		}
+		metrics.SubmissionFailures.WithLabelValues(r.Name, reason).Inc()
+		return nil, err
+	}
+	tracing.SetAttributes(ctx, tracing.SenderKey.String(key.EvmAddress.Hex()), tracing.TxHashKey.String(tx.Hash().Hex()))
+
+	adm := &admission{sponsored: sponsored}
+	if err := r.checkAdmission(ctx, tx, key, adm); err != nil {
+		return nil, err
+	}
+
+	// admitTransaction unlocks the sender
+	held := unlock
+	unlock = nil
+	waitBroadcast, queued, err := r.admitTransaction(ctx, tx, key, adm, held)
+	if err != nil {
+		return nil, err
+	}
 
-		accNum, _, err := utils.QueryElderAccount(utils.AuthClient(r.elderClient.Conn), key.ElderAddress)
+	return func() (common.Hash, error) {
+		defer r.inflight.Done()
+		if queued {
+			return tx.Hash(), nil
+		}
+		elderTxHash, err := waitBroadcast()
 		if err != nil {
-			logger.Error(req.Context(), "Failed to query elder account", "error", err)
-			response.Error = err.Error()
-			return
+			return common.Hash{}, err
 		}
+		tracing.SetAttributes(ctx, tracing.ElderTxHashKey.String(elderTxHash))
+
+		// The background work outlives the request, it keeps its trace but not its cancellation
+		if r.settings().SubmissionMode == config.SubmissionModeAsync {
+			logger.Debug(ctx, "Transaction broadcast, confirming inclusion in the background", "txHash", tx.Hash().Hex(), "elderTxHash", elderTxHash)
+			r.background(func() { r.confirmInclusion(context.WithoutCancel(ctx), key.EvmAddress, tx.Hash(), elderTxHash) })
+			return tx.Hash(), nil
+		}
+
+		if err := r.confirmInclusion(ctx, key.EvmAddress, tx.Hash(), elderTxHash); err != nil {
+			return common.Hash{}, err
+		}
+		return tx.Hash(), nil
+	}, nil
+}
+
+// admitTransaction checks the transaction nonce against the sender's next nonce, it broadcasts the
+// transaction to Elder when it is the next one and queues it in the tx pool when it is ahead. The sender
+// is locked until the transaction has its place among the Elder transactions of its key, not during the
+// broadcast, so the next transactions of the sender can join the same Elder batch. unlock is set when
+// the caller already locked the sender. The reservations of adm are released when the transaction is
+// rejected, a queued transaction keeps them until it is promoted, except a sponsored one which reserves
+// again on promotion. The returned function waits for the Elder transaction to pass CheckTx, it is nil
+// when the transaction is queued.
+func (r *RollApp) admitTransaction(ctx context.Context, tx *ethtypes.Transaction, key *keystore.Key, adm *admission, unlock func()) (func() (string, error), bool, error) {
+	logger := r.logger.With("method", "admitTransaction")
+	sender := key.EvmAddress
 
-		msg := &types.MsgSubmitRollTx{
-			RollId: r.ElderRegistationId,
-			TxData: internalTxBytes,
-			Sender: key.ElderAddress,
-			AccNum: accNum,
+	if unlock == nil {
+		unlock = r.txPool.lockSender(sender)
+	}
+	rpcNonce, err := r.GetAddressNonce(ctx, sender.Hex())
+	if err != nil {
+		unlock()
+		adm.release()
+		logger.Error(ctx, "Failed to get address nonce", "error", err)
+		metrics.SubmissionFailures.WithLabelValues(r.Name, metrics.ReasonVerify).Inc()
+		return nil, false, errors.Wrap(err, "failed to get address nonce")
+	}
+
+	nonce := r.txPool.nextNonce(sender, rpcNonce)
+	switch {
+	case tx.Nonce() < rpcNonce:
+		unlock()
+		adm.release()
+		logger.Error(ctx, "Nonce too low", "expected", nonce, "got", tx.Nonce())
+		metrics.SubmissionFailures.WithLabelValues(r.Name, metrics.ReasonNonce).Inc()
+		return nil, false, fmt.Errorf("%w: next nonce %d, tx nonce %d", ErrNonceTooLow, nonce, tx.Nonce())
+	case tx.Nonce() < nonce:
+		// The nonce is already submitted to Elder, an Elder transaction can't be replaced
+		unlock()
+		adm.release()
+		metrics.SubmissionFailures.WithLabelValues(r.Name, metrics.ReasonPool).Inc()
+		if submission, ok := r.submissions.get(tx.Hash()); ok && submission.Status != SubmissionFailed {
+			logger.Warn(ctx, "Transaction already submitted", "txHash", tx.Hash().Hex())
+			return nil, false, ErrAlreadyKnown
+		}
+		logger.Error(ctx, "Nonce already submitted to Elder", "expected", nonce, "got", tx.Nonce())
+		return nil, false, fmt.Errorf("%w: next nonce %d, tx nonce %d", ErrReplacementSubmitted, nonce, tx.Nonce())
+	case tx.Nonce() > nonce:
+		if adm.sponsored {
+			// A queued sponsored transaction holds no sponsor budget, or throwaway senders could spend it
+			// without a single broadcast. Its checks run again when it is promoted.
+			adm.release()
+			adm = &admission{sponsored: true, unchecked: true}
+		}
+		err := r.queueTransaction(ctx, tx, key, adm)
+		unlock()
+		if err != nil {
+			adm.release()
+			return nil, false, err
 		}
+		return nil, true, nil
+	}
+
+	r.txPool.reserve(sender, nonce)
+	wait, err := r.broadcastTransaction(ctx, tx, key, adm)
+	if err != nil {
+		r.txPool.release(sender, nonce)
+		unlock()
+		return nil, false, err
+	}
+	unlock()
+
+	// The queued transactions following this one are promoted while it waits, so they can join its batch
+	if r.txPool.hasQueued(sender) {
+		r.background(func() { r.promote(context.WithoutCancel(ctx), sender) })
+	}
 
-		elderTxHash, err := r.elderClient.BroadCastTxn(key, msg)
+	return func() (string, error) {
+		elderTxHash, err := wait()
 		if err != nil {
-			logger.Error(req.Context(), "Failed to broadcast transaction", "error", err)
-			response.Error = err.Error()
-			return
+			r.txPool.release(sender, nonce)
+			return "", err
 		}
+		return elderTxHash, nil
+	}, false, nil
+}
 
-		_, rollAppBlock, err := utils.GetElderTxFromHash(utils.TxClient(r.elderClient.Conn), elderTxHash)
-		if err != nil || rollAppBlock == "" {
-			logger.Error(req.Context(), "Failed to fetch elder transaction", "error", err)
-			response.Error = fmt.Errorf("failed to fetch elder tx, rollAppBlock: %v, err: %v", rollAppBlock, err)
-			return
+// broadcastTransaction wraps the rollApp transaction in a MsgSubmitRollTx and enqueues it to the Elder
+// transactions of its key, after replaying it on the rollApp when simulation is enabled. The submission is
+// journaled before the broadcast, so it is reconciled after a crash. The returned function waits for the
+// Elder transaction to pass CheckTx and records its outcome, the reservations of adm are settled with the
+// Elder fee paid, or released when the transaction is not broadcast.
+func (r *RollApp) broadcastTransaction(ctx context.Context, tx *ethtypes.Transaction, key *keystore.Key, adm *admission) (func() (string, error), error) {
+	logger := r.logger.With("method", "broadcastTransaction")
+
+	internalTxBytes, err := tx.MarshalBinary()
+	if err != nil {
+		logger.Error(ctx, "Failed to encode transaction", "error", err)
+		adm.release()
+		return nil, err
+	}
+
+	if r.settings().Simulation.Enabled {
+		if err := r.simulate(ctx, tx, key.EvmAddress); err != nil {
+			adm.release()
+			return nil, err
 		}
+	}
 
-		response.Result = tx.Hash().String()
-	} else {
-		// Relay all other calls to rollApp RPC
-		r.ForwardtoRollAppRPC(w, body)
+	accNum, err := r.elderClient.AccountNumber(ctx, key.ElderAddress)
+	if err != nil {
+		logger.Error(ctx, "Failed to get elder account number", "error", err)
+		metrics.SubmissionFailures.WithLabelValues(r.Name, metrics.ReasonAccountQuery).Inc()
+		adm.release()
+		return nil, withCode(BroadcastFailedCode, err, nil)
 	}
+
+	msg := &types.MsgSubmitRollTx{
+		RollId: r.settings().ElderRegistrationId,
+		TxData: internalTxBytes,
+		Sender: key.ElderAddress,
+		AccNum: accNum,
+	}
+
+	now := time.Now()
+	r.submissions.add(&Submission{
+		TxHash:      tx.Hash(),
+		Sender:      key.EvmAddress,
+		Nonce:       hexutil.Uint64(tx.Nonce()),
+		Status:      SubmissionSubmitting,
+		RawTx:       internalTxBytes,
+		SubmittedAt: now,
+		UpdatedAt:   now,
+	})
+
+	wait := r.elderClient.Enqueue(ctx, key, msg)
+	return func() (string, error) {
+		broadcast, err := wait()
+		metrics.BroadcastDuration.WithLabelValues(r.Name).Observe(time.Since(now).Seconds())
+		if err != nil {
+			logger.Error(ctx, "Failed to broadcast transaction", "error", err)
+			metrics.SubmissionFailures.WithLabelValues(r.Name, metrics.ReasonBroadcast).Inc()
+			err = withCode(BroadcastFailedCode, err, nil)
+			r.submissions.setFailed(tx.Hash(), err)
+			adm.release()
+			return "", err
+		}
+		metrics.Submissions.WithLabelValues(r.Name, metrics.StatusBroadcast).Inc()
+
+		r.spendSponsor(adm)
+		adm.settle(broadcast.Fee)
+		r.submissions.setPending(tx.Hash(), broadcast.ElderTxHash)
+		r.pendingTxs.send(tx)
+		return broadcast.ElderTxHash, nil
+	}, nil
+}
+
+// confirmInclusion waits for the Elder transaction to be included in a rollApp block and records the outcome.
+// On failure the nonces reserved for sender are forgotten, so the rollApp pending nonce is expected again.
+func (r *RollApp) confirmInclusion(ctx context.Context, sender common.Address, txHash common.Hash, elderTxHash string) error {
+	logger := r.logger.With("method", "confirmInclusion")
+	start := time.Now()
+
+	rollAppBlock, err := r.elderClient.RollAppBlock(ctx, elderTxHash)
+	if err != nil || rollAppBlock == "" {
+		logger.Error(ctx, "Failed to fetch elder transaction", "txHash", txHash.Hex(), "elderTxHash", elderTxHash, "error", err)
+		err = fmt.Errorf("failed to fetch elder tx, rollAppBlock: %v, err: %v", rollAppBlock, err)
+		r.submissions.setFailed(txHash, err)
+		r.txPool.reset(sender)
+		metrics.SubmissionFailures.WithLabelValues(r.Name, metrics.ReasonInclusion).Inc()
+		return withCode(InclusionTimeoutCode, err, map[string]string{
+			"txHash":      txHash.Hex(),
+			"elderTxHash": elderTxHash,
+		})
+	}
+
+	logger.Debug(ctx, "Transaction included", "txHash", txHash.Hex(), "elderTxHash", elderTxHash, "rollAppBlock", rollAppBlock)
+	r.submissions.setIncluded(txHash, rollAppBlock)
+	metrics.Submissions.WithLabelValues(r.Name, metrics.StatusIncluded).Inc()
+	metrics.InclusionDuration.WithLabelValues(r.Name).Observe(time.Since(start).Seconds())
+	return nil
+}
+
+// decodeParam decodes the JSON-RPC positional parameter at index into v
+func decodeParam(params []interface{}, index int, v interface{}) error {
+	if index >= len(params) {
+		return NewRPCError(InvalidParamsCode, fmt.Sprintf("missing value for required argument %d", index), nil)
+	}
+	raw, err := json.Marshal(params[index])
+	if err != nil {
+		return withCode(InvalidParamsCode, err, nil)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return withCode(InvalidParamsCode, fmt.Errorf("invalid argument %d: %w", index, err), nil)
+	}
+	return nil
 }
 
 // isBatch returns true when the first non-whitespace characters is '['
 // Code taken from go-ethereum/rpc/json.go
+// canonicalID returns an id without insignificant whitespace, the ids of responses are matched on it
+func canonicalID(id json.RawMessage) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, id); err != nil {
+		return string(id)
+	}
+	return buf.String()
+}
+
+// requestID returns the id of a JSON-RPC request as sent by the client, nil when it has none
+func requestID(rawRequest json.RawMessage) json.RawMessage {
+	var request struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(rawRequest, &request); err != nil {
+		return nil
+	}
+	return request.ID
+}
+
 func isBatch(raw json.RawMessage) bool {
 	for _, c := range raw {
 		// skip insignificant whitespace (http://www.ietf.org/rfc/rfc4627.txt)
@@ -0,0 +1,452 @@
+package rollapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/0xElder/elder-wrap/pkg/elder"
+	"github.com/0xElder/elder-wrap/pkg/keystore"
+	"github.com/0xElder/elder-wrap/pkg/logging"
+	"github.com/0xElder/elder-wrap/pkg/policy"
+	routertypes "github.com/0xElder/elder/x/router/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// testSenderPrivateKey is keccak256("cow"), the key signing the EIP-712 example
+const testSenderPrivateKey = "c85ef7d79691fe79573b1a7064c19c1a9819ebdbd1faaab1a8ec92344438aaf4"
+
+// fakeElder groups the enqueued messages into batches of batchSize, the wait of a message ends once its
+// batch is full and returns the hash elder-<batch index>
+type fakeElder struct {
+	batchSize int
+	// rollAppBlock is the block the Elder transactions are included in, none when empty
+	rollAppBlock string
+	// err fails the broadcast of the Elder transactions when set
+	err error
+	// fee is the Elder fee paid for each message
+	fee uint64
+	// release blocks the rollApp block lookups until it is closed, when set
+	release chan struct{}
+
+	mu      sync.Mutex
+	msgs    []*routertypes.MsgSubmitRollTx
+	batches []chan struct{}
+	lookups int
+}
+
+func (f *fakeElder) AccountNumber(ctx context.Context, elderAddress string) (uint64, error) {
+	return 1, nil
+}
+
+func (f *fakeElder) Enqueue(ctx context.Context, key *keystore.Key, msg *routertypes.MsgSubmitRollTx) func() (elder.Broadcast, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	index := len(f.msgs) / f.batchSize
+	if index == len(f.batches) {
+		f.batches = append(f.batches, make(chan struct{}))
+	}
+	f.msgs = append(f.msgs, msg)
+	full := f.batches[index]
+	if len(f.msgs)%f.batchSize == 0 {
+		close(full)
+	}
+	return func() (elder.Broadcast, error) {
+		if f.err != nil {
+			return elder.Broadcast{}, f.err
+		}
+		select {
+		case <-full:
+			return elder.Broadcast{ElderTxHash: fmt.Sprintf("elder-%d", index), Fee: f.fee}, nil
+		case <-time.After(5 * time.Second):
+			return elder.Broadcast{}, fmt.Errorf("batch %d is not full", index)
+		}
+	}
+}
+
+func (f *fakeElder) EstimateFee() uint64 {
+	return f.fee
+}
+
+func (f *fakeElder) RollAppBlock(ctx context.Context, elderTxHash string) (string, error) {
+	f.mu.Lock()
+	f.lookups++
+	f.mu.Unlock()
+
+	if f.release != nil {
+		<-f.release
+	}
+	if f.rollAppBlock == "" || !strings.HasPrefix(elderTxHash, "elder-") {
+		return "", fmt.Errorf("elder transaction %s not found", elderTxHash)
+	}
+	return f.rollAppBlock, nil
+}
+
+// lookedUp returns the number of rollApp block lookups
+func (f *fakeElder) lookedUp() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.lookups
+}
+
+// enqueued returns the number of messages enqueued
+func (f *fakeElder) enqueued() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.msgs)
+}
+
+// newSubmitUpstream answers eth_chainId with chain id 1 and eth_getTransactionCount with nonce
+func newSubmitUpstream(nonce uint64) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var request JsonRPCRequest
+		json.NewDecoder(req.Body).Decode(&request)
+		id, _ := json.Marshal(request.ID)
+
+		switch request.Method {
+		case "eth_chainId":
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":"0x1"}`, id)
+		case "eth_getTransactionCount":
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":"0x%x"}`, id, nonce)
+		case "eth_getTransactionReceipt":
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":null}`, id)
+		default:
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":"0x1"}`, id)
+		}
+	}))
+}
+
+// newSubmitTestRollApp returns a rollApp submitting to elder the transactions of the key of
+// testSenderPrivateKey, it returns the key to sign them with
+func newSubmitTestRollApp(t *testing.T, upstreamURL string, elder *fakeElder) (*RollApp, *keystore.Key) {
+	t.Helper()
+	store, err := keystore.NewPlainKeyStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := keystore.NewKeyStoreClient(store, logging.NewDevSlogger(nil))
+	if err := client.ImportPrivateKey("alice", testSenderPrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	key, err := client.GetKeyByAlias("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.RollAppConfig{
+		RPC:                 upstreamURL,
+		UpstreamSelection:   config.UpstreamSelectionRoundRobin,
+		HealthCheckInterval: time.Hour,
+		MaxBlockLag:         config.DefaultMaxBlockLag,
+		ElderRegistrationId: 1,
+		SubmissionMode:      config.SubmissionModeAsync,
+	}
+	r, err := NewRollApp("rollup1", cfg, config.TxPoolConfig{PriceBump: 10, MaxQueuedPerSender: 64, MaxQueued: 1024}, store, logging.NewDevSlogger(nil), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.elderClient = elder
+	t.Cleanup(r.Close)
+	return r, key
+}
+
+// signTestTx returns the raw transfer with nonce and fee signed with key
+func signTestTx(t *testing.T, key *keystore.Key, nonce uint64, fee int64) string {
+	t.Helper()
+	privateKey, err := key.ECDSA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := common.HexToAddress("0x00000000000000000000000000000000000000c1")
+	tx, err := types.SignNewTx(privateKey, types.LatestSignerForChainID(big.NewInt(1)), &types.DynamicFeeTx{
+		ChainID:   big.NewInt(1),
+		Nonce:     nonce,
+		GasTipCap: big.NewInt(fee),
+		GasFeeCap: big.NewInt(fee),
+		Gas:       21000,
+		To:        &to,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hexutil.Encode(raw)
+}
+
+func TestRollApp_submitRawTransaction_batch(t *testing.T) {
+	upstream := newSubmitUpstream(0)
+	defer upstream.Close()
+	elder := &fakeElder{batchSize: 3, rollAppBlock: "0x10"}
+	r, key := newSubmitTestRollApp(t, upstream.URL, elder)
+
+	// Each transaction is sent while the previous ones wait for their batch to be broadcast
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for nonce := range errs {
+		wg.Add(1)
+		go func(nonce int) {
+			defer wg.Done()
+			_, errs[nonce] = r.submitRawTransaction(context.Background(), signTestTx(t, key, uint64(nonce), 1), nil)
+		}(nonce)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for elder.enqueued() <= nonce && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	wg.Wait()
+
+	for nonce, err := range errs {
+		if err != nil {
+			t.Errorf("submitRawTransaction() nonce %d error = %v", nonce, err)
+		}
+	}
+	if len(elder.batches) != 1 {
+		t.Errorf("sent %d batches, want 1", len(elder.batches))
+	}
+	for i, msg := range elder.msgs {
+		var tx types.Transaction
+		if err := tx.UnmarshalBinary(msg.TxData); err != nil {
+			t.Fatal(err)
+		}
+		if tx.Nonce() != uint64(i) {
+			t.Errorf("message %d has nonce %d, want %d", i, tx.Nonce(), i)
+		}
+		if submission, ok := r.submissions.get(tx.Hash()); !ok || submission.ElderTxHash != "elder-0" {
+			t.Errorf("submission of nonce %d = %+v, want elder transaction elder-0", tx.Nonce(), submission)
+		}
+	}
+
+	if err := r.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newBatchTestRollApp returns a rollApp serving eth_sendRawTransaction with 0xhash, its upstream answers
+// batches in reverse order with the method of each request as result
+func newBatchTestRollApp(t *testing.T) *RollApp {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var requests []JsonRPCRequest
+		body, _ := io.ReadAll(req.Body)
+		if err := json.Unmarshal(body, &requests); err != nil {
+			t.Errorf("upstream received invalid batch: %s", body)
+		}
+		responses := make([]JsonRPCResponse, 0, len(requests))
+		for i := len(requests) - 1; i >= 0; i-- {
+			responses = append(responses, JsonRPCResponse{JsonRPC: "2.0", ID: requests[i].ID, Result: requests[i].Method})
+		}
+		json.NewEncoder(w).Encode(responses)
+	}))
+	t.Cleanup(upstream.Close)
+
+	upstreams, err := newUpstreamPool("rollup1", []string{upstream.URL}, config.UpstreamSelectionRoundRobin, config.DefaultMaxBlockLag, logging.NewDevSlogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(upstreams.close)
+
+	return &RollApp{
+		upstreams: upstreams,
+		logger:    logging.NewDevSlogger(nil),
+		methods: map[string]methodHandler{
+			"eth_sendRawTransaction": func(ctx context.Context, params []interface{}) (interface{}, error) {
+				return "0xhash", nil
+			},
+		},
+	}
+}
+
+func TestRollApp_HandleRequest_batch(t *testing.T) {
+	r := newBatchTestRollApp(t)
+
+	body := `[
+		{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]},
+		{"jsonrpc":"2.0","id":2,"method":"eth_sendRawTransaction","params":["0x01"]},
+		{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["0x02"]},
+		{"jsonrpc":"2.0","id":3,"method":"eth_blockNumber","params":[]},
+		{"jsonrpc":"2.0","method":"eth_chainId","params":[]}
+	]`
+	w := httptest.NewRecorder()
+	r.HandleRequest(w, httptest.NewRequest(http.MethodPost, "/rollup1", strings.NewReader(body)))
+
+	var responses []JsonRPCResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &responses); err != nil {
+		t.Fatalf("invalid batch response: %s", w.Body.String())
+	}
+
+	want := []string{"eth_chainId", "0xhash", "eth_blockNumber"}
+	if len(responses) != len(want) {
+		t.Fatalf("got %d responses, want %d", len(responses), len(want))
+	}
+	for i, response := range responses {
+		if response.ID != float64(i+1) || response.Result != want[i] {
+			t.Errorf("response %d = id %v result %v, want id %d result %s", i, response.ID, response.Result, i+1, want[i])
+		}
+	}
+}
+
+func TestRollApp_HandleRequest_ids(t *testing.T) {
+	r := newBatchTestRollApp(t)
+
+	tests := []struct {
+		name string
+		body string
+		// want is the response body, without the trailing newline
+		want string
+	}{
+		{
+			name: "notification",
+			body: `{"jsonrpc":"2.0","method":"eth_sendRawTransaction","params":["0x01"]}`,
+			want: ``,
+		},
+		{
+			name: "ids above float64 precision",
+			body: `[{"jsonrpc":"2.0","id":9007199254740993,"method":"eth_chainId"},{"jsonrpc":"2.0","id":9007199254740995,"method":"eth_sendRawTransaction","params":["0x01"]}]`,
+			want: `[{"jsonrpc":"2.0","result":"eth_chainId","id":9007199254740993},{"jsonrpc":"2.0","result":"0xhash","id":9007199254740995}]`,
+		},
+		{
+			name: "ids matched regardless of whitespace",
+			body: `[{"jsonrpc":"2.0","id":[ 1, 2 ],"method":"eth_chainId"},{"jsonrpc":"2.0","id":{"a": 1},"method":"eth_blockNumber"},{"jsonrpc":"2.0","id":3,"method":"eth_sendRawTransaction","params":["0x01"]}]`,
+			want: `[{"jsonrpc":"2.0","result":"eth_chainId","id":[1,2]},{"jsonrpc":"2.0","result":"eth_blockNumber","id":{"a":1}},{"jsonrpc":"2.0","result":"0xhash","id":3}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.HandleRequest(w, httptest.NewRequest(http.MethodPost, "/rollup1", strings.NewReader(tt.body)))
+			if got := strings.TrimSuffix(w.Body.String(), "\n"); got != tt.want {
+				t.Errorf("HandleRequest() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRollApp_HandleRequest_batchTransactions(t *testing.T) {
+	upstream := newSubmitUpstream(0)
+	defer upstream.Close()
+	elder := &fakeElder{batchSize: 3, rollAppBlock: "0x10"}
+	r, key := newSubmitTestRollApp(t, upstream.URL, elder)
+
+	// The transactions are admitted in order and join the same Elder batch, which is broadcast once full
+	requests := make([]string, 3)
+	for nonce := range requests {
+		requests[nonce] = fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"eth_sendRawTransaction","params":["%s"]}`, nonce, signTestTx(t, key, uint64(nonce), 1))
+	}
+	w := httptest.NewRecorder()
+	r.HandleRequest(w, httptest.NewRequest(http.MethodPost, "/rollup1", strings.NewReader("["+strings.Join(requests, ",")+"]")))
+
+	var responses []JsonRPCResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &responses); err != nil {
+		t.Fatalf("invalid batch response: %s", w.Body.String())
+	}
+	if len(responses) != len(requests) {
+		t.Fatalf("got %d responses, want %d", len(responses), len(requests))
+	}
+	for i, response := range responses {
+		if response.ID != float64(i) || response.Error != nil {
+			t.Errorf("response %d = id %v error %v, want id %d without error", i, response.ID, response.Error, i)
+		}
+	}
+	if len(elder.batches) != 1 {
+		t.Errorf("sent %d batches, want 1", len(elder.batches))
+	}
+
+	if err := r.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRollApp_submitRawTransaction_nonce(t *testing.T) {
+	tests := []struct {
+		name    string
+		nonce   uint64
+		fee     int64
+		wantErr error
+	}{
+		{name: "next nonce", nonce: 2, fee: 1},
+		{name: "same transaction", nonce: 1, fee: 1, wantErr: ErrAlreadyKnown},
+		{name: "replacement of a submitted transaction", nonce: 1, fee: 2, wantErr: ErrReplacementSubmitted},
+		{name: "nonce used on the rollApp", nonce: 0, fee: 1, wantErr: ErrNonceTooLow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newSubmitUpstream(1)
+			defer upstream.Close()
+			r, key := newSubmitTestRollApp(t, upstream.URL, &fakeElder{batchSize: 1, rollAppBlock: "0x10"})
+
+			// The transaction with nonce 1 is waiting for rollApp inclusion
+			if _, err := r.submitRawTransaction(context.Background(), signTestTx(t, key, 1, 1), nil); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := r.submitRawTransaction(context.Background(), signTestTx(t, key, tt.nonce, tt.fee), nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("submitRawTransaction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err := r.Drain(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestRollApp_policySpend(t *testing.T) {
+	upstream := newSubmitUpstream(1)
+	defer upstream.Close()
+	elder := &fakeElder{batchSize: 1, rollAppBlock: "0x10", fee: 700}
+	r, key := newSubmitTestRollApp(t, upstream.URL, elder)
+	engine, err := policy.New(policy.File{Rules: []policy.Rule{
+		{Name: "rate", Action: policy.ActionAllow, SenderRate: policy.Rate{Txs: 1, Interval: time.Hour}, MaxDailyElderFee: 1000},
+	}}, r.keyStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetPolicy(engine)
+
+	steps := []struct {
+		name         string
+		nonce        uint64
+		broadcastErr error
+		wantCode     int
+	}{
+		{name: "nonce too low", nonce: 0, wantCode: NonceMismatchCode},
+		{name: "broadcast failure", nonce: 1, broadcastErr: errors.New("elder unavailable"), wantCode: BroadcastFailedCode},
+		{name: "broadcast", nonce: 1},
+		{name: "rate spent", nonce: 2, wantCode: PolicyRejectedCode},
+	}
+
+	for _, step := range steps {
+		elder.err = step.broadcastErr
+		_, err := r.submitRawTransaction(context.Background(), signTestTx(t, key, step.nonce, 1), nil)
+		if step.wantCode == 0 && err != nil {
+			t.Errorf("%s: submitRawTransaction() error = %v", step.name, err)
+		}
+		if step.wantCode != 0 && toRPCError(err).Code != step.wantCode {
+			t.Errorf("%s: submitRawTransaction() error = %v, want code %d", step.name, err, step.wantCode)
+		}
+	}
+	if err := r.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
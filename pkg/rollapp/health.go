@@ -0,0 +1,52 @@
+package rollapp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/health"
+	"github.com/pkg/errors"
+)
+
+// readiness is the state of a rollApp reported by its readiness check
+type readiness struct {
+	ChainID          uint64    `json:"chain_id"`
+	BlockNumber      uint64    `json:"block_number"`
+	BlockAdvancedAt  time.Time `json:"block_advanced_at"`
+	HealthyUpstreams int       `json:"healthy_upstreams"`
+	Upstreams        int       `json:"upstreams"`
+}
+
+// ReadinessCheck checks that the rollApp RPCs answer the chain id and that the rollApp produced
+// a block within maxBlockAge
+func (r *RollApp) ReadinessCheck(maxBlockAge time.Duration) health.Check {
+	return health.Check{
+		Name: "rollapp:" + r.Name,
+		Check: func(ctx context.Context) (interface{}, error) {
+			status := readiness{}
+			upstreams := r.pool()
+			for _, upstream := range upstreams.status() {
+				status.Upstreams++
+				if upstream.Healthy {
+					status.HealthyUpstreams++
+				}
+			}
+			status.BlockNumber, status.BlockAdvancedAt = upstreams.latestBlock()
+
+			chainId, err := r.GetRollAppId(ctx)
+			if err != nil {
+				return status, errors.Wrap(err, "failed to get chain id")
+			}
+			status.ChainID = chainId
+
+			if status.BlockAdvancedAt.IsZero() {
+				return status, errors.New("no block number from the rollApp RPCs yet")
+			}
+			if age := time.Since(status.BlockAdvancedAt); age > maxBlockAge {
+				return status, fmt.Errorf("block number %d has not advanced for %s", status.BlockNumber, age.Round(time.Second))
+			}
+			return status, nil
+		},
+	}
+}
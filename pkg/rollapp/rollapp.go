@@ -1,52 +1,444 @@
 package rollapp
 
 import (
-	"bytes"
 	"context"
 	"encoding/hex"
-	"io"
+	"math/big"
 	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
 
 	"github.com/pkg/errors"
 
+	"github.com/0xElder/elder-wrap/pkg/config"
 	"github.com/0xElder/elder-wrap/pkg/elder"
+	"github.com/0xElder/elder-wrap/pkg/journal"
 	"github.com/0xElder/elder-wrap/pkg/keystore"
 	"github.com/0xElder/elder-wrap/pkg/logging"
+	"github.com/0xElder/elder-wrap/pkg/metrics"
+	"github.com/0xElder/elder-wrap/pkg/policy"
+	"github.com/0xElder/elder-wrap/pkg/tracing"
+	routertypes "github.com/0xElder/elder/x/router/types"
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
+// elderSubmitter is the part of the Elder client submitting the rollApp transactions
+type elderSubmitter interface {
+	AccountNumber(ctx context.Context, elderAddress string) (uint64, error)
+	Enqueue(ctx context.Context, key *keystore.Key, msg *routertypes.MsgSubmitRollTx) func() (elder.Broadcast, error)
+	EstimateFee() uint64
+	RollAppBlock(ctx context.Context, elderTxHash string) (string, error)
+}
+
 type RollApp struct {
-	RPC                string
-	ElderRegistationId uint64
-	client             *ethclient.Client
-	logger             logging.Logger
-	keyStore           keystore.KeyStore
-	elderClient        *elder.ElderClient
+	Name        string
+	logger      logging.Logger
+	keyStore    keystore.KeyStore
+	elderClient elderSubmitter
+	submissions *submissions
+	txPool      *txPool
+	pendingTxs  txFeed
+	quit        chan struct{}
+	// paused rejects new submissions, the inclusion of those already broadcast is still confirmed
+	paused atomic.Bool
+
+	// settingsMu guards the state Reconfigure replaces
+	settingsMu    sync.RWMutex
+	cfg           config.RollAppConfig
+	upstreams     *upstreamPool
+	methods       map[string]methodHandler
+	starts        map[string]startHandler
+	sponsorPolicy SponsorPolicy
+	policy        *policy.Engine
+	// errorABIs decode the custom errors of the reverts found by the simulation
+	errorABIs []abi.ABI
+
+	drainMu  sync.Mutex
+	draining bool
+	// inflight counts the submissions and the background work they started, Drain waits for it
+	inflight sync.WaitGroup
+}
+
+func NewRollApp(name string, cfg *config.RollAppConfig, txPoolCfg config.TxPoolConfig, keyStore keystore.KeyStore, logger logging.Logger, elderClient *elder.ElderClient, journal *journal.Journal) (*RollApp, error) {
+	if err := checkSponsorKey(keyStore, cfg); err != nil {
+		logger.Error(nil, "Invalid sponsor key", "alias", cfg.SponsorKey, "error", err)
+		return nil, err
+	}
+	errorABIs, err := loadErrorABIs(cfg.Simulation)
+	if err != nil {
+		logger.Error(nil, "Invalid simulation abi files", "error", err)
+		return nil, err
+	}
+
+	upstreams, err := newUpstreamPool(name, cfg.Upstreams(), cfg.UpstreamSelection, cfg.MaxBlockLag, logger.With("component", "Upstreams"))
+	if err != nil {
+		return nil, err
+	}
+
+	r := &RollApp{
+		Name:        name,
+		logger:      logger,
+		keyStore:    keyStore,
+		elderClient: elderClient,
+		submissions: newSubmissions(journal, name, metrics.TxPoolPending.WithLabelValues(name), logger.With("component", "Submissions")),
+		txPool:      newTxPool(txPoolCfg.PriceBump, txPoolCfg.MaxQueuedPerSender, txPoolCfg.MaxQueued, txPoolCfg.PersistPath(name), metrics.TxPoolQueued.WithLabelValues(name), logger.With("component", "TxPool")),
+		quit:        make(chan struct{}),
+		cfg:         *cfg,
+		upstreams:   upstreams,
+		errorABIs:   errorABIs,
+	}
+	if cfg.SponsorKey != "" {
+		r.sponsorPolicy = newSponsorPolicy(cfg.SponsorPolicy)
+	}
+	r.registerMethods()
+
+	if err := r.loadTxPool(); err != nil {
+		upstreams.close()
+		return nil, errors.Wrap(err, "failed to load tx pool")
+	}
+
+	if err := r.restoreSubmissions(); err != nil {
+		upstreams.close()
+		return nil, err
+	}
+
+	go r.runTxPool()
+	go r.upstreams.run(cfg.HealthCheckInterval, r.quit)
+	return r, nil
+}
+
+// restoreSubmissions loads the submission journal and reconciles the submissions left unfinished by
+// the previous run in the background
+func (r *RollApp) restoreSubmissions() error {
+	unfinished, err := r.submissions.load()
+	if err != nil {
+		return errors.Wrap(err, "failed to load submission journal")
+	}
+	if len(unfinished) > 0 {
+		r.reserveUnfinished(unfinished)
+		r.background(func() { r.reconcile(context.Background(), unfinished) })
+	}
+	return nil
+}
+
+// track registers in-flight work which Drain waits for, it returns false once the rollApp is draining.
+// The work calls r.inflight.Done when it is finished.
+func (r *RollApp) track() bool {
+	r.drainMu.Lock()
+	defer r.drainMu.Unlock()
+
+	if r.draining {
+		return false
+	}
+	r.inflight.Add(1)
+	return true
+}
+
+// isDraining returns whether Drain was called
+func (r *RollApp) isDraining() bool {
+	r.drainMu.Lock()
+	defer r.drainMu.Unlock()
+
+	return r.draining
+}
+
+// background runs f in a goroutine which Drain waits for. It is called from tracked work, or before
+// the rollApp serves requests.
+func (r *RollApp) background(f func()) {
+	r.inflight.Add(1)
+	go func() {
+		defer r.inflight.Done()
+		f()
+	}()
+}
+
+// Drain stops accepting transactions and waits until the in-flight submissions and inclusion checks
+// are finished, or ctx is done. Submissions still pending are reconciled from the journal on restart.
+func (r *RollApp) Drain(ctx context.Context) error {
+	r.drainMu.Lock()
+	r.draining = true
+	r.drainMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "in-flight submissions did not finish")
+	}
+}
+
+// Pause rejects the transactions submitted to the rollApp until Resume
+func (r *RollApp) Pause() {
+	if !r.paused.Swap(true) {
+		r.logger.Info(nil, "Submissions paused")
+	}
+}
+
+// Resume accepts transactions again after Pause
+func (r *RollApp) Resume() {
+	if r.paused.Swap(false) {
+		r.logger.Info(nil, "Submissions resumed")
+	}
+}
+
+// Paused returns whether submissions to the rollApp are paused
+func (r *RollApp) Paused() bool {
+	return r.paused.Load()
 }
 
-func NewRollApp(rpc string, elderId uint64, keyStore keystore.KeyStore, logger logging.Logger, elderClient *elder.ElderClient) (*RollApp, error) {
-	client, err := ethclient.Dial(rpc)
+// Reconfigure applies a reloaded config to the rollApp while it serves requests. The tx pool and the
+// submissions are kept, the upstream clients are replaced when the rpcs or their probing changed.
+func (r *RollApp) Reconfigure(cfg *config.RollAppConfig) error {
+	reconfiguration, err := r.PrepareReconfigure(cfg)
 	if err != nil {
+		return err
+	}
+	reconfiguration.Apply()
+	return nil
+}
+
+// Reconfiguration is a reloaded rollApp config checked and built by PrepareReconfigure, it takes effect
+// with Apply or is dropped with Discard
+type Reconfiguration struct {
+	r         *RollApp
+	cfg       config.RollAppConfig
+	errorABIs []abi.ABI
+	// upstreams replace the upstreams of the rollApp, nil when they are kept
+	upstreams *upstreamPool
+}
+
+// PrepareReconfigure checks cfg and builds what it needs without changing the rollApp, so the
+// reconfiguration of several rollApps can be applied together once all of them are prepared
+func (r *RollApp) PrepareReconfigure(cfg *config.RollAppConfig) (*Reconfiguration, error) {
+	logger := r.logger.With("method", "PrepareReconfigure")
+
+	if err := checkSponsorKey(r.keyStore, cfg); err != nil {
+		logger.Error(nil, "Invalid sponsor key", "alias", cfg.SponsorKey, "error", err)
+		return nil, err
+	}
+	errorABIs, err := loadErrorABIs(cfg.Simulation)
+	if err != nil {
+		logger.Error(nil, "Invalid simulation abi files", "error", err)
 		return nil, err
 	}
 
-	return &RollApp{
-		RPC:                rpc,
-		ElderRegistationId: elderId,
-		client:             client,
-		logger:             logger,
-		keyStore:           keyStore,
-		elderClient:        elderClient,
-	}, nil
+	current := r.settings()
+	var upstreams *upstreamPool
+	if upstreamsChanged(&current, cfg) {
+		upstreams, err = newUpstreamPool(r.Name, cfg.Upstreams(), cfg.UpstreamSelection, cfg.MaxBlockLag, r.logger.With("component", "Upstreams"))
+		if err != nil {
+			logger.Error(nil, "Failed to create upstreams", "error", err)
+			return nil, errors.Wrap(err, "failed to create upstreams")
+		}
+	}
+	return &Reconfiguration{r: r, cfg: *cfg, errorABIs: errorABIs, upstreams: upstreams}, nil
+}
+
+// Apply reconfigures the rollApp, it can't fail
+func (c *Reconfiguration) Apply() {
+	r := c.r
+	logger := r.logger.With("method", "Reconfigure")
+
+	r.settingsMu.Lock()
+	current := r.cfg
+	r.cfg = c.cfg
+	r.registerMethods()
+	r.errorABIs = c.errorABIs
+	if c.cfg.SponsorKey == "" {
+		r.sponsorPolicy = nil
+	} else if current.SponsorKey == "" || !reflect.DeepEqual(current.SponsorPolicy, c.cfg.SponsorPolicy) {
+		// The budget spent today is kept while the policy is unchanged
+		r.sponsorPolicy = newSponsorPolicy(c.cfg.SponsorPolicy)
+	}
+	retired := r.upstreams
+	if c.upstreams != nil {
+		r.upstreams = c.upstreams
+	}
+	r.settingsMu.Unlock()
+
+	if c.upstreams != nil {
+		logger.Info(nil, "Replaced rollApp RPC upstreams", "rpc", c.cfg.RPC, "rpcs", c.cfg.RPCs)
+		go c.upstreams.run(c.cfg.HealthCheckInterval, r.quit)
+		retired.retire()
+	}
+}
+
+// Discard drops a reconfiguration which is not applied, closing the upstreams it created
+func (c *Reconfiguration) Discard() {
+	if c.upstreams != nil {
+		c.upstreams.close()
+	}
+}
+
+func upstreamsChanged(current, cfg *config.RollAppConfig) bool {
+	return !reflect.DeepEqual(current.Upstreams(), cfg.Upstreams()) ||
+		current.UpstreamSelection != cfg.UpstreamSelection ||
+		current.HealthCheckInterval != cfg.HealthCheckInterval ||
+		current.MaxBlockLag != cfg.MaxBlockLag
+}
+
+// SetPolicy sets the policy file engine evaluated before the transactions are submitted to Elder,
+// nil evaluates none
+func (r *RollApp) SetPolicy(engine *policy.Engine) {
+	r.settingsMu.Lock()
+	defer r.settingsMu.Unlock()
+
+	r.policy = engine
+}
+
+// Reservation is the part of a rate or budget held for a transaction until it is broadcast to Elder,
+// it is settled with the Elder fee paid for the transaction or released when it is not broadcast
+type Reservation interface {
+	Settle(fee uint64)
+	Release()
+}
+
+// admission is what a transaction holds from its admission until its broadcast to Elder: the
+// reservations made for it in the rates and budgets it is counted in
+type admission struct {
+	reserved []Reservation
+	// sponsored is set when the sponsor key pays the Elder fee, see submitterKey
+	sponsored bool
+	// unchecked is set for the transactions loaded from the persisted tx pool, they go through the
+	// sponsor and policy checks in force when they are promoted
+	unchecked bool
+}
+
+// reserve adds a reservation to the admission, reservation may be nil
+func (a *admission) reserve(reservation Reservation) {
+	if reservation != nil {
+		a.reserved = append(a.reserved, reservation)
+	}
+}
+
+// settle settles the reservations with the Elder fee paid for the broadcast transaction
+func (a *admission) settle(fee uint64) {
+	if a == nil {
+		return
+	}
+	for _, reservation := range a.reserved {
+		reservation.Settle(fee)
+	}
+}
+
+// release gives back the reservations of a transaction which is not broadcast
+func (a *admission) release() {
+	if a == nil {
+		return
+	}
+	for _, reservation := range a.reserved {
+		reservation.Release()
+	}
+}
+
+// checkAdmission runs the sponsor and policy checks of a verified transaction and keeps their
+// reservations in adm. Nothing stays reserved when a check rejects the transaction.
+func (r *RollApp) checkAdmission(ctx context.Context, tx *types.Transaction, key *keystore.Key, adm *admission) error {
+	if adm.sponsored {
+		reservation, err := r.sponsor(ctx, key.EvmAddress, tx)
+		if err != nil {
+			metrics.SubmissionFailures.WithLabelValues(r.Name, metrics.ReasonSponsor).Inc()
+			return err
+		}
+		adm.reserve(reservation)
+	}
+	reservation, err := r.checkPolicy(ctx, tx, key)
+	if err != nil {
+		adm.release()
+		metrics.SubmissionFailures.WithLabelValues(r.Name, metrics.ReasonPolicy).Inc()
+		return err
+	}
+	adm.reserve(reservation)
+	adm.unchecked = false
+	return nil
+}
+
+// checkPolicy evaluates the policy file engine on a verified transaction and returns the reservation
+// it made in the rates and budgets, nil when there is none
+func (r *RollApp) checkPolicy(ctx context.Context, tx *types.Transaction, key *keystore.Key) (Reservation, error) {
+	logger := r.logger.With("method", "checkPolicy")
+
+	r.settingsMu.RLock()
+	engine := r.policy
+	r.settingsMu.RUnlock()
+	if engine == nil {
+		return nil, nil
+	}
+
+	reservation, err := engine.Evaluate(ctx, policy.Request{RollApp: r.Name, Sender: key.EvmAddress, Key: key, Tx: tx, Fee: r.elderClient.EstimateFee()})
+	if err != nil {
+		var denied *policy.DeniedError
+		if errors.As(err, &denied) {
+			logger.Warn(ctx, "Policy rejected transaction", "sender", key.EvmAddress.Hex(), "txHash", tx.Hash().Hex(), "rule", denied.Rule, "reason", denied.Reason)
+			var data interface{}
+			if denied.Rule != "" {
+				data = map[string]string{"rule": denied.Rule}
+			}
+			return nil, withCode(PolicyRejectedCode, errors.Wrap(err, "policy rejected transaction"), data)
+		}
+		logger.Error(ctx, "Failed to evaluate policy", "txHash", tx.Hash().Hex(), "error", err)
+		return nil, errors.Wrap(err, "failed to evaluate policy")
+	}
+	if reservation == nil {
+		return nil, nil
+	}
+	return reservation, nil
 }
 
-func (r *RollApp) GetRollAppId(ctx context.Context) (uint64, error) {
+// settings returns the current rollApp config
+func (r *RollApp) settings() config.RollAppConfig {
+	r.settingsMu.RLock()
+	defer r.settingsMu.RUnlock()
+
+	return r.cfg
+}
+
+// pool returns the current rollApp RPC upstreams
+func (r *RollApp) pool() *upstreamPool {
+	r.settingsMu.RLock()
+	defer r.settingsMu.RUnlock()
+
+	return r.upstreams
+}
+
+// Close stops the background loops and closes the rollApp RPC clients
+func (r *RollApp) Close() {
+	close(r.quit)
+	r.pool().close()
+}
+
+// call runs fn with the ethclient of a rollApp RPC and fails over to the next one, see upstreamPool.call
+func (r *RollApp) call(ctx context.Context, fn func(client *ethclient.Client) error) error {
+	return r.pool().call(ctx, fn)
+}
+
+// UpstreamStatus returns the health of the rollApp RPC endpoints
+func (r *RollApp) UpstreamStatus() []UpstreamStatus {
+	return r.pool().status()
+}
+
+func (r *RollApp) GetRollAppId(ctx context.Context) (_ uint64, err error) {
+	ctx, span := tracing.Start(ctx, "GetRollAppId", tracing.RollAppKey.String(r.Name))
+	defer func() { tracing.End(span, err) }()
+
 	logger := r.logger.With("method", "GetRollAppId")
 	logger.Debug(ctx, "Fetching chain ID from rollapp RPC")
-	id, err := r.client.ChainID(ctx)
+	var id *big.Int
+	err = r.call(ctx, func(client *ethclient.Client) (err error) {
+		id, err = client.ChainID(ctx)
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -54,10 +446,17 @@ func (r *RollApp) GetRollAppId(ctx context.Context) (uint64, error) {
 	return id.Uint64(), nil
 }
 
-func (r *RollApp) GetAddressNonce(ctx context.Context, address string) (uint64, error) {
+func (r *RollApp) GetAddressNonce(ctx context.Context, address string) (_ uint64, err error) {
+	ctx, span := tracing.Start(ctx, "GetAddressNonce", tracing.RollAppKey.String(r.Name), tracing.SenderKey.String(address))
+	defer func() { tracing.End(span, err) }()
+
 	logger := r.logger.With("method", "GetAddressNonce")
 	logger.Debug(ctx, "Fetching nonce for address", "address", address)
-	nonce, err := r.client.PendingNonceAt(ctx, common.HexToAddress(address))
+	var nonce uint64
+	err = r.call(ctx, func(client *ethclient.Client) (err error) {
+		nonce, err = client.PendingNonceAt(ctx, common.HexToAddress(address))
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -65,96 +464,91 @@ func (r *RollApp) GetAddressNonce(ctx context.Context, address string) (uint64,
 	return nonce, nil
 }
 
-func (r *RollApp) VerifyRollAppTx(ctx context.Context, rawTx string) (*types.Transaction, *keystore.Key, error) {
+// VerifyRollAppTx decodes and verifies a raw rollApp transaction, it returns the key submitting it to Elder
+// and whether that key is the sponsor key
+func (r *RollApp) VerifyRollAppTx(ctx context.Context, rawTx string) (_ *types.Transaction, _ *keystore.Key, sponsored bool, err error) {
+	ctx, span := tracing.Start(ctx, "VerifyRollAppTx", tracing.RollAppKey.String(r.Name))
+	defer func() { tracing.End(span, err) }()
+
 	logger := r.logger.With("method", "VerifyRollAppTx")
 	logger.Debug(ctx, "Verifying rollapp transaction", "rawTx", rawTx)
 	txBytes, err := hex.DecodeString(rawTx)
 	if err != nil {
 		logger.Error(ctx, "Failed to decode raw transaction", "error", err)
-		return nil, nil, errors.Wrap(err, "failed to decode raw transaction")
+		return nil, nil, false, withCode(InvalidParamsCode, errors.Wrap(err, "failed to decode raw transaction"), nil)
 	}
 
 	var tx types.Transaction
 	err = tx.UnmarshalBinary(txBytes)
 	if err != nil {
 		logger.Error(ctx, "Failed to unmarshal transaction", "error", err)
-		return nil, nil, errors.Wrap(err, "failed to unmarshal transaction")
+		return nil, nil, false, withCode(InvalidParamsCode, errors.Wrap(err, "failed to unmarshal transaction"), nil)
 	}
+	span.SetAttributes(tracing.TxHashKey.String(tx.Hash().Hex()))
 
 	txChainId := tx.ChainId()
 	chainIdRPC, err := r.GetRollAppId(ctx)
 	if err != nil {
 		logger.Error(ctx, "Failed to get chain id", "error", err)
-		return nil, nil, errors.Wrap(err, "failed to get chain id")
+		return nil, nil, false, errors.Wrap(err, "failed to get chain id")
 	}
 
 	if txChainId.Uint64() != chainIdRPC {
 		logger.Error(ctx, "Chain id mismatch", "expected", chainIdRPC, "got", txChainId.Uint64())
-		return nil, nil, errors.New("chain id mismatch")
+		return nil, nil, false, chainIdMismatch(chainIdRPC, txChainId.Uint64())
 	}
 
 	fromAddress, err := types.LatestSignerForChainID(txChainId).Sender(&tx)
 	if err != nil {
 		logger.Error(ctx, "Failed to get sender address", "error", err)
-		return nil, nil, errors.Wrap(err, "failed to get sender address")
+		return nil, nil, false, errors.Wrap(err, "failed to get sender address")
 	}
+	span.SetAttributes(tracing.SenderKey.String(fromAddress.Hex()))
 
-	KeyListByEvmAddress, err := r.keyStore.ListByEvmAddress()
+	key, sponsored, err := r.submitterKey(ctx, fromAddress)
 	if err != nil {
-		logger.Error(ctx, "Failed to list keys by EVM address", "error", err)
-		return nil, nil, errors.Wrap(err, "failed to list keys by EVM address")
-	}
-
-	key, ok := KeyListByEvmAddress[fromAddress]
-	if !ok {
-		logger.Error(ctx, "Key not found in keystore", "address", fromAddress.Hex())
-		return nil, nil, errors.New("key not found in keystore")
+		return nil, nil, false, err
 	}
 
 	address := key.EvmAddress
 	if fromAddress.Cmp(address) != 0 {
 		logger.Error(ctx, "Sender address does not match key address", "expected", address.Hex(), "got", fromAddress.Hex())
-		return nil, nil, errors.New("sender address does not match key address")
+		return nil, nil, false, errors.New("sender address does not match key address")
 	}
 
-	nonce := tx.Nonce()
-	nonceRPC, err := r.GetAddressNonce(ctx, fromAddress.Hex())
-	if err != nil {
-		logger.Error(ctx, "Failed to get address nonce", "error", err)
-		return nil, nil, errors.Wrap(err, "failed to get address nonce")
-	}
-
-	if nonce != nonceRPC {
-		logger.Error(ctx, "Nonce mismatch", "expected", nonceRPC, "got", nonce)
-		return nil, nil, errors.New("nonce mismatch")
-	}
 	logger.Debug(ctx, "Transaction verified successfully", "rawTx", rawTx, "fromAddress", fromAddress.Hex())
-	logger.Debug(ctx, "Transaction details", "chainId", chainIdRPC, "nonce", nonce, "to", tx.To().Hex(), "value", tx.Value().String(), "data", tx.Data())
-	return &tx, key, nil
+	logger.Debug(ctx, "Transaction details", "chainId", chainIdRPC, "nonce", tx.Nonce(), "to", tx.To(), "value", tx.Value().String(), "data", tx.Data())
+	return &tx, key, sponsored, nil
 }
 
-func (r *RollApp) ForwardtoRollAppRPC(w http.ResponseWriter, body []byte) {
+func (r *RollApp) ForwardtoRollAppRPC(ctx context.Context, w http.ResponseWriter, body []byte) {
 	logger := r.logger.With("method", "ForwardtoRollAppRPC")
-	logger.Debug(context.Background(), "Forwarding request to rollApp RPC", "rpc", r.RPC)
-	// Forward the request to the rollApp RPC endpoint
-	resp, err := http.Post(r.RPC, "application/json", bytes.NewBuffer(body))
-	if err != nil {
-		logger.Error(context.Background(), "Failed to forward request to rollApp RPC", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
 
-	// Read the response from the rollApp RPC
-	responseBody, err := io.ReadAll(resp.Body)
+	spanCtx, span := tracing.Start(ctx, "ForwardtoRollAppRPC", tracing.RollAppKey.String(r.Name))
+	responseBody, err := r.relay(spanCtx, body)
+	tracing.End(span, err)
 	if err != nil {
-		logger.Error(context.Background(), "Failed to read response from rollApp RPC", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		r.writeResponse(w, errorResponse(nil, withCode(InternalErrorCode, errors.Wrap(err, "failed to reach rollApp RPC"), nil)))
 		return
 	}
 
 	// Write the response to the client
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(responseBody)
-	logger.Debug(context.Background(), "Forwarded response to client", "status", resp.StatusCode, "response", string(responseBody))
+	logger.Debug(ctx, "Forwarded response to client", "response", string(responseBody))
+}
+
+// relay posts a JSON-RPC request to the rollApp RPC endpoints, failing over between them, and returns the response body
+func (r *RollApp) relay(ctx context.Context, body []byte) ([]byte, error) {
+	logger := r.logger.With("method", "relay")
+	logger.Debug(ctx, "Forwarding request to rollApp RPC")
+
+	responseBody, err := r.pool().relay(ctx, body)
+	if err != nil {
+		logger.Error(ctx, "Failed to forward request to rollApp RPC", "error", err)
+		return nil, err
+	}
+	return responseBody, nil
 }
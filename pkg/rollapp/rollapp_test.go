@@ -0,0 +1,112 @@
+package rollapp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/0xElder/elder-wrap/pkg/logging"
+)
+
+func TestRollApp_Drain(t *testing.T) {
+	tests := []struct {
+		name    string
+		work    time.Duration
+		timeout time.Duration
+		wantErr bool
+	}{
+		{
+			name:    "in-flight work finishes",
+			work:    10 * time.Millisecond,
+			timeout: time.Second,
+			wantErr: false,
+		},
+		{
+			name:    "in-flight work outlives the timeout",
+			work:    time.Second,
+			timeout: 10 * time.Millisecond,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RollApp{}
+			if !r.track() {
+				t.Fatal("track() = false before Drain")
+			}
+			r.background(func() {
+				defer r.inflight.Done()
+				time.Sleep(tt.work)
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			if err := r.Drain(ctx); (err != nil) != tt.wantErr {
+				t.Errorf("Drain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if r.track() {
+				t.Error("track() = true after Drain")
+			}
+		})
+	}
+}
+
+func TestRollApp_Reconfigure(t *testing.T) {
+	first := newTestUpstream(100)
+	defer first.Close()
+	second := newTestUpstream(100)
+	defer second.Close()
+
+	cfg := &config.RollAppConfig{
+		RPC:                 first.URL,
+		UpstreamSelection:   config.UpstreamSelectionRoundRobin,
+		HealthCheckInterval: time.Hour,
+		MaxBlockLag:         config.DefaultMaxBlockLag,
+		ElderRegistrationId: 1,
+		SubmissionMode:      config.SubmissionModeSync,
+	}
+	r, err := NewRollApp("rollup1", cfg, config.TxPoolConfig{}, nil, logging.NewDevSlogger(nil), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	pool := r.pool()
+	sendTransaction := func() error {
+		handler, ok := r.method("eth_sendTransaction")
+		if !ok {
+			t.Fatal("eth_sendTransaction is relayed to the rollApp RPC")
+		}
+		_, err := handler(context.Background(), nil)
+		return err
+	}
+	if err := sendTransaction(); toRPCError(err).Code != MethodNotFoundCode {
+		t.Errorf("eth_sendTransaction without node signing error = %v, want code %d", err, MethodNotFoundCode)
+	}
+
+	reconfigured := *cfg
+	reconfigured.NodeSigning = true
+	if err := r.Reconfigure(&reconfigured); err != nil {
+		t.Fatal(err)
+	}
+	if r.pool() != pool {
+		t.Error("Reconfigure() replaced the upstreams while the rpcs did not change")
+	}
+	if err := sendTransaction(); toRPCError(err).Code == MethodNotFoundCode {
+		t.Error("Reconfigure() did not register the node signing methods")
+	}
+
+	reconfigured.RPC = second.URL
+	if err := r.Reconfigure(&reconfigured); err != nil {
+		t.Fatal(err)
+	}
+	if statuses := r.UpstreamStatus(); len(statuses) != 1 || statuses[0].URL != second.URL {
+		t.Errorf("UpstreamStatus() = %v, want %s only", statuses, second.URL)
+	}
+	select {
+	case <-pool.stopped:
+	default:
+		t.Error("Reconfigure() did not stop probing the replaced upstreams")
+	}
+}
@@ -0,0 +1,402 @@
+package rollapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"sort"
+
+	"github.com/0xElder/elder-wrap/pkg/keystore"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/pkg/errors"
+)
+
+// TransactionArgs are the arguments of eth_sendTransaction and eth_signTransaction
+type TransactionArgs struct {
+	From                 *common.Address `json:"from"`
+	To                   *common.Address `json:"to"`
+	Gas                  *hexutil.Uint64 `json:"gas"`
+	GasPrice             *hexutil.Big    `json:"gasPrice"`
+	MaxFeePerGas         *hexutil.Big    `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big    `json:"maxPriorityFeePerGas"`
+	Value                *hexutil.Big    `json:"value"`
+	Nonce                *hexutil.Uint64 `json:"nonce"`
+	Data                 *hexutil.Bytes  `json:"data"`
+	Input                *hexutil.Bytes  `json:"input"`
+	ChainID              *hexutil.Big    `json:"chainId"`
+}
+
+// SignTransactionResult is the result of eth_signTransaction
+type SignTransactionResult struct {
+	Raw hexutil.Bytes      `json:"raw"`
+	Tx  *types.Transaction `json:"tx"`
+}
+
+// accounts serves eth_accounts and eth_requestAccounts with the EVM addresses in the keystore
+func (r *RollApp) accounts(ctx context.Context, params []interface{}) (interface{}, error) {
+	keys, err := r.keyStore.ListByEvmAddress()
+	if err != nil {
+		r.logger.Error(ctx, "Failed to list keys by EVM address", "error", err)
+		return nil, errors.Wrap(err, "failed to list keys by EVM address")
+	}
+
+	addresses := make([]common.Address, 0, len(keys))
+	for address := range keys {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return bytes.Compare(addresses[i].Bytes(), addresses[j].Bytes()) < 0
+	})
+	return addresses, nil
+}
+
+// sign serves eth_sign, params are [address, data]
+func (r *RollApp) sign(ctx context.Context, params []interface{}) (interface{}, error) {
+	var (
+		address common.Address
+		data    hexutil.Bytes
+	)
+	if err := decodeParam(params, 0, &address); err != nil {
+		return nil, err
+	}
+	if err := decodeParam(params, 1, &data); err != nil {
+		return nil, err
+	}
+	return r.signText(ctx, address, data)
+}
+
+// personalSign serves personal_sign, params are [data, address]
+func (r *RollApp) personalSign(ctx context.Context, params []interface{}) (interface{}, error) {
+	var (
+		data    hexutil.Bytes
+		address common.Address
+	)
+	if err := decodeParam(params, 0, &data); err != nil {
+		return nil, err
+	}
+	if err := decodeParam(params, 1, &address); err != nil {
+		return nil, err
+	}
+	return r.signText(ctx, address, data)
+}
+
+// signText signs data as an EIP-191 personal message
+func (r *RollApp) signText(ctx context.Context, address common.Address, data []byte) (hexutil.Bytes, error) {
+	r.logger.Debug(ctx, "Signing message", "address", address.Hex())
+	return r.signHash(ctx, address, accounts.TextHash(data))
+}
+
+// signTypedData serves eth_signTypedData_v4, params are [address, typedData]
+// where typedData is either a JSON object or a JSON encoded string
+func (r *RollApp) signTypedData(ctx context.Context, params []interface{}) (interface{}, error) {
+	var (
+		address common.Address
+		raw     json.RawMessage
+	)
+	if err := decodeParam(params, 0, &address); err != nil {
+		return nil, err
+	}
+	if err := decodeParam(params, 1, &raw); err != nil {
+		return nil, err
+	}
+
+	var encoded string
+	if err := json.Unmarshal(raw, &encoded); err == nil {
+		raw = json.RawMessage(encoded)
+	}
+
+	var typedData apitypes.TypedData
+	if err := json.Unmarshal(raw, &typedData); err != nil {
+		return nil, withCode(InvalidParamsCode, errors.Wrap(err, "invalid typed data"), nil)
+	}
+
+	hash, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		r.logger.Error(ctx, "Failed to hash typed data", "error", err)
+		return nil, errors.Wrap(err, "failed to hash typed data")
+	}
+
+	r.logger.Debug(ctx, "Signing typed data", "address", address.Hex(), "primaryType", typedData.PrimaryType)
+	return r.signHash(ctx, address, hash)
+}
+
+// signHash signs a 32 bytes hash with the key of address, V is 27 or 28 as expected by
+// the signature verification of wallets and contracts
+func (r *RollApp) signHash(ctx context.Context, address common.Address, hash []byte) (hexutil.Bytes, error) {
+	logger := r.logger.With("method", "signHash")
+
+	key, err := r.keyForAddress(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := key.ECDSA()
+	if err != nil {
+		logger.Error(ctx, "Failed to convert private key", "error", err)
+		return nil, errors.Wrap(err, "failed to convert private key")
+	}
+
+	signature, err := crypto.Sign(hash, privateKey)
+	if err != nil {
+		logger.Error(ctx, "Failed to sign hash", "error", err)
+		return nil, errors.Wrap(err, "failed to sign hash")
+	}
+	// Transform V from 0/1 to 27/28 according to the yellow paper
+	signature[crypto.RecoveryIDOffset] += 27
+	return signature, nil
+}
+
+// signTransaction serves eth_signTransaction
+func (r *RollApp) signTransaction(ctx context.Context, params []interface{}) (interface{}, error) {
+	var args TransactionArgs
+	if err := decodeParam(params, 0, &args); err != nil {
+		return nil, err
+	}
+	if args.From == nil {
+		return nil, NewRPCError(InvalidParamsCode, "from address is required", nil)
+	}
+
+	unlock := r.txPool.lockSender(*args.From)
+	tx, err := r.fillAndSignTransaction(ctx, &args)
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode transaction")
+	}
+	return &SignTransactionResult{Raw: raw, Tx: tx}, nil
+}
+
+// startSendTransaction serves eth_sendTransaction, the transaction is signed with the sender's key
+// and submitted through Elder like eth_sendRawTransaction. The sender stays locked from the nonce
+// fill until the transaction is admitted, so concurrent calls get consecutive nonces.
+func (r *RollApp) startSendTransaction(ctx context.Context, params []interface{}) (func() (interface{}, error), error) {
+	var args TransactionArgs
+	if err := decodeParam(params, 0, &args); err != nil {
+		return nil, err
+	}
+	if args.From == nil {
+		return nil, NewRPCError(InvalidParamsCode, "from address is required", nil)
+	}
+
+	unlock := r.txPool.lockSender(*args.From)
+	tx, err := r.fillAndSignTransaction(ctx, &args)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		unlock()
+		return nil, errors.Wrap(err, "failed to encode transaction")
+	}
+
+	wait, err := r.startRawTransaction(ctx, hexutil.Encode(raw), unlock)
+	if err != nil {
+		return nil, err
+	}
+	return txHashResult(wait), nil
+}
+
+// fillAndSignTransaction fills in the missing nonce, gas and fee fields from the rollApp RPC
+// and signs the transaction with the sender's key, it must be called with the sender locked
+func (r *RollApp) fillAndSignTransaction(ctx context.Context, args *TransactionArgs) (*types.Transaction, error) {
+	logger := r.logger.With("method", "fillAndSignTransaction")
+
+	key, err := r.keyForAddress(ctx, *args.From)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, chainId, err := r.fillTransaction(ctx, args)
+	if err != nil {
+		logger.Error(ctx, "Failed to fill transaction", "error", err)
+		return nil, err
+	}
+
+	privateKey, err := key.ECDSA()
+	if err != nil {
+		logger.Error(ctx, "Failed to convert private key", "error", err)
+		return nil, errors.Wrap(err, "failed to convert private key")
+	}
+
+	signedTx, err := types.SignTx(tx, types.LatestSignerForChainID(chainId), privateKey)
+	if err != nil {
+		logger.Error(ctx, "Failed to sign transaction", "error", err)
+		return nil, errors.Wrap(err, "failed to sign transaction")
+	}
+	logger.Debug(ctx, "Signed transaction", "from", args.From.Hex(), "hash", signedTx.Hash().Hex(), "nonce", signedTx.Nonce())
+	return signedTx, nil
+}
+
+// fillTransaction builds an unsigned transaction from args, querying the rollApp RPC for unset fields.
+// The rollApp chain id is returned along with the transaction, it must be called with the sender locked.
+func (r *RollApp) fillTransaction(ctx context.Context, args *TransactionArgs) (*types.Transaction, *big.Int, error) {
+	if args.GasPrice != nil && (args.MaxFeePerGas != nil || args.MaxPriorityFeePerGas != nil) {
+		return nil, nil, NewRPCError(InvalidParamsCode, "both gasPrice and (maxFeePerGas or maxPriorityFeePerGas) specified", nil)
+	}
+	if args.Data != nil && args.Input != nil && !bytes.Equal(*args.Data, *args.Input) {
+		return nil, nil, NewRPCError(InvalidParamsCode, "both data and input fields are set and not equal", nil)
+	}
+
+	var data []byte
+	if args.Input != nil {
+		data = *args.Input
+	} else if args.Data != nil {
+		data = *args.Data
+	}
+
+	value := new(big.Int)
+	if args.Value != nil {
+		value = args.Value.ToInt()
+	}
+
+	rollAppId, err := r.GetRollAppId(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get chain id")
+	}
+	chainId := new(big.Int).SetUint64(rollAppId)
+	if args.ChainID != nil && args.ChainID.ToInt().Cmp(chainId) != 0 {
+		return nil, nil, chainIdMismatch(rollAppId, args.ChainID.ToInt().Uint64())
+	}
+
+	var nonce uint64
+	if args.Nonce != nil {
+		nonce = uint64(*args.Nonce)
+	} else {
+		rpcNonce, err := r.GetAddressNonce(ctx, args.From.Hex())
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to get address nonce")
+		}
+		// The transactions submitted to Elder are ahead of the rollApp pending nonce until included
+		nonce = r.txPool.nextNonce(*args.From, rpcNonce)
+	}
+
+	var gasPrice, gasTipCap, gasFeeCap *big.Int
+	if args.GasPrice != nil {
+		gasPrice = args.GasPrice.ToInt()
+	} else {
+		var header *types.Header
+		err = r.call(ctx, func(client *ethclient.Client) (err error) {
+			header, err = client.HeaderByNumber(ctx, nil)
+			return err
+		})
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to get latest header")
+		}
+
+		if header.BaseFee == nil {
+			// Pre London chain, fall back to legacy transactions. The 1559 fees are not turned into a gas
+			// price, the caller must set the price it agrees to pay.
+			if args.MaxFeePerGas != nil || args.MaxPriorityFeePerGas != nil {
+				return nil, nil, NewRPCError(InvalidParamsCode, "maxFeePerGas and maxPriorityFeePerGas are not supported, the rollApp has no base fee, use gasPrice", nil)
+			}
+			err = r.call(ctx, func(client *ethclient.Client) (err error) {
+				gasPrice, err = client.SuggestGasPrice(ctx)
+				return err
+			})
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "failed to suggest gas price")
+			}
+		} else {
+			if args.MaxPriorityFeePerGas != nil {
+				gasTipCap = args.MaxPriorityFeePerGas.ToInt()
+			} else {
+				err = r.call(ctx, func(client *ethclient.Client) (err error) {
+					gasTipCap, err = client.SuggestGasTipCap(ctx)
+					return err
+				})
+				if err != nil {
+					return nil, nil, errors.Wrap(err, "failed to suggest gas tip cap")
+				}
+				// The suggested tip never exceeds the caller's fee cap, as go-ethereum does
+				if args.MaxFeePerGas != nil && gasTipCap.Cmp(args.MaxFeePerGas.ToInt()) > 0 {
+					gasTipCap = new(big.Int).Set(args.MaxFeePerGas.ToInt())
+				}
+			}
+
+			if args.MaxFeePerGas != nil {
+				gasFeeCap = args.MaxFeePerGas.ToInt()
+			} else {
+				// Leave room for the base fee to double, as go-ethereum does
+				gasFeeCap = new(big.Int).Add(gasTipCap, new(big.Int).Mul(header.BaseFee, big.NewInt(2)))
+			}
+
+			if gasFeeCap.Cmp(gasTipCap) < 0 {
+				return nil, nil, errors.Errorf("maxFeePerGas (%v) < maxPriorityFeePerGas (%v)", gasFeeCap, gasTipCap)
+			}
+		}
+	}
+
+	var gas uint64
+	if args.Gas != nil {
+		gas = uint64(*args.Gas)
+	} else {
+		msg := ethereum.CallMsg{
+			From:      *args.From,
+			To:        args.To,
+			GasPrice:  gasPrice,
+			GasFeeCap: gasFeeCap,
+			GasTipCap: gasTipCap,
+			Value:     value,
+			Data:      data,
+		}
+		err = r.call(ctx, func(client *ethclient.Client) (err error) {
+			gas, err = client.EstimateGas(ctx, msg)
+			return err
+		})
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to estimate gas")
+		}
+	}
+
+	if gasPrice != nil {
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: gasPrice,
+			Gas:      gas,
+			To:       args.To,
+			Value:    value,
+			Data:     data,
+		}), chainId, nil
+	}
+
+	return types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainId,
+		Nonce:     nonce,
+		GasTipCap: gasTipCap,
+		GasFeeCap: gasFeeCap,
+		Gas:       gas,
+		To:        args.To,
+		Value:     value,
+		Data:      data,
+	}), chainId, nil
+}
+
+// keyForAddress returns the keystore key of an EVM address
+func (r *RollApp) keyForAddress(ctx context.Context, address common.Address) (*keystore.Key, error) {
+	keys, err := r.keyStore.ListByEvmAddress()
+	if err != nil {
+		r.logger.Error(ctx, "Failed to list keys by EVM address", "error", err)
+		return nil, errors.Wrap(err, "failed to list keys by EVM address")
+	}
+
+	key, ok := keys[address]
+	if !ok {
+		r.logger.Error(ctx, "Key not found in keystore", "address", address.Hex())
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
@@ -0,0 +1,268 @@
+package rollapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// eip712Mail is the typed data of the EIP-712 example, signed with keccak256("cow")
+const eip712Mail = `{
+	"types": {
+		"EIP712Domain": [
+			{"name": "name", "type": "string"},
+			{"name": "version", "type": "string"},
+			{"name": "chainId", "type": "uint256"},
+			{"name": "verifyingContract", "type": "address"}
+		],
+		"Person": [
+			{"name": "name", "type": "string"},
+			{"name": "wallet", "type": "address"}
+		],
+		"Mail": [
+			{"name": "from", "type": "Person"},
+			{"name": "to", "type": "Person"},
+			{"name": "contents", "type": "string"}
+		]
+	},
+	"primaryType": "Mail",
+	"domain": {
+		"name": "Ether Mail",
+		"version": "1",
+		"chainId": 1,
+		"verifyingContract": "0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC"
+	},
+	"message": {
+		"from": {"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},
+		"to": {"name": "Bob", "wallet": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"},
+		"contents": "Hello, Bob!"
+	}
+}`
+
+// recoverSigner returns the address which signed hash, signature has V 27 or 28
+func recoverSigner(t *testing.T, hash []byte, signature hexutil.Bytes) common.Address {
+	t.Helper()
+	if len(signature) != crypto.SignatureLength || signature[crypto.RecoveryIDOffset] < 27 {
+		t.Fatalf("signature %s is not a 65 bytes signature with V 27 or 28", signature)
+	}
+	sig := append([]byte{}, signature...)
+	sig[crypto.RecoveryIDOffset] -= 27
+	publicKey, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crypto.PubkeyToAddress(*publicKey)
+}
+
+func TestRollApp_signMessages(t *testing.T) {
+	upstream := newSubmitUpstream(0)
+	defer upstream.Close()
+	r, key := newSubmitTestRollApp(t, upstream.URL, &fakeElder{batchSize: 1})
+
+	var typedData apitypes.TypedData
+	if err := json.Unmarshal([]byte(eip712Mail), &typedData); err != nil {
+		t.Fatal(err)
+	}
+	typedDataHash, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := hexutil.Bytes("hello elder")
+
+	tests := []struct {
+		name    string
+		handler methodHandler
+		params  []interface{}
+		hash    []byte
+	}{
+		{name: "eth_sign", handler: r.sign, params: []interface{}{key.EvmAddress, message}, hash: accounts.TextHash(message)},
+		{name: "personal_sign", handler: r.personalSign, params: []interface{}{message, key.EvmAddress}, hash: accounts.TextHash(message)},
+		{name: "eth_signTypedData_v4", handler: r.signTypedData, params: []interface{}{key.EvmAddress, json.RawMessage(eip712Mail)}, hash: typedDataHash},
+		{name: "eth_signTypedData_v4 with JSON encoded typed data", handler: r.signTypedData, params: []interface{}{key.EvmAddress, eip712Mail}, hash: typedDataHash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.handler(context.Background(), tt.params)
+			if err != nil {
+				t.Fatalf("%s error = %v", tt.name, err)
+			}
+			if signer := recoverSigner(t, tt.hash, result.(hexutil.Bytes)); signer != key.EvmAddress {
+				t.Errorf("%s signed by %s, want %s", tt.name, signer.Hex(), key.EvmAddress.Hex())
+			}
+		})
+	}
+}
+
+func TestRollApp_signTypedData_eip712Vector(t *testing.T) {
+	upstream := newSubmitUpstream(0)
+	defer upstream.Close()
+	r, key := newSubmitTestRollApp(t, upstream.URL, &fakeElder{batchSize: 1})
+
+	if key.EvmAddress != common.HexToAddress("0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826") {
+		t.Fatalf("test key address = %s, want the EIP-712 example signer", key.EvmAddress.Hex())
+	}
+	result, err := r.signTypedData(context.Background(), []interface{}{key.EvmAddress, json.RawMessage(eip712Mail)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The signature of the EIP-712 example: r, s and v 28
+	want := "0x4355c47d63924e8a72e509b65029052eb6c299d53a04e167c5775fd466751c9d" +
+		"07299936d304c153f6443dfa05f40ff007d72911b6f72307f996231605b91562" + "1c"
+	if got := result.(hexutil.Bytes).String(); got != want {
+		t.Errorf("signTypedData() = %s, want %s", got, want)
+	}
+
+	var typedData apitypes.TypedData
+	if err := json.Unmarshal([]byte(eip712Mail), &typedData); err != nil {
+		t.Fatal(err)
+	}
+	hash, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hexutil.Encode(hash); got != "0xbe609aee343fb3c4b28e1df9e632fca64fcfaede20f02e86244efddf30957bd2" {
+		t.Errorf("typed data hash = %s, want the EIP-712 example hash", got)
+	}
+}
+
+func TestRollApp_signTransactions(t *testing.T) {
+	upstream := newSubmitUpstream(1)
+	defer upstream.Close()
+	elder := &fakeElder{batchSize: 1, rollAppBlock: "0x10"}
+	r, key := newSubmitTestRollApp(t, upstream.URL, elder)
+
+	// The transaction with nonce 1 is submitted to Elder, the rollApp pending nonce is still 1
+	if _, err := r.submitRawTransaction(context.Background(), signTestTx(t, key, 1, 1), nil); err != nil {
+		t.Fatal(err)
+	}
+	args := map[string]interface{}{
+		"from":     key.EvmAddress,
+		"to":       "0x00000000000000000000000000000000000000c1",
+		"gas":      "0x5208",
+		"gasPrice": "0x1",
+		"value":    "0x10",
+	}
+	signer := types.LatestSignerForChainID(common.Big1)
+
+	result, err := r.signTransaction(context.Background(), []interface{}{args})
+	if err != nil {
+		t.Fatalf("eth_signTransaction error = %v", err)
+	}
+	var signed types.Transaction
+	if err := signed.UnmarshalBinary(result.(*SignTransactionResult).Raw); err != nil {
+		t.Fatal(err)
+	}
+	if sender, err := types.Sender(signer, &signed); err != nil || sender != key.EvmAddress {
+		t.Errorf("eth_signTransaction signed by %s (%v), want %s", sender.Hex(), err, key.EvmAddress.Hex())
+	}
+	if signed.Nonce() != 2 {
+		t.Errorf("eth_signTransaction nonce = %d, want 2 after the transaction submitted to Elder", signed.Nonce())
+	}
+
+	txHash, err := waitFor(r.startSendTransaction)(context.Background(), []interface{}{args})
+	if err != nil {
+		t.Fatalf("eth_sendTransaction error = %v", err)
+	}
+	var sent types.Transaction
+	if err := sent.UnmarshalBinary(elder.msgs[len(elder.msgs)-1].TxData); err != nil {
+		t.Fatal(err)
+	}
+	if sent.Hash().Hex() != txHash {
+		t.Errorf("eth_sendTransaction = %s, submitted %s", txHash, sent.Hash().Hex())
+	}
+	if sender, err := types.Sender(signer, &sent); err != nil || sender != key.EvmAddress {
+		t.Errorf("eth_sendTransaction signed by %s (%v), want %s", sender.Hex(), err, key.EvmAddress.Hex())
+	}
+	if sent.Nonce() != 2 {
+		t.Errorf("eth_sendTransaction nonce = %d, want 2", sent.Nonce())
+	}
+
+	if err := r.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newFeeUpstream returns a rollApp RPC with a latest block of baseFee, none when nil, suggesting a
+// gas price of 5 and a tip of 100
+func newFeeUpstream(t *testing.T, baseFee *big.Int) *httptest.Server {
+	header, err := json.Marshal(&types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(0), BaseFee: baseFee})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var request JsonRPCRequest
+		json.NewDecoder(req.Body).Decode(&request)
+		id, _ := json.Marshal(request.ID)
+
+		switch request.Method {
+		case "eth_getBlockByNumber":
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":%s}`, id, header)
+		case "eth_gasPrice":
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":"0x5"}`, id)
+		case "eth_maxPriorityFeePerGas":
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":"0x64"}`, id)
+		default:
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":"0x1"}`, id)
+		}
+	}))
+}
+
+func TestRollApp_fillTransaction_fees(t *testing.T) {
+	fee := func(v int64) *hexutil.Big { return (*hexutil.Big)(big.NewInt(v)) }
+
+	tests := []struct {
+		name        string
+		baseFee     *big.Int
+		maxFee      *hexutil.Big
+		maxPriority *hexutil.Big
+		wantErr     bool
+		// wantFeeCap and wantTipCap are the gas price of legacy transactions
+		wantFeeCap int64
+		wantTipCap int64
+	}{
+		{name: "legacy gas price", wantFeeCap: 5, wantTipCap: 5},
+		{name: "fee cap without base fee", maxFee: fee(50), wantErr: true},
+		{name: "tip cap without base fee", maxPriority: fee(1), wantErr: true},
+		{name: "suggested fees", baseFee: big.NewInt(10), wantFeeCap: 120, wantTipCap: 100},
+		{name: "suggested tip capped at the fee cap", baseFee: big.NewInt(10), maxFee: fee(50), wantFeeCap: 50, wantTipCap: 50},
+		{name: "tip above the fee cap", baseFee: big.NewInt(10), maxFee: fee(50), maxPriority: fee(60), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFeeUpstream(t, tt.baseFee)
+			defer upstream.Close()
+			r, key := newSubmitTestRollApp(t, upstream.URL, &fakeElder{batchSize: 1})
+
+			gas := hexutil.Uint64(21000)
+			tx, _, err := r.fillTransaction(context.Background(), &TransactionArgs{
+				From:                 &key.EvmAddress,
+				Gas:                  &gas,
+				MaxFeePerGas:         tt.maxFee,
+				MaxPriorityFeePerGas: tt.maxPriority,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fillTransaction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tx.GasFeeCap().Int64() != tt.wantFeeCap || tx.GasTipCap().Int64() != tt.wantTipCap {
+				t.Errorf("fillTransaction() fee cap %s tip cap %s, want %d and %d", tx.GasFeeCap(), tx.GasTipCap(), tt.wantFeeCap, tt.wantTipCap)
+			}
+		})
+	}
+}
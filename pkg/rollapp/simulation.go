@@ -0,0 +1,196 @@
+package rollapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/0xElder/elder-wrap/pkg/metrics"
+	"github.com/0xElder/elder-wrap/pkg/tracing"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+)
+
+// panicSelector is the selector of the Panic(uint256) errors raised by failed Solidity assertions
+var panicSelector = []byte{0x4e, 0x48, 0x7b, 0x71}
+
+// loadErrorABIs reads the ABIs of the simulation config, from plain ABI files or from the abi of
+// Hardhat and Foundry artifacts
+func loadErrorABIs(cfg config.SimulationConfig) ([]abi.ABI, error) {
+	abis := make([]abi.ABI, 0, len(cfg.ABIFiles))
+	for _, path := range cfg.ABIFiles {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read abi file %s", path)
+		}
+
+		var artifact struct {
+			ABI json.RawMessage `json:"abi"`
+		}
+		if err := json.Unmarshal(content, &artifact); err == nil && len(artifact.ABI) > 0 {
+			content = artifact.ABI
+		}
+		contractABI, err := abi.JSON(bytes.NewReader(content))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse abi file %s", path)
+		}
+		abis = append(abis, contractABI)
+	}
+	return abis, nil
+}
+
+// simulate replays the transaction at the pending block of the rollApp before Elder fees are spent on it.
+// It fails when the sender can't pay for value and gas, when the gas limit is below the estimate or
+// when the transaction reverts, with the decoded revert reason. It is skipped while earlier transactions
+// of the sender are at Elder, the pending block does not hold their changes yet.
+func (r *RollApp) simulate(ctx context.Context, tx *types.Transaction, sender common.Address) (err error) {
+	ctx, span := tracing.Start(ctx, "Simulate", tracing.RollAppKey.String(r.Name), tracing.TxHashKey.String(tx.Hash().Hex()))
+	defer func() { tracing.End(span, err) }()
+
+	logger := r.logger.With("method", "simulate")
+	if r.submissions.inFlight(sender, tx.Nonce()) {
+		logger.Debug(ctx, "Skipping simulation, earlier transactions of the sender are not included yet", "txHash", tx.Hash().Hex(), "sender", sender.Hex())
+		return nil
+	}
+	// The balance, call and estimate read the state of the same rollApp RPC
+	ctx = withUpstreamPin(ctx)
+
+	var balance *big.Int
+	err = r.call(ctx, func(client *ethclient.Client) (err error) {
+		balance, err = client.PendingBalanceAt(ctx, sender)
+		return err
+	})
+	if err != nil {
+		logger.Error(ctx, "Failed to get sender balance", "sender", sender.Hex(), "error", err)
+		return errors.Wrap(err, "failed to get sender balance")
+	}
+	if balance.Cmp(tx.Cost()) < 0 {
+		logger.Warn(ctx, "Sender can't pay for transaction", "sender", sender.Hex(), "balance", balance, "cost", tx.Cost())
+		return r.simulationFailed(withCode(SimulationFailedCode, fmt.Errorf("insufficient funds for value and gas: balance %s, cost %s", balance, tx.Cost()), nil))
+	}
+
+	msg := ethereum.CallMsg{
+		From:       sender,
+		To:         tx.To(),
+		Gas:        tx.Gas(),
+		Value:      tx.Value(),
+		Data:       tx.Data(),
+		AccessList: tx.AccessList(),
+	}
+	if tx.Type() == types.LegacyTxType || tx.Type() == types.AccessListTxType {
+		msg.GasPrice = tx.GasPrice()
+	} else {
+		msg.GasFeeCap, msg.GasTipCap = tx.GasFeeCap(), tx.GasTipCap()
+	}
+
+	err = r.call(ctx, func(client *ethclient.Client) error {
+		_, err := client.PendingCallContract(ctx, msg)
+		return err
+	})
+	if err != nil {
+		return r.revertError(ctx, tx, err)
+	}
+
+	msg.Gas = 0
+	var estimate uint64
+	err = r.call(ctx, func(client *ethclient.Client) (err error) {
+		estimate, err = client.EstimateGas(ctx, msg)
+		return err
+	})
+	if err != nil {
+		return r.revertError(ctx, tx, err)
+	}
+	if tx.Gas() < estimate {
+		logger.Warn(ctx, "Gas limit below estimate", "txHash", tx.Hash().Hex(), "gas", tx.Gas(), "estimate", estimate)
+		return r.simulationFailed(withCode(SimulationFailedCode, fmt.Errorf("gas limit %d below estimate %d", tx.Gas(), estimate), map[string]hexutil.Uint64{
+			"gas":      hexutil.Uint64(tx.Gas()),
+			"estimate": hexutil.Uint64(estimate),
+		}))
+	}
+	return nil
+}
+
+// revertError converts the error of a call to the rollApp RPC. Errors answered by the rollApp RPC are
+// execution failures and returned with the decoded revert reason, the others are failures to reach it.
+func (r *RollApp) revertError(ctx context.Context, tx *types.Transaction, err error) error {
+	logger := r.logger.With("method", "revertError")
+
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		logger.Error(ctx, "Failed to simulate transaction", "txHash", tx.Hash().Hex(), "error", err)
+		return errors.Wrap(err, "failed to simulate transaction")
+	}
+
+	message := err.Error()
+	var data interface{}
+	var dataErr rpc.DataError
+	if errors.As(err, &dataErr) {
+		if encoded, ok := dataErr.ErrorData().(string); ok {
+			if revert, decodeErr := hexutil.Decode(encoded); decodeErr == nil && len(revert) > 0 {
+				revertData := map[string]string{"data": encoded}
+				if reason, ok := decodeRevert(revert, r.revertABIs()); ok {
+					revertData["reason"] = reason
+					message = "execution reverted: " + reason
+				}
+				data = revertData
+			}
+		}
+	}
+	logger.Warn(ctx, "Transaction would fail", "txHash", tx.Hash().Hex(), "error", message)
+	return r.simulationFailed(withCode(SimulationFailedCode, errors.New(message), data))
+}
+
+// simulationFailed counts a transaction rejected by the simulation
+func (r *RollApp) simulationFailed(err *RPCError) error {
+	metrics.SubmissionFailures.WithLabelValues(r.Name, metrics.ReasonSimulation).Inc()
+	return err
+}
+
+// revertABIs returns the ABIs decoding the custom errors of reverts
+func (r *RollApp) revertABIs() []abi.ABI {
+	r.settingsMu.RLock()
+	defer r.settingsMu.RUnlock()
+
+	return r.errorABIs
+}
+
+// decodeRevert decodes the revert data of Error(string), Panic(uint256) and the custom errors of abis
+func decodeRevert(data []byte, abis []abi.ABI) (string, bool) {
+	if len(data) < 4 {
+		return "", false
+	}
+	if reason, err := abi.UnpackRevert(data); err == nil {
+		if bytes.Equal(data[:4], panicSelector) {
+			return "panic: " + reason, true
+		}
+		return reason, true
+	}
+	for _, contractABI := range abis {
+		for _, abiErr := range contractABI.Errors {
+			if !bytes.Equal(data[:4], abiErr.ID[:4]) {
+				continue
+			}
+			args, err := abiErr.Unpack(data)
+			if err != nil {
+				continue
+			}
+			values := make([]string, 0, len(abiErr.Inputs))
+			for _, arg := range args.([]interface{}) {
+				values = append(values, fmt.Sprint(arg))
+			}
+			return fmt.Sprintf("%s(%s)", abiErr.Name, strings.Join(values, ", ")), true
+		}
+	}
+	return "", false
+}
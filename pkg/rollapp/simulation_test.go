@@ -0,0 +1,161 @@
+package rollapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/0xElder/elder-wrap/pkg/logging"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const testErrorABI = `[{"type":"error","name":"InsufficientAllowance","inputs":[{"name":"needed","type":"uint256"}]}]`
+
+// revertData encodes an error with its signature and arguments
+func revertData(t *testing.T, signature string, typ string, value interface{}) []byte {
+	t.Helper()
+	abiType, err := abi.NewType(typ, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, err := abi.Arguments{{Type: abiType}}.Pack(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(crypto.Keccak256([]byte(signature))[:4], packed...)
+}
+
+func TestDecodeRevert(t *testing.T) {
+	errorABI, err := abi.JSON(strings.NewReader(testErrorABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		want   string
+		wantOk bool
+	}{
+		{name: "error string", data: revertData(t, "Error(string)", "string", "not owner"), want: "not owner", wantOk: true},
+		{name: "panic", data: revertData(t, "Panic(uint256)", "uint256", big.NewInt(0x11)), want: "panic: arithmetic underflow or overflow", wantOk: true},
+		{name: "custom error", data: revertData(t, "InsufficientAllowance(uint256)", "uint256", big.NewInt(5)), want: "InsufficientAllowance(5)", wantOk: true},
+		{name: "unknown custom error", data: revertData(t, "Unauthorized(uint256)", "uint256", big.NewInt(5)), wantOk: false},
+		{name: "no data", data: nil, wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := decodeRevert(tt.data, []abi.ABI{errorABI})
+			if ok != tt.wantOk || got != tt.want {
+				t.Errorf("decodeRevert() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+// newSimulationUpstream answers eth_getBalance with balance, eth_estimateGas with estimate and
+// eth_call with revert when it is set
+func newSimulationUpstream(balance int64, estimate uint64, revert []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var request JsonRPCRequest
+		json.NewDecoder(req.Body).Decode(&request)
+		id, _ := json.Marshal(request.ID)
+
+		switch {
+		case request.Method == "eth_getBalance":
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":"0x%x"}`, id, balance)
+		case request.Method == "eth_call" && revert != nil:
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"error":{"code":3,"message":"execution reverted","data":"%s"}}`, id, hexutil.Encode(revert))
+		case request.Method == "eth_call":
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":"0x"}`, id)
+		case request.Method == "eth_estimateGas":
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":"0x%x"}`, id, estimate)
+		default:
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":"0x1"}`, id)
+		}
+	}))
+}
+
+func TestRollApp_simulate(t *testing.T) {
+	to := common.HexToAddress("0x00000000000000000000000000000000000000c1")
+	tx := types.NewTx(&types.LegacyTx{Nonce: 1, To: &to, Gas: 50000, GasPrice: big.NewInt(1), Value: big.NewInt(1000)})
+
+	tests := []struct {
+		name     string
+		balance  int64
+		estimate uint64
+		revert   []byte
+		// inFlight submits the previous transaction of the sender to Elder
+		inFlight   bool
+		wantErr    bool
+		wantReason string
+	}{
+		{name: "succeeds", balance: 100000, estimate: 40000, wantErr: false},
+		{name: "insufficient balance", balance: 50999, estimate: 40000, wantErr: true},
+		{name: "gas below estimate", balance: 100000, estimate: 60000, wantErr: true},
+		{name: "reverts", balance: 100000, estimate: 40000, revert: revertData(t, "Error(string)", "string", "paused"), wantErr: true, wantReason: "paused"},
+		{name: "skipped with a previous transaction in flight", balance: 50999, estimate: 40000, inFlight: true, wantErr: false},
+		{name: "reverts with custom error", balance: 100000, estimate: 40000, revert: revertData(t, "InsufficientAllowance(uint256)", "uint256", big.NewInt(7)), wantErr: true, wantReason: "InsufficientAllowance(7)"},
+	}
+
+	abiFile := filepath.Join(t.TempDir(), "Token.json")
+	if err := os.WriteFile(abiFile, []byte(`{"abi":`+testErrorABI+`}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newSimulationUpstream(tt.balance, tt.estimate, tt.revert)
+			defer upstream.Close()
+
+			cfg := &config.RollAppConfig{
+				RPC:                 upstream.URL,
+				UpstreamSelection:   config.UpstreamSelectionRoundRobin,
+				HealthCheckInterval: time.Hour,
+				MaxBlockLag:         config.DefaultMaxBlockLag,
+				ElderRegistrationId: 1,
+				SubmissionMode:      config.SubmissionModeSync,
+				Simulation:          config.SimulationConfig{Enabled: true, ABIFiles: []string{abiFile}},
+			}
+			r, err := NewRollApp("rollup1", cfg, config.TxPoolConfig{}, nil, logging.NewDevSlogger(nil), nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if tt.inFlight {
+				r.submissions.add(&Submission{TxHash: common.HexToHash("0x01"), Nonce: 0, ElderTxHash: "elder-0", Status: SubmissionPending})
+			}
+
+			err = r.simulate(context.Background(), tx, common.Address{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("simulate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				return
+			}
+			rpcErr := toRPCError(err)
+			if rpcErr.Code != SimulationFailedCode {
+				t.Errorf("simulate() error code = %d, want %d", rpcErr.Code, SimulationFailedCode)
+			}
+			if tt.wantReason != "" {
+				data, _ := rpcErr.Data.(map[string]string)
+				if data["reason"] != tt.wantReason {
+					t.Errorf("simulate() revert reason = %q, want %q", data["reason"], tt.wantReason)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package rollapp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/0xElder/elder-wrap/pkg/keystore"
+	"github.com/0xElder/elder-wrap/pkg/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+)
+
+// SponsorPolicy decides whether the sponsor key pays the Elder fees of a transaction. Allow is
+// called when a sponsored transaction is verified, fee is its estimated Elder fee. A policy with a
+// budget reserves the transaction in it and returns the reservation, which is settled with the fee
+// paid once the transaction is broadcast to Elder or released when it is not.
+type SponsorPolicy interface {
+	Allow(ctx context.Context, sender common.Address, tx *types.Transaction, fee uint64) (Reservation, error)
+}
+
+// sponsorPolicy is the SponsorPolicy of the sponsor_policy config: allowlists and a daily budget
+type sponsorPolicy struct {
+	cfg       config.SponsorPolicyConfig
+	senders   map[common.Address]bool
+	contracts map[common.Address]bool
+	now       func() time.Time
+
+	mu sync.Mutex
+	// day is the UTC day txs and fees are counted for
+	day  time.Time
+	txs  uint64
+	fees uint64
+}
+
+func newSponsorPolicy(cfg config.SponsorPolicyConfig) *sponsorPolicy {
+	p := &sponsorPolicy{
+		cfg:       cfg,
+		senders:   make(map[common.Address]bool, len(cfg.AllowedSenders)),
+		contracts: make(map[common.Address]bool, len(cfg.AllowedContracts)),
+		now:       time.Now,
+	}
+	for _, sender := range cfg.AllowedSenders {
+		p.senders[common.HexToAddress(sender)] = true
+	}
+	for _, contract := range cfg.AllowedContracts {
+		p.contracts[common.HexToAddress(contract)] = true
+	}
+	return p
+}
+
+// sponsorReservation is a transaction and its estimated Elder fee held in the budget of a sponsorPolicy
+type sponsorReservation struct {
+	policy *sponsorPolicy
+	day    time.Time
+	fee    uint64
+	done   bool
+}
+
+// Allow checks the allowlists and the daily budget, the transaction is reserved in the budget in the
+// same step so concurrent transactions can't get past it together
+func (p *sponsorPolicy) Allow(ctx context.Context, sender common.Address, tx *types.Transaction, fee uint64) (Reservation, error) {
+	if len(p.senders) > 0 || len(p.contracts) > 0 {
+		allowed := p.senders[sender]
+		if to := tx.To(); to != nil && p.contracts[*to] {
+			allowed = true
+		}
+		if !allowed {
+			return nil, errors.New("sender and contract are not allowed")
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rollDay()
+	if p.cfg.MaxDailyTxs > 0 && p.txs >= p.cfg.MaxDailyTxs {
+		return nil, fmt.Errorf("daily budget of %d transactions spent", p.cfg.MaxDailyTxs)
+	}
+	if p.cfg.MaxDailyElderFee > 0 && p.fees >= p.cfg.MaxDailyElderFee {
+		return nil, fmt.Errorf("daily budget of %d Elder fees spent", p.cfg.MaxDailyElderFee)
+	}
+	p.txs++
+	p.fees += fee
+	return &sponsorReservation{policy: p, day: p.day, fee: fee}, nil
+}
+
+// Settle counts the transaction with the Elder fee paid for it, on the current UTC day when it was
+// reserved on a previous one
+func (res *sponsorReservation) Settle(fee uint64) {
+	p := res.policy
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if res.done {
+		return
+	}
+	res.done = true
+	if p.rollDay() || !p.day.Equal(res.day) {
+		p.txs++
+		p.fees += fee
+		return
+	}
+	p.fees = p.fees - res.fee + fee
+}
+
+// Release gives back the transaction and its fee to the budget
+func (res *sponsorReservation) Release() {
+	p := res.policy
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if res.done {
+		return
+	}
+	res.done = true
+	if p.rollDay() || !p.day.Equal(res.day) {
+		return
+	}
+	p.txs--
+	p.fees -= res.fee
+}
+
+// rollDay resets the budget on a new UTC day and returns true, must be called with p.mu held
+func (p *sponsorPolicy) rollDay() bool {
+	if day := p.now().UTC().Truncate(24 * time.Hour); !day.Equal(p.day) {
+		p.day, p.txs, p.fees = day, 0, 0
+		return true
+	}
+	return false
+}
+
+// checkSponsorKey checks that the sponsor key of the rollApp config is in the keystore
+func checkSponsorKey(keyStore keystore.KeyStore, cfg *config.RollAppConfig) error {
+	if cfg.SponsorKey == "" {
+		return nil
+	}
+	if _, err := keyStore.Load(cfg.SponsorKey); err != nil {
+		return errors.Wrap(err, "failed to load sponsor key")
+	}
+	return nil
+}
+
+// SetSponsorPolicy replaces the policy of the sponsor key, to plug in a policy other than sponsor_policy
+func (r *RollApp) SetSponsorPolicy(policy SponsorPolicy) {
+	r.settingsMu.Lock()
+	defer r.settingsMu.Unlock()
+
+	r.sponsorPolicy = policy
+}
+
+// submitterKey returns the key submitting the transactions of sender to Elder: the sender's own key,
+// or the sponsor key when the rollApp has one. A sponsored key signs and pays on Elder with the
+// sponsor key while its EVM address stays the sender's, so the nonces and submissions are tracked
+// per sender.
+func (r *RollApp) submitterKey(ctx context.Context, sender common.Address) (*keystore.Key, bool, error) {
+	logger := r.logger.With("method", "submitterKey")
+
+	keys, err := r.keyStore.ListByEvmAddress()
+	if err != nil {
+		logger.Error(ctx, "Failed to list keys by EVM address", "error", err)
+		return nil, false, errors.Wrap(err, "failed to list keys by EVM address")
+	}
+	if key, ok := keys[sender]; ok {
+		return key, false, nil
+	}
+
+	sponsorKey := r.settings().SponsorKey
+	if sponsorKey == "" {
+		logger.Error(ctx, "Key not found in keystore", "address", sender.Hex())
+		return nil, false, ErrUnknownKey
+	}
+	sponsor, err := r.keyStore.Load(sponsorKey)
+	if err != nil {
+		logger.Error(ctx, "Failed to load sponsor key", "alias", sponsorKey, "error", err)
+		return nil, false, errors.Wrap(err, "failed to load sponsor key")
+	}
+	return &keystore.Key{
+		EvmAddress:   sender,
+		ElderAddress: sponsor.ElderAddress,
+		PrivateKey:   sponsor.PrivateKey,
+	}, true, nil
+}
+
+// sponsor checks that the sponsor policy allows paying for the transaction and returns the reservation
+// it made in the budget, nil when there is none
+func (r *RollApp) sponsor(ctx context.Context, sender common.Address, tx *types.Transaction) (Reservation, error) {
+	logger := r.logger.With("method", "sponsor")
+
+	r.settingsMu.RLock()
+	policy := r.sponsorPolicy
+	r.settingsMu.RUnlock()
+
+	if policy == nil {
+		return nil, nil
+	}
+	reservation, err := policy.Allow(ctx, sender, tx, r.elderClient.EstimateFee())
+	if err != nil {
+		logger.Warn(ctx, "Sponsor policy rejected transaction", "sender", sender.Hex(), "txHash", tx.Hash().Hex(), "error", err)
+		return nil, withCode(SponsorRejectedCode, errors.Wrap(err, "sponsor policy rejected transaction"), nil)
+	}
+	return reservation, nil
+}
+
+// spendSponsor counts a transaction broadcast to Elder when the sponsor key paid its Elder fee
+func (r *RollApp) spendSponsor(adm *admission) {
+	if adm != nil && adm.sponsored {
+		metrics.Sponsored.WithLabelValues(r.Name).Inc()
+	}
+}
@@ -0,0 +1,231 @@
+package rollapp
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestSponsorPolicy_Allow(t *testing.T) {
+	allowedSender := common.HexToAddress("0x00000000000000000000000000000000000000a1")
+	otherSender := common.HexToAddress("0x00000000000000000000000000000000000000a2")
+	allowedContract := common.HexToAddress("0x00000000000000000000000000000000000000c1")
+	otherContract := common.HexToAddress("0x00000000000000000000000000000000000000c2")
+
+	call := func(to *common.Address, gas uint64) *types.Transaction {
+		return types.NewTx(&types.LegacyTx{To: to, Gas: gas, GasPrice: big.NewInt(1)})
+	}
+
+	// fee is the Elder fee spent when the submission is allowed
+	type submission struct {
+		sender  common.Address
+		tx      *types.Transaction
+		fee     uint64
+		wantErr bool
+	}
+	tests := []struct {
+		name        string
+		cfg         config.SponsorPolicyConfig
+		submissions []submission
+	}{
+		{
+			name: "no allowlist",
+			cfg:  config.SponsorPolicyConfig{},
+			submissions: []submission{
+				{sender: otherSender, tx: call(&otherContract, 21000), wantErr: false},
+				{sender: otherSender, tx: call(nil, 21000), wantErr: false},
+			},
+		},
+		{
+			name: "allowlists",
+			cfg: config.SponsorPolicyConfig{
+				AllowedSenders:   []string{allowedSender.Hex()},
+				AllowedContracts: []string{allowedContract.Hex()},
+			},
+			submissions: []submission{
+				{sender: allowedSender, tx: call(&otherContract, 21000), wantErr: false},
+				{sender: otherSender, tx: call(&allowedContract, 21000), wantErr: false},
+				{sender: otherSender, tx: call(&otherContract, 21000), wantErr: true},
+				{sender: otherSender, tx: call(nil, 21000), wantErr: true},
+			},
+		},
+		{
+			name: "daily transactions",
+			cfg:  config.SponsorPolicyConfig{MaxDailyTxs: 2},
+			submissions: []submission{
+				{sender: otherSender, tx: call(&otherContract, 21000), wantErr: false},
+				{sender: otherSender, tx: call(&otherContract, 21000), wantErr: false},
+				{sender: otherSender, tx: call(&otherContract, 21000), wantErr: true},
+			},
+		},
+		{
+			name: "daily Elder fee",
+			cfg:  config.SponsorPolicyConfig{MaxDailyElderFee: 50000},
+			submissions: []submission{
+				{sender: otherSender, tx: call(&otherContract, 21000), fee: 30000, wantErr: false},
+				{sender: otherSender, tx: call(&otherContract, 21000), fee: 30000, wantErr: false},
+				{sender: otherSender, tx: call(&otherContract, 21000), fee: 30000, wantErr: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := newSponsorPolicy(tt.cfg)
+			for i, s := range tt.submissions {
+				res, err := policy.Allow(context.Background(), s.sender, s.tx, s.fee)
+				if (err != nil) != s.wantErr {
+					t.Errorf("Allow() submission %d error = %v, wantErr %v", i, err, s.wantErr)
+				}
+				if err == nil {
+					res.Settle(s.fee)
+				}
+			}
+		})
+	}
+}
+
+func TestSponsorPolicy_Allow_nextDay(t *testing.T) {
+	now := time.Date(2025, 1, 1, 23, 0, 0, 0, time.UTC)
+	policy := newSponsorPolicy(config.SponsorPolicyConfig{MaxDailyTxs: 1})
+	policy.now = func() time.Time { return now }
+	tx := types.NewTx(&types.LegacyTx{Gas: 21000, GasPrice: big.NewInt(1)})
+
+	res, err := policy.Allow(context.Background(), common.Address{}, tx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Settle(0)
+	if _, err := policy.Allow(context.Background(), common.Address{}, tx, 0); err == nil {
+		t.Fatal("Allow() allowed a transaction over the daily budget")
+	}
+	now = now.Add(2 * time.Hour)
+	if _, err := policy.Allow(context.Background(), common.Address{}, tx, 0); err != nil {
+		t.Errorf("Allow() on the next day error = %v", err)
+	}
+}
+
+func TestSponsorPolicy_Allow_concurrent(t *testing.T) {
+	policy := newSponsorPolicy(config.SponsorPolicyConfig{MaxDailyTxs: 3, MaxDailyElderFee: 500})
+	tx := types.NewTx(&types.LegacyTx{Gas: 21000, GasPrice: big.NewInt(1)})
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := policy.Allow(context.Background(), common.Address{}, tx, 100)
+			if err == nil {
+				allowed.Add(1)
+				res.Settle(100)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed.Load() != 3 {
+		t.Errorf("Allow() allowed %d concurrent transactions, want 3", allowed.Load())
+	}
+}
+
+func TestSponsorReservation(t *testing.T) {
+	policy := newSponsorPolicy(config.SponsorPolicyConfig{MaxDailyTxs: 1, MaxDailyElderFee: 1000})
+	tx := types.NewTx(&types.LegacyTx{Gas: 21000, GasPrice: big.NewInt(1)})
+
+	res, err := policy.Allow(context.Background(), common.Address{}, tx, 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := policy.Allow(context.Background(), common.Address{}, tx, 400); err == nil {
+		t.Fatal("Allow() allowed a transaction over the reserved budget")
+	}
+	res.Release()
+	res.Release()
+	if policy.txs != 0 || policy.fees != 0 {
+		t.Errorf("released budget counts %d txs and %d fees, want 0", policy.txs, policy.fees)
+	}
+
+	res, err = policy.Allow(context.Background(), common.Address{}, tx, 400)
+	if err != nil {
+		t.Fatalf("Allow() after Release() error = %v", err)
+	}
+	res.Settle(650)
+	if policy.txs != 1 || policy.fees != 650 {
+		t.Errorf("settled budget counts %d txs and %d fees, want 1 and 650", policy.txs, policy.fees)
+	}
+}
+
+func TestRollApp_sponsorBudget(t *testing.T) {
+	upstream := newSubmitUpstream(1)
+	defer upstream.Close()
+	elder := &fakeElder{batchSize: 1, rollAppBlock: "0x10", fee: 700}
+	r, key := newSubmitTestRollApp(t, upstream.URL, elder)
+	r.cfg.SponsorKey = "alice"
+	policy := newSponsorPolicy(config.SponsorPolicyConfig{MaxDailyTxs: 10, MaxDailyElderFee: 700})
+	r.sponsorPolicy = policy
+
+	// The sender has no key in the keystore, alice pays its Elder fees
+	senderKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sponsoredTx := func(nonce uint64) string {
+		tx, err := types.SignNewTx(senderKey, types.LatestSignerForChainID(big.NewInt(1)), &types.DynamicFeeTx{
+			ChainID:   big.NewInt(1),
+			Nonce:     nonce,
+			GasTipCap: big.NewInt(1),
+			GasFeeCap: big.NewInt(1),
+			Gas:       21000,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := tx.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hexutil.Encode(raw)
+	}
+
+	steps := []struct {
+		name         string
+		rawTx        string
+		broadcastErr error
+		wantErr      bool
+		wantTxs      uint64
+		wantFees     uint64
+	}{
+		{name: "nonce too low", rawTx: sponsoredTx(0), wantErr: true},
+		{name: "broadcast failure", rawTx: sponsoredTx(1), broadcastErr: errors.New("elder unavailable"), wantErr: true},
+		{name: "own key", rawTx: signTestTx(t, key, 1, 1)},
+		{name: "queued without reservation", rawTx: sponsoredTx(3)},
+		{name: "broadcast", rawTx: sponsoredTx(1), wantTxs: 1, wantFees: 700},
+		{name: "budget spent", rawTx: sponsoredTx(2), wantErr: true, wantTxs: 1, wantFees: 700},
+	}
+
+	for _, step := range steps {
+		elder.err = step.broadcastErr
+		if _, err := r.submitRawTransaction(context.Background(), step.rawTx, nil); (err != nil) != step.wantErr {
+			t.Errorf("%s: submitRawTransaction() error = %v, wantErr %v", step.name, err, step.wantErr)
+		}
+		policy.mu.Lock()
+		txs, fees := policy.txs, policy.fees
+		policy.mu.Unlock()
+		if txs != step.wantTxs || fees != step.wantFees {
+			t.Errorf("%s: sponsor budget spent %d txs and %d fees, want %d and %d", step.name, txs, fees, step.wantTxs, step.wantFees)
+		}
+	}
+	if err := r.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,531 @@
+package rollapp
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/journal"
+	"github.com/0xElder/elder-wrap/pkg/logging"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// submissionRetention is how long finished submissions are kept for status queries
+const submissionRetention = time.Hour
+
+var (
+	// reconcileExpiry is how long after its last update the Elder transaction of a previous run may still
+	// be included, the rollApp transaction is submitted again only after it
+	reconcileExpiry = 2 * time.Minute
+	// reconcileInterval is the interval of the inclusion checks while waiting for reconcileExpiry
+	reconcileInterval = 5 * time.Second
+	// rollAppBlockLookupTimeout is how long elder_getRollAppBlock waits for the Elder lookup, the lookup
+	// goes on in the background after it and its result is kept for the next calls
+	rollAppBlockLookupTimeout = 5 * time.Second
+)
+
+type SubmissionStatus string
+
+const (
+	// SubmissionQueued is a transaction waiting in the tx pool for a nonce gap to be filled
+	SubmissionQueued SubmissionStatus = "queued"
+	// SubmissionSubmitting is a transaction being broadcast to Elder, it is journaled before the broadcast
+	SubmissionSubmitting SubmissionStatus = "submitting"
+	// SubmissionPending is a transaction broadcast to Elder and waiting for rollApp block inclusion
+	SubmissionPending SubmissionStatus = "pending"
+	// SubmissionIncluded is a transaction included in a rollApp block
+	SubmissionIncluded SubmissionStatus = "included"
+	// SubmissionFailed is a transaction which could not be included
+	SubmissionFailed SubmissionStatus = "failed"
+)
+
+// finished returns whether the submission reached its final status
+func (s SubmissionStatus) finished() bool {
+	return s == SubmissionIncluded || s == SubmissionFailed
+}
+
+// submitted returns whether the submission is at Elder, or being broadcast to it, and not included yet
+func (s SubmissionStatus) submitted() bool {
+	return s == SubmissionSubmitting || s == SubmissionPending
+}
+
+// Submission tracks a rollApp transaction submitted through Elder
+type Submission struct {
+	TxHash       common.Hash      `json:"txHash"`
+	Sender       common.Address   `json:"sender"`
+	Nonce        hexutil.Uint64   `json:"nonce"`
+	ElderTxHash  string           `json:"elderTxHash,omitempty"`
+	RollAppBlock string           `json:"rollAppBlock,omitempty"`
+	Status       SubmissionStatus `json:"status"`
+	Error        string           `json:"error,omitempty"`
+	SubmittedAt  time.Time        `json:"submittedAt"`
+	UpdatedAt    time.Time        `json:"updatedAt"`
+	// RawTx is kept in the journal to resubmit the transaction after a restart
+	RawTx hexutil.Bytes `json:"-"`
+}
+
+// journalEntry is the journal record of a submission
+type journalEntry struct {
+	*Submission
+	RawTx hexutil.Bytes `json:"rawTx"`
+}
+
+// submissions is an in-memory index of submissions by rollApp transaction hash,
+// written through to the journal when one is configured
+type submissions struct {
+	mu     sync.RWMutex
+	byHash map[common.Hash]*Submission
+	// lookups are the submissions whose rollApp block is being looked up on Elder, their channel is
+	// closed once the lookup ends
+	lookups map[common.Hash]chan struct{}
+	journal *journal.Journal
+	rollApp string
+	// pendingGauge counts the submissions at Elder or being broadcast to it
+	pendingGauge prometheus.Gauge
+	logger       logging.Logger
+}
+
+func newSubmissions(journal *journal.Journal, rollApp string, pendingGauge prometheus.Gauge, logger logging.Logger) *submissions {
+	return &submissions{
+		byHash:       make(map[common.Hash]*Submission),
+		lookups:      make(map[common.Hash]chan struct{}),
+		journal:      journal,
+		rollApp:      rollApp,
+		pendingGauge: pendingGauge,
+		logger:       logger,
+	}
+}
+
+func (s *submissions) add(submission *Submission) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune()
+	s.byHash[submission.TxHash] = submission
+	s.write(submission)
+	s.changed()
+}
+
+func (s *submissions) setPending(txHash common.Hash, elderTxHash string) {
+	s.update(txHash, func(submission *Submission) {
+		submission.Status = SubmissionPending
+		submission.ElderTxHash = elderTxHash
+	})
+}
+
+func (s *submissions) setIncluded(txHash common.Hash, rollAppBlock string) {
+	s.update(txHash, func(submission *Submission) {
+		submission.Status = SubmissionIncluded
+		submission.RollAppBlock = rollAppBlock
+	})
+}
+
+func (s *submissions) setFailed(txHash common.Hash, err error) {
+	s.update(txHash, func(submission *Submission) {
+		submission.Status = SubmissionFailed
+		submission.Error = err.Error()
+	})
+}
+
+func (s *submissions) update(txHash common.Hash, fn func(*Submission)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	submission, ok := s.byHash[txHash]
+	if !ok {
+		return
+	}
+	fn(submission)
+	submission.UpdatedAt = time.Now()
+	s.write(submission)
+	s.changed()
+}
+
+// startLookup returns the channel closed once the rollApp block lookup of txHash ends. started is true
+// when no lookup was running, the caller runs it and calls endLookup.
+func (s *submissions) startLookup(txHash common.Hash) (done chan struct{}, started bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if done, ok := s.lookups[txHash]; ok {
+		return done, false
+	}
+	done = make(chan struct{})
+	s.lookups[txHash] = done
+	return done, true
+}
+
+func (s *submissions) endLookup(txHash common.Hash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if done, ok := s.lookups[txHash]; ok {
+		close(done)
+		delete(s.lookups, txHash)
+	}
+}
+
+// get returns a copy of the submission of a rollApp transaction
+func (s *submissions) get(txHash common.Hash) (*Submission, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	submission, ok := s.byHash[txHash]
+	if !ok {
+		return nil, false
+	}
+	result := *submission
+	return &result, true
+}
+
+// pending returns copies of the unfinished submissions of sender, sorted by nonce
+func (s *submissions) pending(sender common.Address) []*Submission {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*Submission, 0)
+	for _, submission := range s.byHash {
+		if submission.Sender != sender {
+			continue
+		}
+		if !submission.Status.finished() {
+			copied := *submission
+			result = append(result, &copied)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Nonce < result[j].Nonce })
+	return result
+}
+
+// inFlight returns whether sender has submissions below nonce at Elder, or being broadcast to it, which
+// are not included yet
+func (s *submissions) inFlight(sender common.Address, nonce uint64) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, submission := range s.byHash {
+		if submission.Sender == sender && uint64(submission.Nonce) < nonce && submission.Status.submitted() {
+			return true
+		}
+	}
+	return false
+}
+
+// prune drops finished submissions older than submissionRetention, must be called with s.mu held
+func (s *submissions) prune() {
+	for hash, submission := range s.byHash {
+		if submission.Status.finished() && time.Since(submission.UpdatedAt) > submissionRetention {
+			delete(s.byHash, hash)
+			if s.journal != nil {
+				if err := s.journal.Delete(s.rollApp, hash.Bytes()); err != nil {
+					s.logger.Error(nil, "Failed to delete journal entry", "txHash", hash.Hex(), "error", err)
+				}
+			}
+		}
+	}
+}
+
+// changed updates the pending gauge, must be called with s.mu held
+func (s *submissions) changed() {
+	size := 0
+	for _, submission := range s.byHash {
+		if submission.Status.submitted() {
+			size++
+		}
+	}
+	s.pendingGauge.Set(float64(size))
+}
+
+// write records the submission in the journal, must be called with s.mu held
+func (s *submissions) write(submission *Submission) {
+	if s.journal == nil {
+		return
+	}
+
+	value, err := json.Marshal(journalEntry{Submission: submission, RawTx: submission.RawTx})
+	if err == nil {
+		err = s.journal.Put(s.rollApp, submission.TxHash.Bytes(), value)
+	}
+	if err != nil {
+		s.logger.Error(nil, "Failed to write journal entry", "txHash", submission.TxHash.Hex(), "error", err)
+	}
+}
+
+// load reads the journal into memory and returns copies of the submissions which were not finished,
+// sorted by sender and nonce
+func (s *submissions) load() ([]*Submission, error) {
+	if s.journal == nil {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var unfinished []*Submission
+	err := s.journal.ForEach(s.rollApp, func(key, value []byte) error {
+		var entry journalEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return errors.Wrapf(err, "failed to decode journal entry %x", key)
+		}
+		if entry.Submission == nil {
+			return nil
+		}
+		entry.Submission.RawTx = entry.RawTx
+		s.byHash[entry.TxHash] = entry.Submission
+
+		if !entry.Status.finished() {
+			submission := *entry.Submission
+			unfinished = append(unfinished, &submission)
+		}
+		return nil
+	})
+	s.changed()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(unfinished, func(i, j int) bool {
+		if unfinished[i].Sender != unfinished[j].Sender {
+			return unfinished[i].Sender.Cmp(unfinished[j].Sender) < 0
+		}
+		return unfinished[i].Nonce < unfinished[j].Nonce
+	})
+	return unfinished, nil
+}
+
+// getSubmission serves elder_getSubmission, params are [txHash]
+func (r *RollApp) getSubmission(ctx context.Context, params []interface{}) (interface{}, error) {
+	var txHash common.Hash
+	if err := decodeParam(params, 0, &txHash); err != nil {
+		return nil, errors.Wrap(err, "invalid transaction hash")
+	}
+
+	submission, ok := r.submissions.get(txHash)
+	if !ok {
+		// Unknown transactions are reported as null, like eth_getTransactionReceipt does
+		return nil, nil
+	}
+	return submission, nil
+}
+
+// getElderTxHash serves elder_getElderTxHash, params are [txHash]
+func (r *RollApp) getElderTxHash(ctx context.Context, params []interface{}) (interface{}, error) {
+	var txHash common.Hash
+	if err := decodeParam(params, 0, &txHash); err != nil {
+		return nil, errors.Wrap(err, "invalid transaction hash")
+	}
+
+	submission, ok := r.submissions.get(txHash)
+	if !ok || submission.ElderTxHash == "" {
+		return nil, nil
+	}
+	return submission.ElderTxHash, nil
+}
+
+// getRollAppBlock serves elder_getRollAppBlock, params are [txHash]. It answers from the submissions,
+// the rollApp block of a submission not known to be included is looked up on Elder for at most
+// rollAppBlockLookupTimeout. Concurrent calls share the lookup.
+func (r *RollApp) getRollAppBlock(ctx context.Context, params []interface{}) (interface{}, error) {
+	var txHash common.Hash
+	if err := decodeParam(params, 0, &txHash); err != nil {
+		return nil, errors.Wrap(err, "invalid transaction hash")
+	}
+
+	submission, ok := r.submissions.get(txHash)
+	if !ok || submission.ElderTxHash == "" {
+		return nil, nil
+	}
+	if submission.RollAppBlock != "" {
+		return submission.RollAppBlock, nil
+	}
+
+	done, started := r.submissions.startLookup(txHash)
+	if started {
+		if !r.track() {
+			r.submissions.endLookup(txHash)
+			return nil, nil
+		}
+		go func() {
+			defer r.inflight.Done()
+			defer r.submissions.endLookup(txHash)
+			r.lookupRollAppBlock(context.WithoutCancel(ctx), txHash, submission.ElderTxHash)
+		}()
+	}
+
+	timer := time.NewTimer(rollAppBlockLookupTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	// The transaction is not included yet when the lookup did not record its rollApp block
+	submission, ok = r.submissions.get(txHash)
+	if !ok || submission.RollAppBlock == "" {
+		return nil, nil
+	}
+	return submission.RollAppBlock, nil
+}
+
+// lookupRollAppBlock records the rollApp block of the Elder transaction once it is included
+func (r *RollApp) lookupRollAppBlock(ctx context.Context, txHash common.Hash, elderTxHash string) {
+	logger := r.logger.With("method", "lookupRollAppBlock")
+
+	rollAppBlock, err := r.elderClient.RollAppBlock(ctx, elderTxHash)
+	if err != nil {
+		logger.Warn(ctx, "Failed to fetch elder transaction", "txHash", txHash.Hex(), "elderTxHash", elderTxHash, "error", err)
+		return
+	}
+	if rollAppBlock == "" {
+		return
+	}
+	// A submission failed by an inclusion timeout may still be included afterwards
+	r.submissions.setIncluded(txHash, rollAppBlock)
+}
+
+// pendingSubmissions serves elder_pendingSubmissions, params are [address]
+func (r *RollApp) pendingSubmissions(ctx context.Context, params []interface{}) (interface{}, error) {
+	var address common.Address
+	if err := decodeParam(params, 0, &address); err != nil {
+		return nil, errors.Wrap(err, "invalid address")
+	}
+	return r.submissions.pending(address), nil
+}
+
+// reconcile settles the submissions left unfinished by a previous run: transactions found on Elder or the
+// rollApp are marked included, transactions whose nonce was used by another transaction are marked failed
+// and the others are submitted again. Transactions which may have reached Elder are submitted again only
+// once their Elder transaction expired, so their fees are not paid twice.
+func (r *RollApp) reconcile(ctx context.Context, unfinished []*Submission) {
+	logger := r.logger.With("method", "reconcile")
+	logger.Info(ctx, "Reconciling unfinished submissions", "count", len(unfinished))
+
+	for _, submission := range unfinished {
+		err := r.reconcileSubmission(ctx, submission)
+		if errors.Is(err, ErrShuttingDown) {
+			logger.Info(ctx, "Stopped reconciling, the submissions left are reconciled on restart", "txHash", submission.TxHash.Hex())
+			return
+		}
+		if err != nil {
+			logger.Error(ctx, "Failed to reconcile submission", "txHash", submission.TxHash.Hex(), "error", err)
+			r.submissions.setFailed(submission.TxHash, errors.Wrap(err, "failed to reconcile after restart"))
+			if submission.Status != SubmissionQueued {
+				r.txPool.release(submission.Sender, uint64(submission.Nonce))
+			}
+		}
+	}
+}
+
+// reserveUnfinished reserves the nonces of the unfinished submissions which may have reached Elder, so they
+// are not reused while reconcile waits for their Elder transactions. unfinished is sorted by sender and nonce.
+func (r *RollApp) reserveUnfinished(unfinished []*Submission) {
+	for _, submission := range unfinished {
+		if submission.Status != SubmissionQueued {
+			r.txPool.reserve(submission.Sender, uint64(submission.Nonce))
+		}
+	}
+}
+
+func (r *RollApp) reconcileSubmission(ctx context.Context, submission *Submission) error {
+	logger := r.logger.With("method", "reconcileSubmission")
+
+	var tx types.Transaction
+	if err := tx.UnmarshalBinary(submission.RawTx); err != nil {
+		return errors.Wrap(err, "failed to decode journaled transaction")
+	}
+
+	if submission.Status == SubmissionQueued && r.txPool.contains(submission.Sender, submission.TxHash) {
+		logger.Debug(ctx, "Transaction already restored in the tx pool", "txHash", submission.TxHash.Hex())
+		return nil
+	}
+
+	// A submitting transaction may have been broadcast without its Elder tx hash being journaled
+	expiry := submission.UpdatedAt.Add(reconcileExpiry)
+	for {
+		settled, err := r.settleSubmission(ctx, submission, &tx)
+		if err != nil || settled {
+			return err
+		}
+		if submission.Status == SubmissionQueued || !time.Now().Before(expiry) {
+			break
+		}
+
+		logger.Debug(ctx, "Waiting for the elder transaction of the previous run", "txHash", submission.TxHash.Hex(), "elderTxHash", submission.ElderTxHash, "expiry", expiry)
+		select {
+		case <-time.After(reconcileInterval):
+		case <-r.quit:
+			return ErrShuttingDown
+		}
+		if r.isDraining() {
+			return ErrShuttingDown
+		}
+	}
+
+	key, sponsored, err := r.submitterKey(ctx, submission.Sender)
+	if err != nil {
+		return err
+	}
+
+	logger.Info(ctx, "Resubmitting transaction", "txHash", submission.TxHash.Hex(), "nonce", tx.Nonce())
+	unlock := r.txPool.lockSender(submission.Sender)
+	if submission.Status != SubmissionQueued {
+		r.txPool.release(submission.Sender, tx.Nonce())
+	}
+	wait, queued, err := r.admitTransaction(ctx, &tx, key, &admission{sponsored: sponsored}, unlock)
+	if err != nil || queued {
+		return err
+	}
+	elderTxHash, err := wait()
+	if err != nil {
+		return err
+	}
+	r.background(func() { r.confirmInclusion(ctx, key.EvmAddress, tx.Hash(), elderTxHash) })
+	return nil
+}
+
+// settleSubmission marks the submission included when its transaction is found on Elder or the rollApp and
+// failed when its nonce was used by another transaction. It returns false when the submission is unsettled.
+func (r *RollApp) settleSubmission(ctx context.Context, submission *Submission, tx *types.Transaction) (bool, error) {
+	logger := r.logger.With("method", "settleSubmission")
+
+	if submission.ElderTxHash != "" {
+		rollAppBlock, err := r.elderClient.RollAppBlock(ctx, submission.ElderTxHash)
+		if err == nil && rollAppBlock != "" {
+			logger.Info(ctx, "Transaction was included", "txHash", submission.TxHash.Hex(), "elderTxHash", submission.ElderTxHash, "rollAppBlock", rollAppBlock)
+			r.submissions.setIncluded(submission.TxHash, rollAppBlock)
+			return true, nil
+		}
+	}
+
+	var receipt *types.Receipt
+	err := r.call(ctx, func(client *ethclient.Client) (err error) {
+		receipt, err = client.TransactionReceipt(ctx, submission.TxHash)
+		return err
+	})
+	if err == nil {
+		logger.Info(ctx, "Transaction found on the rollApp", "txHash", submission.TxHash.Hex(), "block", receipt.BlockNumber)
+		r.submissions.setIncluded(submission.TxHash, receipt.BlockNumber.String())
+		return true, nil
+	}
+
+	rpcNonce, err := r.GetAddressNonce(ctx, submission.Sender.Hex())
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get address nonce")
+	}
+	if rpcNonce > tx.Nonce() {
+		logger.Warn(ctx, "Transaction nonce was used by another transaction", "txHash", submission.TxHash.Hex(), "nonce", tx.Nonce())
+		r.submissions.setFailed(submission.TxHash, errors.New("nonce used by another transaction"))
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,386 @@
+package rollapp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/journal"
+	"github.com/0xElder/elder-wrap/pkg/logging"
+	"github.com/0xElder/elder-wrap/pkg/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// openTestJournal returns a journal in a temporary dir, closed at the end of the test
+func openTestJournal(t *testing.T) *journal.Journal {
+	t.Helper()
+	j, err := journal.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	return j
+}
+
+// journaled returns the submission of txHash read back from the journal
+func journaled(t *testing.T, j *journal.Journal, txHash common.Hash) *Submission {
+	t.Helper()
+	s := newSubmissions(j, "rollup1", metrics.TxPoolPending.WithLabelValues("test"), logging.NewDevSlogger(nil))
+	if _, err := s.load(); err != nil {
+		t.Fatal(err)
+	}
+	submission, ok := s.get(txHash)
+	if !ok {
+		t.Fatalf("transaction %s is not journaled", txHash.Hex())
+	}
+	return submission
+}
+
+// waitForStatus waits until the submission of txHash has status
+func waitForStatus(t *testing.T, r *RollApp, txHash common.Hash, status SubmissionStatus) *Submission {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		submission, ok := r.submissions.get(txHash)
+		if ok && submission.Status == status {
+			return submission
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("submission of %s = %+v, want status %s", txHash.Hex(), submission, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func decodeTestTx(t *testing.T, rawTx string) *types.Transaction {
+	t.Helper()
+	var tx types.Transaction
+	if err := tx.UnmarshalBinary(hexutil.MustDecode(rawTx)); err != nil {
+		t.Fatal(err)
+	}
+	return &tx
+}
+
+func TestRollApp_broadcastTransaction_journal(t *testing.T) {
+	t.Run("journaled before the broadcast", func(t *testing.T) {
+		upstream := newSubmitUpstream(0)
+		defer upstream.Close()
+		elder := &fakeElder{batchSize: 2, rollAppBlock: "0x10"}
+		r, key := newSubmitTestRollApp(t, upstream.URL, elder)
+		j := openTestJournal(t)
+		r.submissions = newSubmissions(j, r.Name, metrics.TxPoolPending.WithLabelValues("test"), logging.NewDevSlogger(nil))
+
+		first := signTestTx(t, key, 0, 1)
+		done := make(chan error, 1)
+		go func() {
+			_, err := r.submitRawTransaction(context.Background(), first, nil)
+			done <- err
+		}()
+		for elder.enqueued() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		// The Elder batch waits for a second transaction, a crash now finds the first one in the journal
+		firstHash := decodeTestTx(t, first).Hash()
+		if submission := journaled(t, j, firstHash); submission.Status != SubmissionSubmitting {
+			t.Errorf("journaled status while broadcasting = %s, want %s", submission.Status, SubmissionSubmitting)
+		}
+
+		if _, err := r.submitRawTransaction(context.Background(), signTestTx(t, key, 1, 1), nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Drain(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if submission := journaled(t, j, firstHash); submission.Status != SubmissionIncluded || submission.ElderTxHash != "elder-0" {
+			t.Errorf("journaled submission = %+v, want included by elder-0", submission)
+		}
+	})
+
+	t.Run("broadcast failure", func(t *testing.T) {
+		upstream := newSubmitUpstream(0)
+		defer upstream.Close()
+		r, key := newSubmitTestRollApp(t, upstream.URL, &fakeElder{batchSize: 1, err: errors.New("elder unavailable")})
+		j := openTestJournal(t)
+		r.submissions = newSubmissions(j, r.Name, metrics.TxPoolPending.WithLabelValues("test"), logging.NewDevSlogger(nil))
+
+		rawTx := signTestTx(t, key, 0, 1)
+		if _, err := r.submitRawTransaction(context.Background(), rawTx, nil); err == nil {
+			t.Fatal("submitRawTransaction() error = nil, want the broadcast failure")
+		}
+		submission := journaled(t, j, decodeTestTx(t, rawTx).Hash())
+		if submission.Status != SubmissionFailed || submission.Error == "" {
+			t.Errorf("journaled submission = %+v, want failed with the broadcast error", submission)
+		}
+	})
+}
+
+func TestRollApp_reconcile(t *testing.T) {
+	expiry, interval := reconcileExpiry, reconcileInterval
+	reconcileExpiry, reconcileInterval = 500*time.Millisecond, 10*time.Millisecond
+	defer func() { reconcileExpiry, reconcileInterval = expiry, interval }()
+
+	tests := []struct {
+		name        string
+		status      SubmissionStatus
+		elderTxHash string
+		updatedAt   time.Duration
+		rpcNonce    uint64
+		// wantWait is whether the transaction waits for the expiry of the previous Elder transaction
+		wantWait      bool
+		wantStatus    SubmissionStatus
+		wantEnqueued  int
+		wantElderHash string
+	}{
+		{
+			name:          "included on Elder",
+			status:        SubmissionPending,
+			elderTxHash:   "elder-previous",
+			rpcNonce:      1,
+			wantStatus:    SubmissionIncluded,
+			wantElderHash: "elder-previous",
+		},
+		{
+			name:          "nonce used by another transaction",
+			status:        SubmissionPending,
+			elderTxHash:   "0xprevious",
+			rpcNonce:      2,
+			wantStatus:    SubmissionFailed,
+			wantElderHash: "0xprevious",
+		},
+		{
+			name:          "expired Elder transaction is resubmitted",
+			status:        SubmissionPending,
+			elderTxHash:   "0xprevious",
+			updatedAt:     -time.Second,
+			rpcNonce:      1,
+			wantStatus:    SubmissionIncluded,
+			wantEnqueued:  1,
+			wantElderHash: "elder-0",
+		},
+		{
+			name:          "broadcast interrupted by a crash waits for the expiry",
+			status:        SubmissionSubmitting,
+			rpcNonce:      1,
+			wantWait:      true,
+			wantStatus:    SubmissionIncluded,
+			wantEnqueued:  1,
+			wantElderHash: "elder-0",
+		},
+		{
+			name:          "pending Elder transaction waits for the expiry",
+			status:        SubmissionPending,
+			elderTxHash:   "0xprevious",
+			rpcNonce:      1,
+			wantWait:      true,
+			wantStatus:    SubmissionIncluded,
+			wantEnqueued:  1,
+			wantElderHash: "elder-0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newSubmitUpstream(tt.rpcNonce)
+			defer upstream.Close()
+			elder := &fakeElder{batchSize: 1, rollAppBlock: "0x10"}
+			r, key := newSubmitTestRollApp(t, upstream.URL, elder)
+			j := openTestJournal(t)
+
+			// The previous run journaled the transaction with nonce 1
+			rawTx := signTestTx(t, key, 1, 1)
+			tx := decodeTestTx(t, rawTx)
+			updatedAt := time.Now().Add(tt.updatedAt)
+			newSubmissions(j, r.Name, metrics.TxPoolPending.WithLabelValues("test"), logging.NewDevSlogger(nil)).add(&Submission{
+				TxHash:      tx.Hash(),
+				Sender:      key.EvmAddress,
+				Nonce:       1,
+				ElderTxHash: tt.elderTxHash,
+				Status:      tt.status,
+				RawTx:       hexutil.MustDecode(rawTx),
+				SubmittedAt: updatedAt,
+				UpdatedAt:   updatedAt,
+			})
+
+			r.submissions = newSubmissions(j, r.Name, metrics.TxPoolPending.WithLabelValues("test"), logging.NewDevSlogger(nil))
+			start := time.Now()
+			if err := r.restoreSubmissions(); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantWait {
+				// The nonce stays reserved for the Elder transaction of the previous run
+				_, err := r.submitRawTransaction(context.Background(), signTestTx(t, key, 1, 2), nil)
+				if !errors.Is(err, ErrReplacementSubmitted) {
+					t.Errorf("replacement while reconciling error = %v, want %v", err, ErrReplacementSubmitted)
+				}
+			}
+
+			submission := waitForStatus(t, r, tx.Hash(), tt.wantStatus)
+			if tt.wantWait && time.Since(start) < reconcileExpiry/2 {
+				t.Errorf("reconciled after %s, want a wait for the expiry", time.Since(start))
+			}
+			if err := r.Drain(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if got := elder.enqueued(); got != tt.wantEnqueued {
+				t.Errorf("enqueued = %d, want %d", got, tt.wantEnqueued)
+			}
+			if submission.ElderTxHash != tt.wantElderHash {
+				t.Errorf("elderTxHash = %s, want %s", submission.ElderTxHash, tt.wantElderHash)
+			}
+		})
+	}
+}
+
+func TestRollApp_submissionMethods(t *testing.T) {
+	upstream := newSubmitUpstream(0)
+	defer upstream.Close()
+	elder := &fakeElder{batchSize: 1, rollAppBlock: "0x10", release: make(chan struct{})}
+	r, key := newSubmitTestRollApp(t, upstream.URL, elder)
+	defer func(timeout time.Duration) { rollAppBlockLookupTimeout = timeout }(rollAppBlockLookupTimeout)
+	rollAppBlockLookupTimeout = 20 * time.Millisecond
+
+	now := time.Now()
+	included := &Submission{TxHash: common.HexToHash("0x01"), Sender: key.EvmAddress, Nonce: 0, ElderTxHash: "elder-0", RollAppBlock: "0x0f", Status: SubmissionIncluded, SubmittedAt: now, UpdatedAt: now}
+	pending := &Submission{TxHash: common.HexToHash("0x02"), Sender: key.EvmAddress, Nonce: 2, ElderTxHash: "elder-1", Status: SubmissionPending, SubmittedAt: now, UpdatedAt: now}
+	queued := &Submission{TxHash: common.HexToHash("0x03"), Sender: key.EvmAddress, Nonce: 4, Status: SubmissionQueued, SubmittedAt: now, UpdatedAt: now}
+	submitting := &Submission{TxHash: common.HexToHash("0x04"), Sender: key.EvmAddress, Nonce: 3, Status: SubmissionSubmitting, SubmittedAt: now, UpdatedAt: now}
+	for _, submission := range []*Submission{included, pending, queued, submitting} {
+		r.submissions.add(submission)
+	}
+	unknown := common.HexToHash("0x05")
+
+	tests := []struct {
+		name    string
+		handler methodHandler
+		txHash  common.Hash
+		want    interface{}
+	}{
+		{name: "elder_getSubmission", handler: r.getSubmission, txHash: pending.TxHash, want: pending},
+		{name: "elder_getSubmission of an unknown transaction", handler: r.getSubmission, txHash: unknown, want: nil},
+		{name: "elder_getElderTxHash", handler: r.getElderTxHash, txHash: included.TxHash, want: "elder-0"},
+		{name: "elder_getElderTxHash before the broadcast", handler: r.getElderTxHash, txHash: queued.TxHash, want: nil},
+		{name: "elder_getRollAppBlock of an included transaction", handler: r.getRollAppBlock, txHash: included.TxHash, want: "0x0f"},
+		{name: "elder_getRollAppBlock of a pending transaction", handler: r.getRollAppBlock, txHash: pending.TxHash, want: nil},
+		{name: "elder_getRollAppBlock before the broadcast", handler: r.getRollAppBlock, txHash: submitting.TxHash, want: nil},
+		{name: "elder_getRollAppBlock of an unknown transaction", handler: r.getRollAppBlock, txHash: unknown, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.handler(context.Background(), []interface{}{tt.txHash})
+			if err != nil {
+				t.Fatalf("%s error = %v", tt.name, err)
+			}
+			if want, ok := tt.want.(*Submission); ok {
+				submission, _ := got.(*Submission)
+				if submission == nil || submission.TxHash != want.TxHash || submission.Status != want.Status || submission.ElderTxHash != want.ElderTxHash {
+					t.Errorf("%s = %+v, want %+v", tt.name, got, want)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("elder_pendingSubmissions", func(t *testing.T) {
+		got, err := r.pendingSubmissions(context.Background(), []interface{}{key.EvmAddress})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var nonces []hexutil.Uint64
+		for _, submission := range got.([]*Submission) {
+			nonces = append(nonces, submission.Nonce)
+		}
+		if len(nonces) != 3 || nonces[0] != 2 || nonces[1] != 3 || nonces[2] != 4 {
+			t.Errorf("elder_pendingSubmissions nonces = %v, want [0x2 0x3 0x4]", nonces)
+		}
+	})
+
+	t.Run("elder_getRollAppBlock looks up once past the timeout", func(t *testing.T) {
+		// The lookup started above is blocked on Elder
+		for elder.lookedUp() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		for i := 0; i < 3; i++ {
+			if got, err := r.getRollAppBlock(context.Background(), []interface{}{pending.TxHash}); err != nil || got != nil {
+				t.Fatalf("getRollAppBlock() = %v, %v while looked up, want nil", got, err)
+			}
+		}
+		if got := elder.lookedUp(); got != 1 {
+			t.Errorf("lookups = %d, want 1", got)
+		}
+
+		close(elder.release)
+		waitForStatus(t, r, pending.TxHash, SubmissionIncluded)
+		if got, err := r.getRollAppBlock(context.Background(), []interface{}{pending.TxHash}); err != nil || got != "0x10" {
+			t.Errorf("getRollAppBlock() = %v, %v after the lookup, want 0x10", got, err)
+		}
+		if got := elder.lookedUp(); got != 1 {
+			t.Errorf("lookups = %d after the rollApp block was recorded, want 1", got)
+		}
+	})
+
+	t.Run("elder_getRollAppBlock waits for the lookup", func(t *testing.T) {
+		broadcast := &Submission{TxHash: common.HexToHash("0x06"), Sender: key.EvmAddress, Nonce: 5, ElderTxHash: "elder-2", Status: SubmissionPending, SubmittedAt: now, UpdatedAt: now}
+		notIncluded := &Submission{TxHash: common.HexToHash("0x07"), Sender: key.EvmAddress, Nonce: 6, ElderTxHash: "unknown", Status: SubmissionPending, SubmittedAt: now, UpdatedAt: now}
+		r.submissions.add(broadcast)
+		r.submissions.add(notIncluded)
+
+		if got, err := r.getRollAppBlock(context.Background(), []interface{}{broadcast.TxHash}); err != nil || got != "0x10" {
+			t.Errorf("getRollAppBlock() = %v, %v, want 0x10", got, err)
+		}
+		if got, err := r.getRollAppBlock(context.Background(), []interface{}{notIncluded.TxHash}); err != nil || got != nil {
+			t.Errorf("getRollAppBlock() of a transaction not included = %v, %v, want nil", got, err)
+		}
+		if err := r.Drain(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// recordingGauge records the value set on the gauge
+type recordingGauge struct {
+	prometheus.Gauge
+	value float64
+}
+
+func (g *recordingGauge) Set(value float64) {
+	g.value = value
+}
+
+func TestSubmissions_pendingGauge(t *testing.T) {
+	gauge := &recordingGauge{}
+	s := newSubmissions(nil, "rollup1", gauge, logging.NewDevSlogger(nil))
+	first, second := common.HexToHash("0x01"), common.HexToHash("0x02")
+
+	steps := []struct {
+		name   string
+		update func()
+		want   float64
+	}{
+		{name: "queued", update: func() { s.add(&Submission{TxHash: first, Status: SubmissionQueued}) }, want: 0},
+		{name: "submitting", update: func() { s.add(&Submission{TxHash: first, Status: SubmissionSubmitting}) }, want: 1},
+		{name: "pending", update: func() { s.setPending(first, "elder-0") }, want: 1},
+		{name: "second submitting", update: func() { s.add(&Submission{TxHash: second, Status: SubmissionSubmitting}) }, want: 2},
+		{name: "included", update: func() { s.setIncluded(first, "0x10") }, want: 1},
+		{name: "failed", update: func() { s.setFailed(second, errors.New("broadcast failed")) }, want: 0},
+	}
+
+	for _, step := range steps {
+		step.update()
+		if gauge.value != step.want {
+			t.Errorf("%s: pending gauge = %v, want %v", step.name, gauge.value, step.want)
+		}
+	}
+}
@@ -0,0 +1,568 @@
+package rollapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/keystore"
+	"github.com/0xElder/elder-wrap/pkg/logging"
+	"github.com/0xElder/elder-wrap/pkg/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// txPoolPromoteInterval is how often queued transactions are checked against the rollApp nonces
+	txPoolPromoteInterval = 5 * time.Second
+	// queuedTxLifetime is how long a transaction can wait for a nonce gap to be filled
+	queuedTxLifetime = 3 * time.Hour
+)
+
+var (
+	ErrNonceTooLow            = NewRPCError(NonceMismatchCode, "nonce too low", nil)
+	ErrAlreadyKnown           = NewRPCError(TxPoolRejectedCode, "already known", nil)
+	ErrReplacementUnderpriced = NewRPCError(TxPoolRejectedCode, "replacement transaction underpriced", nil)
+	ErrReplacementSubmitted   = NewRPCError(TxPoolRejectedCode, "replacement transaction not possible, nonce already submitted to Elder", nil)
+	ErrTxPoolSenderFull       = NewRPCError(TxPoolRejectedCode, "too many queued transactions for sender", nil)
+	ErrTxPoolFull             = NewRPCError(TxPoolRejectedCode, "tx pool is full", nil)
+)
+
+// senderLock is the lock of a sender and the number of callers holding or waiting for it
+type senderLock struct {
+	sync.Mutex
+	refs int
+}
+
+type pooledTx struct {
+	tx  *types.Transaction
+	key *keystore.Key
+	// admission holds the reservations of the transaction until it is broadcast
+	admission *admission
+	addedAt   time.Time
+}
+
+// txPool keeps, per sender, the next nonce expected by elder-wrap and the transactions with a future nonce.
+// Transactions are released to Elder in nonce order as the earlier ones are submitted.
+type txPool struct {
+	priceBump uint64
+	maxQueued int
+	// maxQueuedTotal caps the queued transactions of all senders
+	maxQueuedTotal int
+	persistPath    string
+	queuedGauge    prometheus.Gauge
+	logger         logging.Logger
+
+	mu sync.Mutex
+	// senderLocks serialize the nonce checks of a sender and the enqueueing of its Elder messages, so
+	// transactions reach Elder in nonce order. A lock is dropped once no one holds or waits for it.
+	senderLocks map[common.Address]*senderLock
+	// pending is the nonce following the last transaction submitted to Elder, it can be ahead of the
+	// rollApp pending nonce while Elder transactions are not included yet
+	pending map[common.Address]uint64
+	queued  map[common.Address]map[uint64]*pooledTx
+	// snapshot holds the queued transactions as of version, persist writes it without p.mu held
+	snapshot []*types.Transaction
+	version  uint64
+
+	// persistMu serializes the writes of persistPath, persisted is the version it holds
+	persistMu sync.Mutex
+	persisted uint64
+}
+
+func newTxPool(priceBump uint64, maxQueued, maxQueuedTotal int, persistPath string, queuedGauge prometheus.Gauge, logger logging.Logger) *txPool {
+	return &txPool{
+		priceBump:      priceBump,
+		maxQueued:      maxQueued,
+		maxQueuedTotal: maxQueuedTotal,
+		persistPath:    persistPath,
+		queuedGauge:    queuedGauge,
+		logger:         logger,
+		senderLocks:    make(map[common.Address]*senderLock),
+		pending:        make(map[common.Address]uint64),
+		queued:         make(map[common.Address]map[uint64]*pooledTx),
+	}
+}
+
+// lockSender locks the sender and returns the function to unlock it
+func (p *txPool) lockSender(sender common.Address) func() {
+	p.mu.Lock()
+	lock, ok := p.senderLocks[sender]
+	if !ok {
+		lock = &senderLock{}
+		p.senderLocks[sender] = lock
+	}
+	lock.refs++
+	p.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(p.senderLocks, sender)
+		}
+	}
+}
+
+// nextNonce returns the nonce the next transaction of sender has to use given the rollApp pending nonce
+func (p *txPool) nextNonce(sender common.Address, rpcNonce uint64) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pending, ok := p.pending[sender]; ok && pending > rpcNonce {
+		return pending
+	}
+	delete(p.pending, sender)
+	return rpcNonce
+}
+
+// reserve records that the transaction with nonce is being submitted to Elder
+func (p *txPool) reserve(sender common.Address, nonce uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending[sender] = nonce + 1
+}
+
+// release undoes reserve when the transaction could not be broadcast. The nonces reserved after it are
+// released too, their transactions can't execute without it.
+func (p *txPool) release(sender common.Address, nonce uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pending, ok := p.pending[sender]; ok && pending > nonce {
+		p.pending[sender] = nonce
+	}
+}
+
+// reset forgets the submitted nonces of sender, the rollApp pending nonce is used again afterwards
+func (p *txPool) reset(sender common.Address) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.pending, sender)
+}
+
+// enqueue adds a transaction with a future nonce, a queued transaction with the same nonce is
+// replaced when the new one pays at least priceBump percent more. The replaced transaction is returned.
+func (p *txPool) enqueue(sender common.Address, tx *types.Transaction, key *keystore.Key, adm *admission) (*pooledTx, error) {
+	defer p.persist()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	txs, ok := p.queued[sender]
+	if !ok {
+		txs = make(map[uint64]*pooledTx)
+		p.queued[sender] = txs
+	}
+
+	old, exists := txs[tx.Nonce()]
+	if exists {
+		if old.tx.Hash() == tx.Hash() {
+			return nil, ErrAlreadyKnown
+		}
+		if !p.isReplacement(old.tx, tx) {
+			return nil, fmt.Errorf("%w, fees must be %d%% higher", ErrReplacementUnderpriced, p.priceBump)
+		}
+	} else if len(txs) >= p.maxQueued {
+		return nil, ErrTxPoolSenderFull
+	} else if len(p.snapshot) >= p.maxQueuedTotal {
+		// The snapshot holds every queued transaction
+		return nil, ErrTxPoolFull
+	}
+
+	txs[tx.Nonce()] = &pooledTx{tx: tx, key: key, admission: adm, addedAt: time.Now()}
+	p.changed()
+
+	if exists {
+		return old, nil
+	}
+	return nil, nil
+}
+
+// isReplacement returns true when replacement bumps both the fee cap and the tip of old by priceBump percent
+func (p *txPool) isReplacement(old, replacement *types.Transaction) bool {
+	bump := big.NewInt(int64(100 + p.priceBump))
+	hundred := big.NewInt(100)
+
+	minFeeCap := new(big.Int).Div(new(big.Int).Mul(old.GasFeeCap(), bump), hundred)
+	minTipCap := new(big.Int).Div(new(big.Int).Mul(old.GasTipCap(), bump), hundred)
+	return replacement.GasFeeCap().Cmp(minFeeCap) >= 0 && replacement.GasTipCap().Cmp(minTipCap) >= 0
+}
+
+// pop removes and returns the queued transaction of sender with nonce
+func (p *txPool) pop(sender common.Address, nonce uint64) *pooledTx {
+	defer p.persist()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ptx, ok := p.queued[sender][nonce]
+	if !ok {
+		return nil
+	}
+	delete(p.queued[sender], nonce)
+	if len(p.queued[sender]) == 0 {
+		delete(p.queued, sender)
+	}
+	p.changed()
+	return ptx
+}
+
+// dropStale removes the queued transactions of sender which can't be included anymore,
+// because their nonce is below the rollApp pending nonce or they waited longer than queuedTxLifetime
+func (p *txPool) dropStale(sender common.Address, rpcNonce uint64) []*pooledTx {
+	defer p.persist()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var dropped []*pooledTx
+	for nonce, ptx := range p.queued[sender] {
+		if nonce < rpcNonce || time.Since(ptx.addedAt) > queuedTxLifetime {
+			dropped = append(dropped, ptx)
+			delete(p.queued[sender], nonce)
+		}
+	}
+	if len(p.queued[sender]) == 0 {
+		delete(p.queued, sender)
+	}
+	if len(dropped) > 0 {
+		p.changed()
+	}
+	return dropped
+}
+
+// dropSender removes the queued transactions of sender
+func (p *txPool) dropSender(sender common.Address) []*pooledTx {
+	defer p.persist()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	dropped := make([]*pooledTx, 0, len(p.queued[sender]))
+	for _, ptx := range p.queued[sender] {
+		dropped = append(dropped, ptx)
+	}
+	if len(dropped) > 0 {
+		delete(p.queued, sender)
+		p.changed()
+	}
+	return dropped
+}
+
+// hasQueued returns true when sender has queued transactions
+func (p *txPool) hasQueued(sender common.Address) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.queued[sender]) > 0
+}
+
+// contains returns true when the transaction is queued
+func (p *txPool) contains(sender common.Address, txHash common.Hash) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, ptx := range p.queued[sender] {
+		if ptx.tx.Hash() == txHash {
+			return true
+		}
+	}
+	return false
+}
+
+// senders returns the senders with queued transactions
+func (p *txPool) senders() []common.Address {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	senders := make([]common.Address, 0, len(p.queued))
+	for sender := range p.queued {
+		senders = append(senders, sender)
+	}
+	return senders
+}
+
+// size returns the number of queued transactions
+func (p *txPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	size := 0
+	for _, txs := range p.queued {
+		size += len(txs)
+	}
+	return size
+}
+
+// changed updates the queued gauge and takes the snapshot persisted next, must be called with p.mu held
+func (p *txPool) changed() {
+	snapshot := make([]*types.Transaction, 0)
+	for _, txs := range p.queued {
+		for _, ptx := range txs {
+			snapshot = append(snapshot, ptx.tx)
+		}
+	}
+	p.queuedGauge.Set(float64(len(snapshot)))
+	p.snapshot = snapshot
+	p.version++
+}
+
+// persist writes the last snapshot of the queued transactions to persistPath if set, must be called
+// without p.mu held. A snapshot already written by a concurrent call is not written again.
+func (p *txPool) persist() {
+	if p.persistPath == "" {
+		return
+	}
+
+	p.persistMu.Lock()
+	defer p.persistMu.Unlock()
+
+	p.mu.Lock()
+	snapshot, version := p.snapshot, p.version
+	p.mu.Unlock()
+	if version == p.persisted {
+		return
+	}
+
+	raws := make([]hexutil.Bytes, 0, len(snapshot))
+	for _, tx := range snapshot {
+		raw, err := tx.MarshalBinary()
+		if err != nil {
+			p.logger.Error(nil, "Failed to encode pooled transaction", "txHash", tx.Hash().Hex(), "error", err)
+			continue
+		}
+		raws = append(raws, raw)
+	}
+
+	content, err := json.Marshal(raws)
+	if err == nil {
+		err = writeFileAtomic(p.persistPath, content)
+	}
+	if err != nil {
+		p.logger.Error(nil, "Failed to persist tx pool", "path", p.persistPath, "error", err)
+		return
+	}
+	p.persisted = version
+}
+
+// loadPersisted returns the transactions persisted at persistPath, sorted by nonce
+func (p *txPool) loadPersisted() ([]*types.Transaction, error) {
+	if p.persistPath == "" {
+		return nil, nil
+	}
+
+	content, err := os.ReadFile(p.persistPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to read tx pool file")
+	}
+
+	var raws []hexutil.Bytes
+	if err := json.Unmarshal(content, &raws); err != nil {
+		return nil, errors.Wrap(err, "failed to decode tx pool file")
+	}
+
+	txs := make([]*types.Transaction, 0, len(raws))
+	for _, raw := range raws {
+		var tx types.Transaction
+		if err := tx.UnmarshalBinary(raw); err != nil {
+			return nil, errors.Wrap(err, "failed to decode pooled transaction")
+		}
+		txs = append(txs, &tx)
+	}
+	sort.Slice(txs, func(i, j int) bool { return txs[i].Nonce() < txs[j].Nonce() })
+	return txs, nil
+}
+
+// writeFileAtomic replaces the file at path with content, through a temporary file of the same directory
+func writeFileAtomic(path string, content []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// queueTransaction adds a transaction with a future nonce to the pool, with the reservations it
+// holds until it is broadcast
+func (r *RollApp) queueTransaction(ctx context.Context, tx *types.Transaction, key *keystore.Key, adm *admission) error {
+	logger := r.logger.With("method", "queueTransaction")
+
+	rawTx, err := tx.MarshalBinary()
+	if err != nil {
+		logger.Error(ctx, "Failed to encode transaction", "error", err)
+		return err
+	}
+
+	replaced, err := r.txPool.enqueue(key.EvmAddress, tx, key, adm)
+	if err != nil {
+		logger.Error(ctx, "Failed to queue transaction", "txHash", tx.Hash().Hex(), "error", err)
+		metrics.SubmissionFailures.WithLabelValues(r.Name, metrics.ReasonPool).Inc()
+		return err
+	}
+	if replaced != nil {
+		logger.Debug(ctx, "Replaced queued transaction", "txHash", tx.Hash().Hex(), "replaced", replaced.tx.Hash().Hex())
+		replaced.admission.release()
+		r.submissions.setFailed(replaced.tx.Hash(), fmt.Errorf("replaced by %s", tx.Hash().Hex()))
+	}
+
+	now := time.Now()
+	r.submissions.add(&Submission{
+		TxHash:      tx.Hash(),
+		Sender:      key.EvmAddress,
+		Nonce:       hexutil.Uint64(tx.Nonce()),
+		Status:      SubmissionQueued,
+		RawTx:       rawTx,
+		SubmittedAt: now,
+		UpdatedAt:   now,
+	})
+	metrics.Submissions.WithLabelValues(r.Name, metrics.StatusQueued).Inc()
+	logger.Debug(ctx, "Queued transaction until the nonce gap is filled", "txHash", tx.Hash().Hex(), "sender", key.EvmAddress.Hex(), "nonce", tx.Nonce())
+	return nil
+}
+
+// promote submits the queued transactions of sender which became executable, in nonce order
+func (r *RollApp) promote(ctx context.Context, sender common.Address) {
+	for r.promoteNext(ctx, sender) {
+	}
+}
+
+// promoteNext submits the queued transaction of sender with the next nonce, if any. The broadcast is
+// waited for in the background, so the following queued transactions can join its batch.
+// It returns false when there is nothing left to promote.
+func (r *RollApp) promoteNext(ctx context.Context, sender common.Address) bool {
+	logger := r.logger.With("method", "promoteNext")
+
+	unlock := r.txPool.lockSender(sender)
+	defer unlock()
+
+	rpcNonce, err := r.GetAddressNonce(ctx, sender.Hex())
+	if err != nil {
+		logger.Error(ctx, "Failed to get address nonce", "sender", sender.Hex(), "error", err)
+		return false
+	}
+
+	for _, ptx := range r.txPool.dropStale(sender, rpcNonce) {
+		logger.Warn(ctx, "Dropped queued transaction", "txHash", ptx.tx.Hash().Hex(), "nonce", ptx.tx.Nonce())
+		ptx.admission.release()
+		r.submissions.setFailed(ptx.tx.Hash(), errors.New("dropped from pool, nonce too low or expired"))
+	}
+
+	nonce := r.txPool.nextNonce(sender, rpcNonce)
+	ptx := r.txPool.pop(sender, nonce)
+	if ptx == nil {
+		return false
+	}
+
+	if ptx.admission.unchecked {
+		if err := r.checkAdmission(ctx, ptx.tx, ptx.key, ptx.admission); err != nil {
+			logger.Warn(ctx, "Dropped persisted transaction", "txHash", ptx.tx.Hash().Hex(), "error", err)
+			r.submissions.setFailed(ptx.tx.Hash(), err)
+			// The following nonces of the sender can't execute without it
+			for _, later := range r.txPool.dropSender(sender) {
+				logger.Warn(ctx, "Dropped queued transaction", "txHash", later.tx.Hash().Hex(), "nonce", later.tx.Nonce())
+				later.admission.release()
+				r.submissions.setFailed(later.tx.Hash(), fmt.Errorf("dropped from pool, nonce %d was rejected", nonce))
+			}
+			return false
+		}
+	}
+
+	logger.Debug(ctx, "Promoting queued transaction", "txHash", ptx.tx.Hash().Hex(), "sender", sender.Hex(), "nonce", nonce)
+	r.txPool.reserve(sender, nonce)
+	wait, err := r.broadcastTransaction(ctx, ptx.tx, ptx.key, ptx.admission)
+	if err != nil {
+		r.txPool.release(sender, nonce)
+		r.submissions.setFailed(ptx.tx.Hash(), err)
+		return false
+	}
+
+	r.background(func() {
+		elderTxHash, err := wait()
+		if err != nil {
+			r.txPool.release(sender, nonce)
+			r.submissions.setFailed(ptx.tx.Hash(), err)
+			return
+		}
+		r.confirmInclusion(context.WithoutCancel(ctx), sender, ptx.tx.Hash(), elderTxHash)
+	})
+	return true
+}
+
+// runTxPool periodically promotes queued transactions whose nonce gap was filled outside of elder-wrap,
+// queued transactions wait while submissions are paused
+func (r *RollApp) runTxPool() {
+	ticker := time.NewTicker(txPoolPromoteInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.quit:
+			return
+		case <-ticker.C:
+			if r.Paused() || !r.track() {
+				continue
+			}
+			for _, sender := range r.txPool.senders() {
+				r.promote(context.Background(), sender)
+			}
+			r.inflight.Done()
+		}
+	}
+}
+
+// loadTxPool queues the transactions persisted by a previous run. Their sponsor and policy checks run
+// when they are promoted, once the policies of this run are in force.
+func (r *RollApp) loadTxPool() error {
+	logger := r.logger.With("method", "loadTxPool")
+	ctx := context.Background()
+
+	txs, err := r.txPool.loadPersisted()
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range txs {
+		sender, err := types.LatestSignerForChainID(tx.ChainId()).Sender(tx)
+		if err != nil {
+			logger.Error(ctx, "Failed to get sender of persisted transaction", "txHash", tx.Hash().Hex(), "error", err)
+			continue
+		}
+		key, sponsored, err := r.submitterKey(ctx, sender)
+		if err != nil {
+			continue
+		}
+		if err := r.queueTransaction(ctx, tx, key, &admission{sponsored: sponsored, unchecked: true}); err != nil {
+			continue
+		}
+	}
+	logger.Info(ctx, "Loaded persisted tx pool", "queued", r.txPool.size())
+	return nil
+}
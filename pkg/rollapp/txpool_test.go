@@ -0,0 +1,238 @@
+package rollapp
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/0xElder/elder-wrap/pkg/logging"
+	"github.com/0xElder/elder-wrap/pkg/metrics"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func newPoolTx(nonce uint64, fee int64) *types.Transaction {
+	return types.NewTx(&types.DynamicFeeTx{
+		ChainID:   big.NewInt(1),
+		Nonce:     nonce,
+		GasTipCap: big.NewInt(fee),
+		GasFeeCap: big.NewInt(fee),
+		Gas:       21000,
+	})
+}
+
+func TestTxPool_enqueue(t *testing.T) {
+	sender := common.HexToAddress("0x1")
+
+	tests := []struct {
+		name        string
+		otherSender bool
+		fee         int64
+		nonce       uint64
+		wantErr     error
+	}{
+		{name: "new nonce over limit", nonce: 6, fee: 100, wantErr: ErrTxPoolSenderFull},
+		{name: "new sender over pool limit", otherSender: true, nonce: 6, fee: 100, wantErr: ErrTxPoolFull},
+		{name: "same transaction", nonce: 5, fee: 100, wantErr: ErrAlreadyKnown},
+		{name: "underpriced replacement", nonce: 5, fee: 105, wantErr: ErrReplacementUnderpriced},
+		{name: "replacement", nonce: 5, fee: 110},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newTxPool(10, 1, 1, "", metrics.TxPoolQueued.WithLabelValues("test"), logging.NewDevSlogger(nil))
+			if _, err := pool.enqueue(sender, newPoolTx(5, 100), nil, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			txSender := sender
+			if tt.otherSender {
+				txSender = common.HexToAddress("0x2")
+			}
+			_, err := pool.enqueue(txSender, newPoolTx(tt.nonce, tt.fee), nil, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("txPool.enqueue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTxPool_nextNonce(t *testing.T) {
+	sender := common.HexToAddress("0x1")
+	pool := newTxPool(10, 64, 1024, "", metrics.TxPoolQueued.WithLabelValues("test"), logging.NewDevSlogger(nil))
+
+	if got := pool.nextNonce(sender, 3); got != 3 {
+		t.Errorf("txPool.nextNonce() = %d, want 3", got)
+	}
+
+	pool.reserve(sender, 3)
+	pool.reserve(sender, 4)
+	if got := pool.nextNonce(sender, 3); got != 5 {
+		t.Errorf("txPool.nextNonce() after reserve = %d, want 5", got)
+	}
+
+	pool.release(sender, 4)
+	if got := pool.nextNonce(sender, 3); got != 4 {
+		t.Errorf("txPool.nextNonce() after release = %d, want 4", got)
+	}
+
+	pool.reserve(sender, 4)
+	pool.release(sender, 3)
+	if got := pool.nextNonce(sender, 2); got != 3 {
+		t.Errorf("txPool.nextNonce() after releasing an earlier nonce = %d, want 3", got)
+	}
+
+	pool.reset(sender)
+	if got := pool.nextNonce(sender, 3); got != 3 {
+		t.Errorf("txPool.nextNonce() after reset = %d, want 3", got)
+	}
+}
+
+func TestTxPool_lockSender(t *testing.T) {
+	sender := common.HexToAddress("0x1")
+	pool := newTxPool(10, 64, 1024, "", metrics.TxPoolQueued.WithLabelValues("test"), logging.NewDevSlogger(nil))
+
+	unlock := pool.lockSender(sender)
+	locked := make(chan struct{})
+	go func() {
+		defer close(locked)
+		pool.lockSender(sender)()
+	}()
+
+	// The lock is kept while the second caller waits for it
+	time.Sleep(10 * time.Millisecond)
+	unlock()
+	<-locked
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if len(pool.senderLocks) != 0 {
+		t.Errorf("txPool keeps %d sender locks once unlocked, want 0", len(pool.senderLocks))
+	}
+}
+
+func TestTxPool_persist(t *testing.T) {
+	sender := common.HexToAddress("0x1")
+	path := filepath.Join(t.TempDir(), "rollup1.json")
+
+	pool := newTxPool(10, 64, 1024, path, metrics.TxPoolQueued.WithLabelValues("test"), logging.NewDevSlogger(nil))
+	for _, nonce := range []uint64{9, 7, 8} {
+		if _, err := pool.enqueue(sender, newPoolTx(nonce, 100), nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pool.pop(sender, 8)
+
+	txs, err := newTxPool(10, 64, 1024, path, metrics.TxPoolQueued.WithLabelValues("test"), logging.NewDevSlogger(nil)).loadPersisted()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 2 || txs[0].Nonce() != 7 || txs[1].Nonce() != 9 {
+		t.Errorf("txPool.loadPersisted() returned %d transactions, want nonces 7 and 9", len(txs))
+	}
+	if entries, err := os.ReadDir(filepath.Dir(path)); err != nil || len(entries) != 1 {
+		t.Errorf("tx pool directory holds %d files, want only the persisted tx pool", len(entries))
+	}
+}
+
+// testReservation counts its releases
+type testReservation struct {
+	released atomic.Int32
+}
+
+func (r *testReservation) Settle(fee uint64) {}
+
+func (r *testReservation) Release() { r.released.Add(1) }
+
+func TestRollApp_promoteNext_persisted(t *testing.T) {
+	tests := []struct {
+		name          string
+		allowedSender bool
+		wantPromoted  bool
+		// wantTxs is the number of transactions the sponsor budget counts and Elder got
+		wantTxs uint64
+	}{
+		{name: "allowed by the sponsor policy", allowedSender: true, wantPromoted: true, wantTxs: 1},
+		{name: "rejected by the sponsor policy", wantPromoted: false, wantTxs: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newSubmitUpstream(1)
+			defer upstream.Close()
+			elder := &fakeElder{batchSize: 1, rollAppBlock: "0x10"}
+			r, _ := newSubmitTestRollApp(t, upstream.URL, elder)
+			r.cfg.SponsorKey = "alice"
+
+			senderKey, err := crypto.GenerateKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sender := crypto.PubkeyToAddress(senderKey.PublicKey)
+			cfg := config.SponsorPolicyConfig{AllowedSenders: []string{common.HexToAddress("0xa1").Hex()}}
+			if tt.allowedSender {
+				cfg.AllowedSenders = append(cfg.AllowedSenders, sender.Hex())
+			}
+			policy := newSponsorPolicy(cfg)
+			r.sponsorPolicy = policy
+
+			signTx := func(nonce uint64) *types.Transaction {
+				tx, err := types.SignNewTx(senderKey, types.LatestSignerForChainID(big.NewInt(1)), &types.DynamicFeeTx{
+					ChainID:   big.NewInt(1),
+					Nonce:     nonce,
+					GasTipCap: big.NewInt(1),
+					GasFeeCap: big.NewInt(1),
+					Gas:       21000,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return tx
+			}
+			key, sponsored, err := r.submitterKey(context.Background(), sender)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Queued as loadTxPool does, the sponsor policy runs on promotion
+			tx := signTx(1)
+			if err := r.queueTransaction(context.Background(), tx, key, &admission{sponsored: sponsored, unchecked: true}); err != nil {
+				t.Fatal(err)
+			}
+			// The next nonce waits behind it with a reservation
+			later, reservation := signTx(2), &testReservation{}
+			if err := r.queueTransaction(context.Background(), later, key, &admission{reserved: []Reservation{reservation}}); err != nil {
+				t.Fatal(err)
+			}
+
+			if promoted := r.promoteNext(context.Background(), sender); promoted != tt.wantPromoted {
+				t.Errorf("promoteNext() = %v, want %v", promoted, tt.wantPromoted)
+			}
+			if err := r.Drain(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if submission, ok := r.submissions.get(tx.Hash()); !ok || (submission.Status == SubmissionFailed) == tt.wantPromoted {
+				t.Errorf("submission = %+v, want failed %v", submission, !tt.wantPromoted)
+			}
+			// The later nonce is dropped with the rejected transaction, it can't execute without it
+			if submission, ok := r.submissions.get(later.Hash()); !ok || (submission.Status == SubmissionFailed) == tt.wantPromoted {
+				t.Errorf("later submission = %+v, want failed %v", submission, !tt.wantPromoted)
+			}
+			if r.txPool.contains(sender, later.Hash()) != tt.wantPromoted || (reservation.released.Load() == 1) == tt.wantPromoted {
+				t.Errorf("later transaction queued %v with %d releases, want queued %v", r.txPool.contains(sender, later.Hash()), reservation.released.Load(), tt.wantPromoted)
+			}
+			policy.mu.Lock()
+			txs := policy.txs
+			policy.mu.Unlock()
+			if txs != tt.wantTxs || uint64(len(elder.msgs)) != tt.wantTxs {
+				t.Errorf("sponsor budget counts %d txs and Elder got %d, want %d", txs, len(elder.msgs), tt.wantTxs)
+			}
+		})
+	}
+}
@@ -0,0 +1,415 @@
+package rollapp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/0xElder/elder-wrap/pkg/logging"
+	"github.com/0xElder/elder-wrap/pkg/metrics"
+	"github.com/0xElder/elder-wrap/pkg/tracing"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+)
+
+// upstreamProbeTimeout bounds an upstream health probe
+const upstreamProbeTimeout = 5 * time.Second
+
+// upstreamRetireDelay is how long replaced upstreams stay open for the calls in flight on them
+const upstreamRetireDelay = 30 * time.Second
+
+// UpstreamStatus is the health of an upstream rollApp RPC
+type UpstreamStatus struct {
+	URL         string    `json:"url"`
+	Healthy     bool      `json:"healthy"`
+	BlockNumber uint64    `json:"block_number"`
+	Latency     string    `json:"latency"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastCheck   time.Time `json:"last_check"`
+}
+
+type upstream struct {
+	url string
+	// host labels the metrics of the upstream, the url can hold API keys
+	host   string
+	client *ethclient.Client
+
+	mu          sync.RWMutex
+	healthy     bool
+	blockNumber uint64
+	latency     time.Duration
+	lastError   string
+	lastCheck   time.Time
+}
+
+// markFailed takes the upstream out of rotation until the next successful probe
+func (u *upstream) markFailed(err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.healthy = false
+	u.lastError = err.Error()
+}
+
+func (u *upstream) currentLatency() time.Duration {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	return u.latency
+}
+
+func (u *upstream) status() UpstreamStatus {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+
+	return UpstreamStatus{
+		URL:         u.url,
+		Healthy:     u.healthy,
+		BlockNumber: u.blockNumber,
+		Latency:     u.latency.String(),
+		LastError:   u.lastError,
+		LastCheck:   u.lastCheck,
+	}
+}
+
+// upstreamPool spreads the calls to the rollApp over its RPC endpoints. Upstreams are probed with
+// eth_blockNumber, an upstream which fails or lags more than maxBlockLag blocks behind the others
+// is skipped until it recovers.
+type upstreamPool struct {
+	rollApp     string
+	upstreams   []*upstream
+	selection   string
+	maxBlockLag uint64
+	next        atomic.Uint64
+	logger      logging.Logger
+	// stopped ends the probes, the clients are closed once
+	stopped   chan struct{}
+	stopOnce  sync.Once
+	closeOnce sync.Once
+
+	headMu sync.Mutex
+	// head is the highest block number seen on the upstreams, headAdvancedAt when it last increased
+	head           uint64
+	headAdvancedAt time.Time
+}
+
+func newUpstreamPool(rollApp string, urls []string, selection string, maxBlockLag uint64, logger logging.Logger) (*upstreamPool, error) {
+	p := &upstreamPool{
+		rollApp:     rollApp,
+		selection:   selection,
+		maxBlockLag: maxBlockLag,
+		logger:      logger,
+		stopped:     make(chan struct{}),
+	}
+	for _, rawURL := range urls {
+		parsed, err := url.Parse(rawURL)
+		if err != nil {
+			p.close()
+			return nil, errors.Wrapf(err, "invalid rpc %s", rawURL)
+		}
+		client, err := ethclient.Dial(rawURL)
+		if err != nil {
+			p.close()
+			return nil, errors.Wrapf(err, "failed to dial %s", rawURL)
+		}
+		// Upstreams are healthy until the first probe says otherwise
+		p.upstreams = append(p.upstreams, &upstream{url: rawURL, host: parsed.Host, client: client, healthy: true})
+	}
+	return p, nil
+}
+
+// candidates returns the upstreams in the order they should be tried: the healthy ones by the
+// selection strategy, then the unhealthy ones as a last resort
+func (p *upstreamPool) candidates() []*upstream {
+	var healthy, unhealthy []*upstream
+	for _, u := range p.upstreams {
+		if u.status().Healthy {
+			healthy = append(healthy, u)
+		} else {
+			unhealthy = append(unhealthy, u)
+		}
+	}
+
+	switch p.selection {
+	case config.UpstreamSelectionLowestLatency:
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return healthy[i].currentLatency() < healthy[j].currentLatency()
+		})
+	default:
+		if len(healthy) > 1 {
+			offset := int(p.next.Add(1) % uint64(len(healthy)))
+			healthy = append(healthy[offset:], healthy[:offset]...)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// upstreamPin is the upstream the state reads of a request are pinned to, so they see the same state
+type upstreamPin struct {
+	mu       sync.Mutex
+	upstream *upstream
+}
+
+type upstreamPinKey struct{}
+
+// withUpstreamPin returns a context whose state reads are pinned to one upstream, ctx is returned when
+// it is already pinned
+func withUpstreamPin(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(upstreamPinKey{}).(*upstreamPin); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, upstreamPinKey{}, &upstreamPin{})
+}
+
+// stateCandidates returns the upstreams in the order state reads should try them: the upstream pinned
+// for the request, then the healthy ones from the highest block, then the unhealthy ones
+func (p *upstreamPool) stateCandidates(pinned *upstream) []*upstream {
+	candidates := p.candidates()
+	healthy := 0
+	for healthy < len(candidates) && candidates[healthy].status().Healthy {
+		healthy++
+	}
+	sort.SliceStable(candidates[:healthy], func(i, j int) bool {
+		return candidates[i].status().BlockNumber > candidates[j].status().BlockNumber
+	})
+	for i, u := range candidates {
+		if u == pinned {
+			copy(candidates[1:i+1], candidates[:i])
+			candidates[0] = u
+			break
+		}
+	}
+	return candidates
+}
+
+// call runs fn with the ethclients of the upstreams in turn until one answers, like relay. The upstream
+// which answers is pinned for the rest of the request when ctx has a pin, see withUpstreamPin. Errors
+// answered by the upstream are returned without trying the next one.
+func (p *upstreamPool) call(ctx context.Context, fn func(client *ethclient.Client) error) error {
+	pin, _ := ctx.Value(upstreamPinKey{}).(*upstreamPin)
+	var pinned *upstream
+	if pin != nil {
+		pin.mu.Lock()
+		pinned = pin.upstream
+		pin.mu.Unlock()
+	}
+
+	var lastErr error
+	for _, u := range p.stateCandidates(pinned) {
+		err := fn(u.client)
+		if err == nil || ctx.Err() != nil || !upstreamFailed(err) {
+			if err == nil && pin != nil {
+				pin.mu.Lock()
+				pin.upstream = u
+				pin.mu.Unlock()
+			}
+			return err
+		}
+		p.logger.Warn(ctx, "Upstream failed, trying the next one", "rpc", u.url, "error", err)
+		u.markFailed(err)
+		lastErr = err
+	}
+	return lastErr
+}
+
+// upstreamFailed returns whether err is a failure to reach the upstream rather than an answer from it
+func upstreamFailed(err error) bool {
+	if errors.Is(err, ethereum.NotFound) {
+		return false
+	}
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		return false
+	}
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
+
+// relay posts a JSON-RPC request to the upstreams in turn until one answers. A request canceled by its
+// caller or past its deadline is not an upstream failure, it returns without trying the next one.
+func (p *upstreamPool) relay(ctx context.Context, body []byte) ([]byte, error) {
+	var lastErr error
+	for _, u := range p.candidates() {
+		responseBody, err := p.post(ctx, u, body)
+		if err == nil {
+			return responseBody, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		p.logger.Warn(ctx, "Upstream failed, trying the next one", "rpc", u.url, "error", err)
+		u.markFailed(err)
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (p *upstreamPool) post(ctx context.Context, u *upstream, body []byte) (responseBody []byte, err error) {
+	defer func(start time.Time) {
+		status := "ok"
+		if err != nil {
+			status = "error"
+		}
+		metrics.UpstreamDuration.WithLabelValues(p.rollApp, u.host, status).Observe(time.Since(start).Seconds())
+	}(time.Now())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, req.Header)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("rollApp RPC returned %s", resp.Status)
+	}
+	return responseBody, nil
+}
+
+// probe refreshes the health of every upstream
+func (p *upstreamPool) probe(ctx context.Context) {
+	wasHealthy := make([]bool, len(p.upstreams))
+	for i, u := range p.upstreams {
+		wasHealthy[i] = u.status().Healthy
+	}
+
+	var wg sync.WaitGroup
+	for _, u := range p.upstreams {
+		wg.Add(1)
+		go func(u *upstream) {
+			defer wg.Done()
+
+			probeCtx, cancel := context.WithTimeout(ctx, upstreamProbeTimeout)
+			defer cancel()
+
+			start := time.Now()
+			blockNumber, err := u.client.BlockNumber(probeCtx)
+			latency := time.Since(start)
+
+			u.mu.Lock()
+			defer u.mu.Unlock()
+			u.lastCheck = time.Now()
+			if err != nil {
+				u.healthy = false
+				u.lastError = err.Error()
+				return
+			}
+			u.healthy = true
+			u.blockNumber = blockNumber
+			u.latency = latency
+			u.lastError = ""
+		}(u)
+	}
+	wg.Wait()
+
+	var highest uint64
+	for _, u := range p.upstreams {
+		if status := u.status(); status.Healthy && status.BlockNumber > highest {
+			highest = status.BlockNumber
+		}
+	}
+	p.headMu.Lock()
+	if highest > p.head {
+		p.head = highest
+		p.headAdvancedAt = time.Now()
+	}
+	p.headMu.Unlock()
+	for i, u := range p.upstreams {
+		u.mu.Lock()
+		if u.healthy && highest-u.blockNumber > p.maxBlockLag {
+			u.healthy = false
+			u.lastError = fmt.Sprintf("%d blocks behind", highest-u.blockNumber)
+		}
+		u.mu.Unlock()
+
+		status := u.status()
+		healthy := 0.0
+		if status.Healthy {
+			healthy = 1
+		}
+		metrics.UpstreamHealthy.WithLabelValues(p.rollApp, u.host).Set(healthy)
+		metrics.UpstreamBlockNumber.WithLabelValues(p.rollApp, u.host).Set(float64(status.BlockNumber))
+
+		if wasHealthy[i] && !status.Healthy {
+			p.logger.Warn(ctx, "Upstream unhealthy", "rpc", status.URL, "error", status.LastError)
+		} else if !wasHealthy[i] && status.Healthy {
+			p.logger.Info(ctx, "Upstream recovered", "rpc", status.URL, "blockNumber", status.BlockNumber)
+		}
+	}
+}
+
+// run probes the upstreams every interval until quit is closed or the pool is stopped
+func (p *upstreamPool) run(interval time.Duration, quit chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	p.probe(context.Background())
+	for {
+		select {
+		case <-quit:
+			return
+		case <-p.stopped:
+			return
+		case <-ticker.C:
+			p.probe(context.Background())
+		}
+	}
+}
+
+// latestBlock returns the highest block number seen on the upstreams and when it was first seen
+func (p *upstreamPool) latestBlock() (uint64, time.Time) {
+	p.headMu.Lock()
+	defer p.headMu.Unlock()
+
+	return p.head, p.headAdvancedAt
+}
+
+func (p *upstreamPool) status() []UpstreamStatus {
+	statuses := make([]UpstreamStatus, len(p.upstreams))
+	for i, u := range p.upstreams {
+		statuses[i] = u.status()
+	}
+	return statuses
+}
+
+func (p *upstreamPool) stop() {
+	p.stopOnce.Do(func() { close(p.stopped) })
+}
+
+// retire stops probing the upstreams and closes them once the calls in flight are done
+func (p *upstreamPool) retire() {
+	p.stop()
+	time.AfterFunc(upstreamRetireDelay, p.close)
+}
+
+func (p *upstreamPool) close() {
+	p.stop()
+	p.closeOnce.Do(func() {
+		for _, u := range p.upstreams {
+			u.client.Close()
+		}
+	})
+}
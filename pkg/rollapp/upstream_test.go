@@ -0,0 +1,213 @@
+package rollapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/0xElder/elder-wrap/pkg/logging"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// newTestUpstream serves eth_blockNumber with blockNumber, or fails every call when blockNumber is negative
+func newTestUpstream(blockNumber int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if blockNumber < 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var request JsonRPCRequest
+		json.NewDecoder(req.Body).Decode(&request)
+		json.NewEncoder(w).Encode(JsonRPCResponse{JsonRPC: "2.0", ID: request.ID, Result: fmt.Sprintf("0x%x", blockNumber)})
+	}))
+}
+
+func TestUpstreamPool_probe(t *testing.T) {
+	healthy := newTestUpstream(100)
+	defer healthy.Close()
+	lagging := newTestUpstream(90)
+	defer lagging.Close()
+	down := newTestUpstream(-1)
+	defer down.Close()
+
+	pool, err := newUpstreamPool("rollup1", []string{down.URL, lagging.URL, healthy.URL}, config.UpstreamSelectionRoundRobin, 5, logging.NewDevSlogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.close()
+
+	pool.probe(context.Background())
+
+	want := map[string]bool{healthy.URL: true, lagging.URL: false, down.URL: false}
+	for _, status := range pool.status() {
+		if status.Healthy != want[status.URL] {
+			t.Errorf("upstream %s healthy = %v, want %v (%s)", status.URL, status.Healthy, want[status.URL], status.LastError)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		if got := pool.candidates()[0].url; got != healthy.URL {
+			t.Errorf("candidates()[0] = %s, want %s", got, healthy.URL)
+		}
+	}
+}
+
+func TestUpstreamPool_relay(t *testing.T) {
+	down := newTestUpstream(-1)
+	defer down.Close()
+	up := newTestUpstream(100)
+	defer up.Close()
+
+	pool, err := newUpstreamPool("rollup1", []string{down.URL, up.URL}, config.UpstreamSelectionRoundRobin, 5, logging.NewDevSlogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.close()
+
+	// Both upstreams are in rotation until probed, the failing one is skipped on error
+	for i := 0; i < 2; i++ {
+		body, err := pool.relay(context.Background(), []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`))
+		if err != nil {
+			t.Fatalf("relay() error = %v", err)
+		}
+		var response JsonRPCResponse
+		if err := json.Unmarshal(body, &response); err != nil || response.Result != "0x64" {
+			t.Errorf("relay() = %s, want block 0x64", body)
+		}
+	}
+
+	for _, status := range pool.status() {
+		if status.URL == down.URL && status.Healthy {
+			t.Errorf("failing upstream still healthy after relay")
+		}
+	}
+}
+
+func TestUpstreamPool_call(t *testing.T) {
+	down := newTestUpstream(-1)
+	defer down.Close()
+	behind := newTestUpstream(95)
+	defer behind.Close()
+	ahead := newTestUpstream(100)
+	defer ahead.Close()
+
+	pool, err := newUpstreamPool("rollup1", []string{down.URL, behind.URL, ahead.URL}, config.UpstreamSelectionRoundRobin, 10, logging.NewDevSlogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.close()
+
+	blockNumber := func(ctx context.Context) uint64 {
+		var blockNumber uint64
+		err := pool.call(ctx, func(client *ethclient.Client) (err error) {
+			blockNumber, err = client.BlockNumber(ctx)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("call() error = %v", err)
+		}
+		return blockNumber
+	}
+
+	// Before the first probe the failing upstream is skipped on error
+	for i := 0; i < 3; i++ {
+		blockNumber(context.Background())
+	}
+	for _, status := range pool.status() {
+		if status.URL == down.URL && status.Healthy {
+			t.Errorf("failing upstream still healthy after call")
+		}
+	}
+
+	pool.probe(context.Background())
+	pinned := withUpstreamPin(context.Background())
+	if got := blockNumber(pinned); got != 100 {
+		t.Errorf("call() = block %d, want the highest block 100", got)
+	}
+
+	// The upstream behind moves ahead, the pinned request keeps reading from the same upstream
+	for _, u := range pool.upstreams {
+		if u.url == behind.URL {
+			u.mu.Lock()
+			u.blockNumber = 105
+			u.mu.Unlock()
+		}
+	}
+	if got := blockNumber(pinned); got != 100 {
+		t.Errorf("pinned call() = block %d, want 100", got)
+	}
+	if got := blockNumber(context.Background()); got != 95 {
+		t.Errorf("call() = block %d, want 95 from the upstream with the highest block", got)
+	}
+}
+
+func TestUpstreamPool_relayCanceled(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls.Add(1)
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	pool, err := newUpstreamPool("rollup1", []string{slow.URL, slow.URL + "/"}, config.UpstreamSelectionRoundRobin, 5, logging.NewDevSlogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.relay(ctx, []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)); err == nil {
+		t.Fatal("relay() past the deadline succeeded, want error")
+	}
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("relay() past the deadline called %d upstreams, want 1", got)
+	}
+	for _, status := range pool.status() {
+		if status.LastError != "" {
+			t.Errorf("upstream %s marked failed after the caller's deadline: %s", status.URL, status.LastError)
+		}
+	}
+}
+
+func TestRollApp_ReadinessCheck(t *testing.T) {
+	up := newTestUpstream(100)
+	defer up.Close()
+
+	pool, err := newUpstreamPool("rollup1", []string{up.URL}, config.UpstreamSelectionRoundRobin, 5, logging.NewDevSlogger(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.close()
+	r := &RollApp{Name: "rollup1", upstreams: pool, logger: logging.NewDevSlogger(nil)}
+	check := r.ReadinessCheck(time.Minute)
+
+	if _, err := check.Check(context.Background()); err == nil {
+		t.Errorf("Check() before the first probe succeeded, want error")
+	}
+
+	pool.probe(context.Background())
+	details, err := check.Check(context.Background())
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if status := details.(readiness); status.ChainID != 100 || status.BlockNumber != 100 || status.HealthyUpstreams != 1 {
+		t.Errorf("Check() = %+v, want chain id 100, block 100 and 1 healthy upstream", status)
+	}
+
+	// The block number does not advance, the rollApp is not ready once it is older than the max age
+	pool.headAdvancedAt = time.Now().Add(-2 * time.Minute)
+	pool.probe(context.Background())
+	if _, err := check.Check(context.Background()); err == nil {
+		t.Errorf("Check() with a stalled block number succeeded, want error")
+	}
+}
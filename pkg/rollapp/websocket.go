@@ -0,0 +1,447 @@
+package rollapp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/logging"
+	"github.com/0xElder/elder-wrap/pkg/tracing"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsWriteTimeout bounds a write to a websocket client
+	wsWriteTimeout = 10 * time.Second
+	// wsPendingTxBuffer is the number of submitted transactions buffered for a websocket client,
+	// a client which falls further behind misses transactions
+	wsPendingTxBuffer = 256
+)
+
+// txFeed fans out the transactions submitted to Elder to the websocket sessions
+type txFeed struct {
+	mu   sync.Mutex
+	subs map[chan *types.Transaction]struct{}
+}
+
+func (f *txFeed) subscribe() chan *types.Transaction {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.subs == nil {
+		f.subs = make(map[chan *types.Transaction]struct{})
+	}
+	ch := make(chan *types.Transaction, wsPendingTxBuffer)
+	f.subs[ch] = struct{}{}
+	return ch
+}
+
+func (f *txFeed) unsubscribe(ch chan *types.Transaction) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.subs, ch)
+}
+
+// send never blocks, subscribers with a full buffer miss the transaction
+func (f *txFeed) send(tx *types.Transaction) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for ch := range f.subs {
+		select {
+		case ch <- tx:
+		default:
+		}
+	}
+}
+
+// wsSession proxies a websocket client to the rollApp websocket endpoint. Methods served by elder-wrap
+// are handled locally and the transactions submitted by elder-wrap are emitted on newPendingTransactions
+// subscriptions, next to the ones of the rollApp.
+type wsSession struct {
+	// ctx is the context of the upgrade request, with the trace context propagated by the client
+	ctx    context.Context
+	r      *RollApp
+	client *websocket.Conn
+	// upstream is nil when the rollApp has no websocket endpoint, calls are then relayed over HTTP
+	upstream *websocket.Conn
+	logger   logging.Logger
+
+	writeMu sync.Mutex
+
+	mu sync.Mutex
+	// subscribeRequests are the ids of the newPendingTransactions subscribe requests sent upstream,
+	// with their fullTx flag, until the upstream subscription id is known
+	subscribeRequests map[string]bool
+	// pendingTxSubs are the newPendingTransactions subscription ids with their fullTx flag
+	pendingTxSubs map[string]bool
+}
+
+// HandleWebSocket serves JSON-RPC over websocket on the rollApp endpoint
+func (r *RollApp) HandleWebSocket(w http.ResponseWriter, req *http.Request) {
+	logger := r.logger.With("method", "HandleWebSocket")
+
+	var upstream *websocket.Conn
+	if ws := r.settings().WS; ws != "" {
+		conn, _, err := websocket.DefaultDialer.DialContext(req.Context(), ws, nil)
+		if err != nil {
+			logger.Error(req.Context(), "Failed to connect to rollApp websocket", "ws", ws, "error", err)
+			http.Error(w, "Failed to connect to rollApp websocket", http.StatusBadGateway)
+			return
+		}
+		upstream = conn
+	}
+
+	upgrader := websocket.Upgrader{CheckOrigin: r.checkOrigin}
+	client, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// Upgrade already replied to the client
+		logger.Error(req.Context(), "Failed to upgrade to websocket", "error", err)
+		if upstream != nil {
+			upstream.Close()
+		}
+		return
+	}
+
+	s := &wsSession{
+		ctx:               tracing.Extract(req.Context(), req.Header),
+		r:                 r,
+		client:            client,
+		upstream:          upstream,
+		logger:            r.logger.With("component", "wsSession", "remote", req.RemoteAddr),
+		subscribeRequests: make(map[string]bool),
+		pendingTxSubs:     make(map[string]bool),
+	}
+	s.run()
+}
+
+// checkOrigin allows the websockets of clients without an Origin header, of the same origin and of the
+// origins in ws_origins. Any other web page visited by the operator could use the wrapper, and its node
+// signing keys.
+func (r *RollApp) checkOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range r.settings().WSOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, req.Host) {
+		return true
+	}
+	r.logger.Warn(req.Context(), "Rejecting cross-origin websocket", "origin", origin)
+	return false
+}
+
+func (s *wsSession) run() {
+	s.logger.Debug(nil, "Websocket session started")
+	defer s.logger.Debug(nil, "Websocket session closed")
+	defer s.client.Close()
+
+	txs := s.r.pendingTxs.subscribe()
+	defer s.r.pendingTxs.unsubscribe(txs)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	if s.upstream != nil {
+		defer s.upstream.Close()
+		go s.readUpstream()
+	}
+	go s.emitPendingTxs(txs, done)
+	go s.closeOnQuit(done)
+
+	s.readClient()
+}
+
+// closeOnQuit closes the client connection when the rollApp is closed, hijacked connections are
+// not closed by the HTTP server shutdown
+func (s *wsSession) closeOnQuit(done chan struct{}) {
+	select {
+	case <-done:
+	case <-s.r.quit:
+		s.writeMu.Lock()
+		s.client.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "shutting down"), time.Now().Add(wsWriteTimeout))
+		s.writeMu.Unlock()
+		s.client.Close()
+	}
+}
+
+// readClient serves the client messages until the client or the upstream connection is closed
+func (s *wsSession) readClient() {
+	for {
+		_, message, err := s.client.ReadMessage()
+		if err != nil {
+			s.logger.Debug(nil, "Websocket client read failed", "error", err)
+			return
+		}
+		s.handleMessage(message)
+	}
+}
+
+// readUpstream relays the upstream messages to the client, and closes the client when upstream goes away
+func (s *wsSession) readUpstream() {
+	defer s.client.Close()
+
+	for {
+		_, message, err := s.upstream.ReadMessage()
+		if err != nil {
+			s.logger.Debug(nil, "Websocket upstream read failed", "error", err)
+			return
+		}
+		s.trackSubscription(message)
+		s.writeRaw(message)
+	}
+}
+
+func (s *wsSession) handleMessage(message []byte) {
+	if isBatch(message) {
+		// Batches are served like over HTTP, subscriptions are not supported in batches
+		go s.handleBatch(message)
+		return
+	}
+
+	var rpcRequest JsonRPCRequest
+	if err := json.Unmarshal(message, &rpcRequest); err != nil {
+		s.write(errorResponse(nil, withCode(ParseErrorCode, err, nil)))
+		return
+	}
+	if rpcRequest.Method == "" {
+		s.write(errorResponse(rpcRequest.ID, NewRPCError(InvalidRequestCode, "missing method", nil)))
+		return
+	}
+
+	if handler, ok := s.r.method(rpcRequest.Method); ok {
+		// eth_sendRawTransaction can wait for inclusion, other messages are served meanwhile
+		go func() {
+			ctx, span := tracing.Start(s.ctx, "HandleWebSocket", tracing.RollAppKey.String(s.r.Name), tracing.MethodKey.String(rpcRequest.Method))
+			defer span.End()
+			response := s.r.handleCall(ctx, handler, rpcRequest)
+			if !isNotification(message) {
+				s.write(response)
+			}
+		}()
+		return
+	}
+
+	switch rpcRequest.Method {
+	case "eth_subscribe":
+		var kind string
+		if err := decodeParam(rpcRequest.Params, 0, &kind); err == nil && kind == "newPendingTransactions" {
+			s.subscribePendingTxs(rpcRequest, message)
+			return
+		}
+	case "eth_unsubscribe":
+		if s.unsubscribeLocal(rpcRequest) {
+			return
+		}
+	}
+	s.forward(message)
+}
+
+func (s *wsSession) handleBatch(message []byte) {
+	var rawRequests []json.RawMessage
+	if err := json.Unmarshal(message, &rawRequests); err != nil {
+		s.write(errorResponse(nil, withCode(ParseErrorCode, err, nil)))
+		return
+	}
+	if len(rawRequests) == 0 {
+		s.write(errorResponse(nil, NewRPCError(InvalidRequestCode, "empty batch", nil)))
+		return
+	}
+
+	rpcRequests := make([]*JsonRPCRequest, len(rawRequests))
+	for i, rawRequest := range rawRequests {
+		var rpcRequest JsonRPCRequest
+		if err := json.Unmarshal(rawRequest, &rpcRequest); err != nil || rpcRequest.Method == "" {
+			continue
+		}
+		rpcRequests[i] = &rpcRequest
+	}
+
+	ctx, span := tracing.Start(s.ctx, "HandleWebSocket", tracing.RollAppKey.String(s.r.Name), tracing.MethodKey.String("batch"), tracing.BatchSizeKey.Int(len(rawRequests)))
+	defer span.End()
+	if responses := s.r.handleBatch(ctx, rawRequests, rpcRequests); len(responses) > 0 {
+		s.write(responses)
+	}
+}
+
+// forward sends a message to the rollApp websocket, or relays it over HTTP when there is none
+func (s *wsSession) forward(message []byte) {
+	if s.upstream != nil {
+		// Only the client read loop writes upstream
+		if err := s.upstream.WriteMessage(websocket.TextMessage, message); err != nil {
+			s.logger.Error(nil, "Failed to forward message to rollApp websocket", "error", err)
+			s.client.Close()
+		}
+		return
+	}
+
+	go func() {
+		response, err := s.r.relay(s.ctx, message)
+		if err != nil {
+			if response := relayFailed(message, err); response != nil {
+				s.write(response)
+			}
+			return
+		}
+		s.writeRaw(response)
+	}()
+}
+
+// relayFailed is the answer to a message which could not be relayed, with an error for each request of
+// a batch. Notifications are not answered, it is nil when there is nothing to answer.
+func relayFailed(message []byte, err error) interface{} {
+	rpcErr := withCode(InternalErrorCode, err, nil)
+	if !isBatch(message) {
+		if isNotification(message) {
+			return nil
+		}
+		return errorResponse(requestID(message), rpcErr)
+	}
+
+	var rawRequests []json.RawMessage
+	if err := json.Unmarshal(message, &rawRequests); err != nil {
+		return errorResponse(nil, rpcErr)
+	}
+	responses := make([]JsonRPCResponse, 0, len(rawRequests))
+	for _, rawRequest := range rawRequests {
+		if !isNotification(rawRequest) {
+			responses = append(responses, errorResponse(requestID(rawRequest), rpcErr))
+		}
+	}
+	if len(responses) == 0 {
+		return nil
+	}
+	return responses
+}
+
+// subscribePendingTxs subscribes the client to newPendingTransactions, the subscription also
+// receives the transactions submitted by elder-wrap
+func (s *wsSession) subscribePendingTxs(rpcRequest JsonRPCRequest, message []byte) {
+	var fullTx bool
+	if len(rpcRequest.Params) > 1 {
+		decodeParam(rpcRequest.Params, 1, &fullTx)
+	}
+
+	if s.upstream != nil {
+		s.mu.Lock()
+		s.subscribeRequests[canonicalID(rpcRequest.ID)] = fullTx
+		s.mu.Unlock()
+		s.forward(message)
+		return
+	}
+
+	subID := string(rpc.NewID())
+	s.mu.Lock()
+	s.pendingTxSubs[subID] = fullTx
+	s.mu.Unlock()
+	s.write(JsonRPCResponse{JsonRPC: "2.0", ID: rpcRequest.ID, Result: subID})
+}
+
+// unsubscribeLocal removes a newPendingTransactions subscription, it returns true when the
+// subscription only exists in elder-wrap and the client was answered
+func (s *wsSession) unsubscribeLocal(rpcRequest JsonRPCRequest) bool {
+	var subID string
+	if err := decodeParam(rpcRequest.Params, 0, &subID); err != nil {
+		return false
+	}
+
+	s.mu.Lock()
+	_, ok := s.pendingTxSubs[subID]
+	delete(s.pendingTxSubs, subID)
+	s.mu.Unlock()
+
+	if !ok || s.upstream != nil {
+		return false
+	}
+	s.write(JsonRPCResponse{JsonRPC: "2.0", ID: rpcRequest.ID, Result: true})
+	return true
+}
+
+// trackSubscription records the subscription id of an upstream newPendingTransactions subscription
+func (s *wsSession) trackSubscription(message []byte) {
+	var response struct {
+		ID     json.RawMessage `json:"id"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(message, &response); err != nil || len(response.ID) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fullTx, ok := s.subscribeRequests[canonicalID(response.ID)]
+	if !ok {
+		return
+	}
+	delete(s.subscribeRequests, canonicalID(response.ID))
+
+	var subID string
+	if err := json.Unmarshal(response.Result, &subID); err == nil && subID != "" {
+		s.pendingTxSubs[subID] = fullTx
+	}
+}
+
+// emitPendingTxs notifies the newPendingTransactions subscriptions of the transactions submitted by elder-wrap
+func (s *wsSession) emitPendingTxs(txs chan *types.Transaction, done chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case tx := <-txs:
+			s.mu.Lock()
+			subs := make(map[string]bool, len(s.pendingTxSubs))
+			for subID, fullTx := range s.pendingTxSubs {
+				subs[subID] = fullTx
+			}
+			s.mu.Unlock()
+
+			for subID, fullTx := range subs {
+				var result interface{} = tx.Hash()
+				if fullTx {
+					result = tx
+				}
+				s.write(map[string]interface{}{
+					"jsonrpc": "2.0",
+					"method":  "eth_subscription",
+					"params": map[string]interface{}{
+						"subscription": subID,
+						"result":       result,
+					},
+				})
+			}
+		}
+	}
+}
+
+func (s *wsSession) write(v interface{}) {
+	message, err := json.Marshal(v)
+	if err != nil {
+		s.logger.Error(nil, "Failed to encode websocket message", "error", err)
+		return
+	}
+	s.writeRaw(message)
+}
+
+func (s *wsSession) writeRaw(message []byte) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.client.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := s.client.WriteMessage(websocket.TextMessage, message); err != nil {
+		s.logger.Debug(nil, "Websocket client write failed", "error", err)
+		s.client.Close()
+	}
+}
@@ -0,0 +1,163 @@
+package rollapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/0xElder/elder-wrap/pkg/logging"
+	"github.com/gorilla/websocket"
+)
+
+func TestRollApp_HandleWebSocket_pendingTransactions(t *testing.T) {
+	r := &RollApp{
+		logger: logging.NewDevSlogger(nil),
+		methods: map[string]methodHandler{
+			"elder_getSubmission": func(ctx context.Context, params []interface{}) (interface{}, error) {
+				return nil, nil
+			},
+		},
+	}
+	server := httptest.NewServer(http.HandlerFunc(r.HandleWebSocket))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_subscribe","params":["newPendingTransactions"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	var subscribed JsonRPCResponse
+	if err := conn.ReadJSON(&subscribed); err != nil {
+		t.Fatal(err)
+	}
+	subID, ok := subscribed.Result.(string)
+	if !ok || subID == "" {
+		t.Fatalf("eth_subscribe result = %v, want a subscription id", subscribed.Result)
+	}
+
+	tx := newPoolTx(0, 100)
+	// The session subscribed to the feed before reading eth_subscribe
+	r.pendingTxs.send(tx)
+
+	var notification struct {
+		Method string `json:"method"`
+		Params struct {
+			Subscription string `json:"subscription"`
+			Result       string `json:"result"`
+		} `json:"params"`
+	}
+	if err := conn.ReadJSON(&notification); err != nil {
+		t.Fatal(err)
+	}
+	if notification.Method != "eth_subscription" || notification.Params.Subscription != subID || notification.Params.Result != tx.Hash().Hex() {
+		t.Errorf("notification = %+v, want %s on subscription %s", notification, tx.Hash().Hex(), subID)
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":2,"method":"elder_getSubmission","params":["0x01"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(message, &response); err != nil {
+		t.Fatal(err)
+	}
+	if string(response["id"]) != "2" || string(response["result"]) != "null" {
+		t.Errorf("elder_getSubmission response = %s, want null result for id 2", message)
+	}
+}
+
+func TestRollApp_checkOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		origin  string
+		want    bool
+	}{
+		{name: "no origin header", origin: "", want: true},
+		{name: "same origin", origin: "http://wrapper.example:8080", want: true},
+		{name: "cross origin rejected by default", origin: "https://evil.example", want: false},
+		{name: "allowed origin", origins: []string{"https://dapp.example"}, origin: "https://DApp.example", want: true},
+		{name: "origin not allowed", origins: []string{"https://dapp.example"}, origin: "https://evil.example", want: false},
+		{name: "any origin", origins: []string{"*"}, origin: "https://evil.example", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RollApp{logger: logging.NewDevSlogger(nil), cfg: config.RollAppConfig{WSOrigins: tt.origins}}
+			req := httptest.NewRequest(http.MethodGet, "http://wrapper.example:8080/rollup1", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if got := r.checkOrigin(req); got != tt.want {
+				t.Errorf("checkOrigin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelayFailed(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		// want are the ids of the error responses, a single response when there is no batch
+		want  string
+		batch bool
+	}{
+		{name: "request", message: `{"jsonrpc":"2.0","id":"a1","method":"eth_chainId"}`, want: `"a1"`},
+		{name: "notification", message: `{"jsonrpc":"2.0","method":"eth_chainId"}`},
+		{name: "batch", message: `[{"jsonrpc":"2.0","id":7,"method":"eth_chainId"},{"jsonrpc":"2.0","method":"eth_chainId"},{"jsonrpc":"2.0","id":8,"method":"eth_blockNumber"}]`, want: `[7,8]`, batch: true},
+		{name: "batch of notifications", message: `[{"jsonrpc":"2.0","method":"eth_chainId"}]`, batch: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := relayFailed([]byte(tt.message), errors.New("rollApp RPC unavailable"))
+			if tt.want == "" {
+				if response != nil {
+					t.Errorf("relayFailed() = %+v, want no response", response)
+				}
+				return
+			}
+
+			encoded, err := json.Marshal(response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			if tt.batch {
+				var responses []map[string]json.RawMessage
+				if err := json.Unmarshal(encoded, &responses); err != nil {
+					t.Fatal(err)
+				}
+				ids := make([]string, len(responses))
+				for i, response := range responses {
+					ids[i] = string(response["id"])
+				}
+				got = "[" + strings.Join(ids, ",") + "]"
+			} else {
+				var single map[string]json.RawMessage
+				if err := json.Unmarshal(encoded, &single); err != nil {
+					t.Fatal(err)
+				}
+				got = string(single["id"])
+			}
+			if got != tt.want {
+				t.Errorf("relayFailed() answered ids %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
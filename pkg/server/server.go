@@ -0,0 +1,500 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/0xElder/elder-wrap/pkg/elder"
+	"github.com/0xElder/elder-wrap/pkg/health"
+	"github.com/0xElder/elder-wrap/pkg/journal"
+	"github.com/0xElder/elder-wrap/pkg/keystore"
+	"github.com/0xElder/elder-wrap/pkg/logging"
+	"github.com/0xElder/elder-wrap/pkg/metrics"
+	"github.com/0xElder/elder-wrap/pkg/middleware"
+	"github.com/0xElder/elder-wrap/pkg/policy"
+	"github.com/0xElder/elder-wrap/pkg/rollapp"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// Server serves the rollApps of the config. Reload swaps the routes as a whole, requests in flight
+// finish on the routes they started on.
+type Server struct {
+	keyStore    keystore.KeyStore
+	elderClient *elder.ElderClient
+	journal     *journal.Journal
+	logLevel    *slog.LevelVar
+	logger      logging.Logger
+
+	// overridesFile saves the overrides, they are kept in memory only when it is empty
+	overridesFile string
+
+	// reloadMu serializes the reloads
+	reloadMu sync.Mutex
+	// base is the config as loaded from config.yaml, without the overrides, guarded by reloadMu
+	base *config.Config
+	// overrides are the changes made through the admin API, merged over base, guarded by reloadMu
+	overrides *config.Overrides
+	// policy is the engine of the policy file, guarded by reloadMu
+	policy *policy.Engine
+	routes atomic.Pointer[routes]
+	// retiring counts the removed rollApps still draining
+	retiring sync.WaitGroup
+}
+
+// routes are the rollApp handlers and the router built from a config
+type routes struct {
+	cfg      *config.Config
+	rollApps map[string]*rollapp.RollApp
+	router   http.Handler
+}
+
+// New creates the rollApp handlers of cfg merged with the overrides of admin.overrides_file. logLevel
+// is set to the log level of every loaded config.
+func New(cfg *config.Config, keyStore keystore.KeyStore, elderClient *elder.ElderClient, journal *journal.Journal, logLevel *slog.LevelVar, logger logging.Logger) (*Server, error) {
+	s := &Server{
+		keyStore:      keyStore,
+		elderClient:   elderClient,
+		journal:       journal,
+		logLevel:      logLevel,
+		logger:        logger,
+		overridesFile: cfg.Admin.OverridesFile,
+		base:          cfg,
+		overrides:     &config.Overrides{},
+	}
+
+	overrides, err := s.loadOverrides(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	cfg, err = s.merge(context.Background(), cfg, overrides)
+	if err != nil {
+		return nil, err
+	}
+	s.overrides = overrides
+	logLevel.Set(cfg.GetSlogLevel())
+
+	engine, err := s.loadPolicy(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.policy = engine
+
+	rollApps := make(map[string]*rollapp.RollApp)
+	for _, name := range cfg.ListRollApps() {
+		rollAppHandler, err := s.newRollApp(name, cfg)
+		if err != nil {
+			for _, created := range rollApps {
+				created.Close()
+			}
+			return nil, err
+		}
+		rollAppHandler.SetPolicy(engine)
+		rollApps[name] = rollAppHandler
+	}
+	s.routes.Store(s.newRoutes(cfg, rollApps))
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.routes.Load().router.ServeHTTP(w, req)
+}
+
+// Config returns the config being served
+func (s *Server) Config() *config.Config {
+	return s.routes.Load().cfg
+}
+
+// Reload applies a new config merged with the overrides: rollApps are added, reconfigured or removed
+// and the routes swapped. Removed rollApps stop accepting transactions and are closed once their
+// submissions are drained. Settings which are only read at startup are kept, a warning tells they need
+// a restart.
+func (s *Server) Reload(ctx context.Context, cfg *config.Config) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	overrides, err := s.loadOverrides(ctx)
+	if err != nil {
+		return err
+	}
+	merged, err := s.merge(ctx, cfg, overrides)
+	if err != nil {
+		return err
+	}
+	if err := s.apply(ctx, merged); err != nil {
+		return err
+	}
+	s.base, s.overrides = cfg, overrides
+	return nil
+}
+
+// Update applies the changes update makes to a copy of the config being served. The changes are
+// saved as overrides of config.yaml before they are applied, so reloads and restarts keep them.
+func (s *Server) Update(ctx context.Context, update func(cfg *config.Config) error) error {
+	logger := s.logger.With("method", "Update")
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	cfg := *s.routes.Load().cfg
+	cfg.RollAppConfigs = make(map[string]config.RollAppConfig, len(cfg.RollAppConfigs))
+	for name, rollAppConfig := range s.routes.Load().cfg.RollAppConfigs {
+		cfg.RollAppConfigs[name] = rollAppConfig
+	}
+	if err := update(&cfg); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		logger.Error(ctx, "invalid config update", "error", err)
+		return errors.Wrap(err, "invalid config")
+	}
+
+	overrides := config.DiffOverrides(s.base, &cfg)
+	if err := s.saveOverrides(ctx, overrides); err != nil {
+		return err
+	}
+	if err := s.apply(ctx, &cfg); err != nil {
+		// The previous overrides match the config still served
+		_ = s.saveOverrides(ctx, s.overrides)
+		return err
+	}
+	s.overrides = overrides
+	return nil
+}
+
+// loadOverrides reads the overrides file, or returns the overrides in memory when there is none. It
+// must be called with reloadMu held.
+func (s *Server) loadOverrides(ctx context.Context) (*config.Overrides, error) {
+	if s.overridesFile == "" {
+		return s.overrides, nil
+	}
+
+	overrides, err := config.LoadOverrides(s.overridesFile)
+	if err != nil {
+		s.logger.Error(ctx, "failed to load overrides file", "overridesFile", s.overridesFile, "error", err)
+		return nil, errors.Wrapf(err, "failed to load overrides file %s", s.overridesFile)
+	}
+	return overrides, nil
+}
+
+// saveOverrides writes the overrides file, if any
+func (s *Server) saveOverrides(ctx context.Context, overrides *config.Overrides) error {
+	if s.overridesFile == "" {
+		return nil
+	}
+
+	if err := overrides.Save(s.overridesFile); err != nil {
+		s.logger.Error(ctx, "failed to save overrides file", "overridesFile", s.overridesFile, "error", err)
+		return errors.Wrapf(err, "failed to save overrides file %s", s.overridesFile)
+	}
+	return nil
+}
+
+// merge returns cfg with the overrides applied
+func (s *Server) merge(ctx context.Context, cfg *config.Config, overrides *config.Overrides) (*config.Config, error) {
+	merged, err := overrides.Apply(cfg)
+	if err != nil {
+		s.logger.Error(ctx, "failed to merge overrides", "overridesFile", s.overridesFile, "error", err)
+		return nil, errors.Wrap(err, "failed to merge overrides")
+	}
+	return merged, nil
+}
+
+// RollApp returns the handler of a rollApp being served
+func (s *Server) RollApp(name string) (*rollapp.RollApp, bool) {
+	rollAppHandler, ok := s.routes.Load().rollApps[name]
+	return rollAppHandler, ok
+}
+
+// apply prepares every change of cfg first and applies them only once all of them succeeded, a
+// failing rollApp leaves the config being served unchanged. It must be called with reloadMu held.
+func (s *Server) apply(ctx context.Context, cfg *config.Config) error {
+	logger := s.logger.With("method", "apply")
+
+	current := s.routes.Load()
+	applied := *cfg
+	for _, setting := range keepStartupSettings(current.cfg, &applied) {
+		logger.Warn(ctx, "Config change needs a restart", "setting", setting)
+	}
+	cfg = &applied
+
+	engine, err := s.loadPolicy(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	rollApps := make(map[string]*rollapp.RollApp, len(cfg.RollAppConfigs))
+	var added []*rollapp.RollApp
+	var reconfigurations []*rollapp.Reconfiguration
+	discard := func() {
+		for _, created := range added {
+			created.Close()
+		}
+		for _, reconfiguration := range reconfigurations {
+			reconfiguration.Discard()
+		}
+	}
+	for _, name := range cfg.ListRollApps() {
+		rollAppConfig, err := cfg.GetRollAppConfig(name)
+		if err != nil {
+			logger.Error(ctx, "failed to get rollapp config", "rollapp", name, "error", err)
+			discard()
+			return errors.Wrapf(err, "failed to get rollapp config for %s", name)
+		}
+
+		existing, ok := current.rollApps[name]
+		if !ok {
+			logger.Info(ctx, "Adding rollapp", "rollapp", name, "rpc", rollAppConfig.RPC, "elderId", rollAppConfig.ElderRegistrationId)
+			rollAppHandler, err := s.newRollApp(name, cfg)
+			if err != nil {
+				discard()
+				return err
+			}
+			added = append(added, rollAppHandler)
+			rollApps[name] = rollAppHandler
+			continue
+		}
+
+		if previous, _ := current.cfg.GetRollAppConfig(name); !reflect.DeepEqual(previous, rollAppConfig) {
+			logger.Info(ctx, "Reconfiguring rollapp", "rollapp", name, "rpc", rollAppConfig.RPC, "elderId", rollAppConfig.ElderRegistrationId)
+			reconfiguration, err := existing.PrepareReconfigure(rollAppConfig)
+			if err != nil {
+				logger.Error(ctx, "failed to reconfigure rollapp", "rollapp", name, "error", err)
+				discard()
+				return errors.Wrapf(err, "failed to reconfigure rollapp %s", name)
+			}
+			reconfigurations = append(reconfigurations, reconfiguration)
+		}
+		rollApps[name] = existing
+	}
+
+	for _, reconfiguration := range reconfigurations {
+		reconfiguration.Apply()
+	}
+	for _, rollAppHandler := range rollApps {
+		rollAppHandler.SetPolicy(engine)
+	}
+	s.policy = engine
+	s.logLevel.Set(cfg.GetSlogLevel())
+	s.routes.Store(s.newRoutes(cfg, rollApps))
+
+	for name, removed := range current.rollApps {
+		if _, ok := rollApps[name]; ok {
+			continue
+		}
+		logger.Info(ctx, "Removing rollapp", "rollapp", name)
+		s.retire(name, removed, cfg)
+	}
+	return nil
+}
+
+// loadPolicy loads the policy file of cfg, keeping the rates and budgets counted by the current policy.
+// It must be called with reloadMu held.
+func (s *Server) loadPolicy(ctx context.Context, cfg *config.Config) (*policy.Engine, error) {
+	if cfg.PolicyFile == "" {
+		return nil, nil
+	}
+
+	engine, err := policy.Load(cfg.PolicyFile, s.keyStore)
+	if err != nil {
+		s.logger.Error(ctx, "failed to load policy file", "policyFile", cfg.PolicyFile, "error", err)
+		return nil, errors.Wrapf(err, "failed to load policy file %s", cfg.PolicyFile)
+	}
+	if engine.FeeBudgets() && !cfg.ElderTx.LocalSigning {
+		s.logger.Error(ctx, "max_daily_elder_fee requires elder_tx.local_signing", "policyFile", cfg.PolicyFile)
+		return nil, fmt.Errorf("policy file %s: max_daily_elder_fee requires elder_tx.local_signing", cfg.PolicyFile)
+	}
+	engine.Continue(s.policy)
+	s.logger.Info(ctx, "Loaded policy file", "policyFile", cfg.PolicyFile)
+	return engine, nil
+}
+
+// ReloadKeys picks up the keys imported in or deleted from the keystore
+func (s *Server) ReloadKeys(ctx context.Context) error {
+	logger := s.logger.With("method", "ReloadKeys")
+
+	if err := s.elderClient.SyncKeys(s.keyStore); err != nil {
+		logger.Error(ctx, "failed to reload keys", "error", err)
+		return errors.Wrap(err, "failed to reload keys")
+	}
+	logger.Info(ctx, "Reloaded keystore")
+	return nil
+}
+
+// Drain stops accepting transactions and waits for the in-flight submissions of every rollApp,
+// including the removed ones still draining
+func (s *Server) Drain(ctx context.Context) {
+	for name, rollAppHandler := range s.routes.Load().rollApps {
+		if err := rollAppHandler.Drain(ctx); err != nil {
+			s.logger.Error(ctx, "failed to drain rollapp submissions", "rollapp", name, "error", err)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.retiring.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.logger.Error(ctx, "removed rollapps did not finish draining", "error", ctx.Err())
+	}
+}
+
+// Close closes the rollApps being served
+func (s *Server) Close() {
+	for _, rollAppHandler := range s.routes.Load().rollApps {
+		rollAppHandler.Close()
+	}
+}
+
+func (s *Server) newRollApp(name string, cfg *config.Config) (*rollapp.RollApp, error) {
+	rollAppConfig, err := cfg.GetRollAppConfig(name)
+	if err != nil {
+		s.logger.Error(nil, "failed to get rollapp config", "rollapp", name, "error", err)
+		return nil, errors.Wrapf(err, "failed to get rollapp config for %s", name)
+	}
+
+	s.logger.Info(nil, "Creating rollapp handler", "rollapp", name, "rpc", rollAppConfig.RPC, "elderId", rollAppConfig.ElderRegistrationId)
+	rollAppHandler, err := rollapp.NewRollApp(
+		name,
+		rollAppConfig,
+		cfg.TxPool,
+		s.keyStore,
+		s.logger.With("rollapp", name),
+		s.elderClient,
+		s.journal,
+	)
+	if err != nil {
+		s.logger.Error(nil, "failed to create rollapp handler", "rollapp", name, "error", err)
+		return nil, errors.Wrapf(err, "failed to create rollapp handler for %s", name)
+	}
+	return rollAppHandler, nil
+}
+
+// retire drains a removed rollApp in the background and closes it
+func (s *Server) retire(name string, rollAppHandler *rollapp.RollApp, cfg *config.Config) {
+	s.retiring.Add(1)
+	go func() {
+		defer s.retiring.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := rollAppHandler.Drain(ctx); err != nil {
+			s.logger.Error(ctx, "failed to drain removed rollapp", "rollapp", name, "error", err)
+		}
+		rollAppHandler.Close()
+	}()
+}
+
+func (s *Server) newRoutes(cfg *config.Config, rollApps map[string]*rollapp.RollApp) *routes {
+	router := mux.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return middleware.RestLoggingMiddleware(next, s.logger)
+	})
+
+	readinessChecks := []health.Check{
+		s.elderClient.ReadinessCheck(cfg.Health.MaxBlockAge),
+		health.KeyStoreCheck(s.keyStore),
+	}
+	if minBalance, ok := cfg.Health.MinElderBalanceInt(); ok {
+		readinessChecks = append(readinessChecks, s.elderClient.BalanceCheck(s.keyStore, cfg.Health.ElderDenom, minBalance))
+	}
+
+	for name, rollAppHandler := range rollApps {
+		readinessChecks = append(readinessChecks, rollAppHandler.ReadinessCheck(cfg.Health.MaxBlockAge))
+
+		router.HandleFunc(fmt.Sprintf("/%s", name), rollAppHandler.HandleWebSocket).Methods(http.MethodGet).HeadersRegexp("Upgrade", "(?i)^websocket$")
+		router.HandleFunc(fmt.Sprintf("/%s", name), rollAppHandler.HandleRequest).Methods(http.MethodPost)
+	}
+
+	router.HandleFunc("/", baseHandler(cfg, rollApps)).Methods(http.MethodGet)
+	router.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+	router.HandleFunc("/healthz", health.HandleHealthz).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", health.NewChecker(cfg.Health.Timeout, s.logger.With("component", "Health"), readinessChecks...).HandleReadyz).Methods(http.MethodGet)
+
+	return &routes{cfg: cfg, rollApps: rollApps, router: router}
+}
+
+// keepStartupSettings sets back on cfg the settings only read at startup and returns those which changed
+func keepStartupSettings(current, cfg *config.Config) []string {
+	var changed []string
+	if current.ElderGrpcEndpoint != cfg.ElderGrpcEndpoint || !reflect.DeepEqual(current.ElderGrpc, cfg.ElderGrpc) {
+		changed = append(changed, "elder_grpc")
+	}
+	if current.ElderTx != cfg.ElderTx {
+		changed = append(changed, "elder_tx")
+	}
+	if current.ElderBatch != cfg.ElderBatch {
+		changed = append(changed, "elder_batch")
+	}
+	if current.ElderWrapPort != cfg.ElderWrapPort {
+		changed = append(changed, "elder_wrap_port")
+	}
+	if current.KeyStoreDir != cfg.KeyStoreDir || current.KeyStoreType != cfg.KeyStoreType || current.KeyStorePasswordFile != cfg.KeyStorePasswordFile {
+		changed = append(changed, "key_store")
+	}
+	if current.JournalDir != cfg.JournalDir {
+		changed = append(changed, "journal_dir")
+	}
+	if !reflect.DeepEqual(current.TxPool, cfg.TxPool) {
+		changed = append(changed, "tx_pool")
+	}
+	if !reflect.DeepEqual(current.Tracing, cfg.Tracing) {
+		changed = append(changed, "tracing")
+	}
+	if current.Admin != cfg.Admin {
+		changed = append(changed, "admin")
+	}
+
+	cfg.ElderGrpcEndpoint, cfg.ElderGrpc = current.ElderGrpcEndpoint, current.ElderGrpc
+	cfg.ElderTx, cfg.ElderBatch = current.ElderTx, current.ElderBatch
+	cfg.ElderWrapPort = current.ElderWrapPort
+	cfg.KeyStoreDir, cfg.KeyStoreType, cfg.KeyStorePasswordFile = current.KeyStoreDir, current.KeyStoreType, current.KeyStorePasswordFile
+	cfg.JournalDir = current.JournalDir
+	cfg.TxPool = current.TxPool
+	cfg.Tracing = current.Tracing
+	cfg.Admin = current.Admin
+	return changed
+}
+
+func baseHandler(cfg *config.Config, rollApps map[string]*rollapp.RollApp) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endpoints := make(map[string]interface{})
+
+		for _, rollApp := range cfg.ListRollApps() {
+			rollAppConfig, err := cfg.GetRollAppConfig(rollApp)
+			if err != nil {
+				continue
+			}
+			endpoint := map[string]interface{}{
+				"endpoint":              fmt.Sprintf("/%s", rollApp),
+				"rpc":                   rollAppConfig.RPC,
+				"ws":                    rollAppConfig.WS,
+				"elder_registration_id": rollAppConfig.ElderRegistrationId,
+				"node_signing":          rollAppConfig.NodeSigning,
+			}
+			if handler, ok := rollApps[rollApp]; ok {
+				endpoint["upstreams"] = handler.UpstreamStatus()
+			}
+			endpoints[rollApp] = endpoint
+		}
+
+		response := map[string]interface{}{
+			"elder_grpc":           cfg.ElderGrpcEndpoint,
+			"elder_grpc_endpoints": cfg.ElderGrpcEndpoints(),
+			"endpoints":            endpoints,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/0xElder/elder-wrap/pkg/elder"
+	"github.com/0xElder/elder-wrap/pkg/keystore"
+	"github.com/0xElder/elder-wrap/pkg/logging"
+)
+
+func newTestConfig(keyStoreDir string, rpcs map[string]string) *config.Config {
+	cfg := &config.Config{
+		ElderGrpcEndpoint: "localhost:9090",
+		ElderWrapPort:     config.DefaultElderWrapPort,
+		KeyStoreDir:       keyStoreDir,
+		ShutdownTimeout:   time.Second,
+		LogLevel:          "info",
+		RollAppConfigs:    make(map[string]config.RollAppConfig),
+	}
+	for name, rpc := range rpcs {
+		cfg.RollAppConfigs[name] = config.RollAppConfig{
+			RPC:                 rpc,
+			UpstreamSelection:   config.UpstreamSelectionRoundRobin,
+			HealthCheckInterval: time.Hour,
+			MaxBlockLag:         config.DefaultMaxBlockLag,
+			ElderRegistrationId: 1,
+			SubmissionMode:      config.SubmissionModeSync,
+		}
+	}
+	return cfg
+}
+
+func TestServer_Reload(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer upstream.Close()
+
+	keyStoreDir := t.TempDir()
+	keyStore, err := keystore.NewPlainKeyStore(keyStoreDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logging.NewDevSlogger(nil)
+	cfg := newTestConfig(keyStoreDir, map[string]string{"rollup1": upstream.URL})
+	elderClient, err := elder.NewElderClient(cfg.ElderGrpcEndpoints(), cfg.ElderGrpc, keyStore, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elderClient.Close()
+
+	logLevel := new(slog.LevelVar)
+	s, err := New(cfg, keyStore, elderClient, nil, logLevel, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	status := func(path string) int {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`)))
+		return w.Code
+	}
+	if got := status("/rollup1"); got != http.StatusOK {
+		t.Fatalf("POST /rollup1 = %d before reload, want %d", got, http.StatusOK)
+	}
+
+	reloaded := newTestConfig(keyStoreDir, map[string]string{"rollup2": upstream.URL})
+	reloaded.LogLevel = "debug"
+	reloaded.ElderWrapPort = "9000"
+	if err := s.Reload(context.Background(), reloaded); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: "/rollup1", want: http.StatusNotFound},
+		{path: "/rollup2", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := status(tt.path); got != tt.want {
+			t.Errorf("POST %s = %d after reload, want %d", tt.path, got, tt.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	var base struct {
+		Endpoints map[string]interface{} `json:"endpoints"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &base); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := base.Endpoints["rollup2"]; !ok || len(base.Endpoints) != 1 {
+		t.Errorf("GET / endpoints = %v, want rollup2 only", base.Endpoints)
+	}
+
+	if logLevel.Level() != slog.LevelDebug {
+		t.Errorf("log level = %v after reload, want %v", logLevel.Level(), slog.LevelDebug)
+	}
+	if got := s.Config().ElderWrapPort; got != config.DefaultElderWrapPort {
+		t.Errorf("elder_wrap_port = %s after reload, want it kept at %s", got, config.DefaultElderWrapPort)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.Drain(ctx)
+	if ctx.Err() != nil {
+		t.Error("Drain() did not wait for the removed rollapp only")
+	}
+}
+
+func TestServer_Reload_policyFile(t *testing.T) {
+	keyStoreDir := t.TempDir()
+	keyStore, err := keystore.NewPlainKeyStore(keyStoreDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logging.NewDevSlogger(nil)
+	cfg := newTestConfig(keyStoreDir, map[string]string{"rollup1": "http://localhost:8545"})
+	elderClient, err := elder.NewElderClient(cfg.ElderGrpcEndpoints(), cfg.ElderGrpc, keyStore, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elderClient.Close()
+
+	s, err := New(cfg, keyStore, elderClient, nil, new(slog.LevelVar), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	policyFile := filepath.Join(t.TempDir(), "policy.yaml")
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "valid policy file", content: "default: deny\nrules:\n  - action: allow\n    max_gas: 1000000\n", wantErr: false},
+		{name: "invalid policy file", content: "rules:\n  - action: relay\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(policyFile, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			reloaded := newTestConfig(keyStoreDir, map[string]string{"rollup1": "http://localhost:8545", "rollup2": "http://localhost:8545"})
+			reloaded.PolicyFile = policyFile
+			if err := s.Reload(context.Background(), reloaded); (err != nil) != tt.wantErr {
+				t.Fatalf("Reload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, ok := s.RollApp("rollup2"); !ok {
+				t.Error("Reload() did not keep the last valid config")
+			}
+		})
+	}
+}
+
+func TestServer_Reload_atomic(t *testing.T) {
+	keyStoreDir := t.TempDir()
+	keyStore, err := keystore.NewPlainKeyStore(keyStoreDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logging.NewDevSlogger(nil)
+	cfg := newTestConfig(keyStoreDir, map[string]string{"rollup1": "http://localhost:8545", "rollup2": "http://localhost:8545"})
+	elderClient, err := elder.NewElderClient(cfg.ElderGrpcEndpoints(), cfg.ElderGrpc, keyStore, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elderClient.Close()
+
+	s, err := New(cfg, keyStore, elderClient, nil, new(slog.LevelVar), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// rollup1 is reconfigured first, then rollup2 fails on a sponsor key missing from the keystore
+	reloaded := newTestConfig(keyStoreDir, map[string]string{"rollup1": "http://localhost:8546", "rollup2": "http://localhost:8545", "rollup3": "http://localhost:8545"})
+	failing := reloaded.RollAppConfigs["rollup2"]
+	failing.SponsorKey = "missing"
+	reloaded.RollAppConfigs["rollup2"] = failing
+	if err := s.Reload(context.Background(), reloaded); err == nil {
+		t.Fatal("Reload() error = nil with a missing sponsor key")
+	}
+
+	rollup1, ok := s.RollApp("rollup1")
+	if !ok {
+		t.Fatal("Reload() removed rollup1")
+	}
+	if statuses := rollup1.UpstreamStatus(); len(statuses) != 1 || statuses[0].URL != "http://localhost:8545" {
+		t.Errorf("rollup1 upstreams = %v after a failed reload, want http://localhost:8545", statuses)
+	}
+	if _, ok := s.RollApp("rollup3"); ok {
+		t.Error("Reload() added rollup3 while the reload failed")
+	}
+	if got := s.Config().RollAppConfigs["rollup1"].RPC; got != "http://localhost:8545" {
+		t.Errorf("rollup1 rpc = %s after a failed reload, want http://localhost:8545", got)
+	}
+}
+
+func TestServer_Update_overrides(t *testing.T) {
+	keyStoreDir := t.TempDir()
+	keyStore, err := keystore.NewPlainKeyStore(keyStoreDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logging.NewDevSlogger(nil)
+	elderClient, err := elder.NewElderClient([]string{"localhost:9090"}, config.ElderGrpcConfig{}, keyStore, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elderClient.Close()
+
+	tests := []struct {
+		name          string
+		overridesFile string
+		// restart creates a new server from config.yaml after the update
+		restart bool
+	}{
+		{name: "reload without overrides file", overridesFile: ""},
+		{name: "reload with overrides file", overridesFile: filepath.Join(t.TempDir(), "overrides.yaml")},
+		{name: "restart with overrides file", overridesFile: filepath.Join(t.TempDir(), "overrides.yaml"), restart: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := func() *config.Config {
+				cfg := newTestConfig(keyStoreDir, map[string]string{"rollup1": "http://localhost:8545", "rollup2": "http://localhost:8545"})
+				cfg.Admin.OverridesFile = tt.overridesFile
+				return cfg
+			}
+			s, err := New(base(), keyStore, elderClient, nil, new(slog.LevelVar), logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { s.Close() }()
+
+			err = s.Update(context.Background(), func(cfg *config.Config) error {
+				delete(cfg.RollAppConfigs, "rollup1")
+				rollup2 := cfg.RollAppConfigs["rollup2"]
+				rollup2.RPC = "http://localhost:8546"
+				cfg.RollAppConfigs["rollup2"] = rollup2
+				cfg.RollAppConfigs["rollup3"] = rollup2
+				cfg.LogLevel = "debug"
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.restart {
+				s.Close()
+				restarted, err := New(base(), keyStore, elderClient, nil, new(slog.LevelVar), logger)
+				if err != nil {
+					t.Fatal(err)
+				}
+				s = restarted
+			} else if err := s.Reload(context.Background(), base()); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := s.RollApp("rollup1"); ok {
+				t.Error("rollup1 deleted through Update is served again")
+			}
+			for _, name := range []string{"rollup2", "rollup3"} {
+				if got := s.Config().RollAppConfigs[name].RPC; got != "http://localhost:8546" {
+					t.Errorf("%s rpc = %q, want the rpc set through Update", name, got)
+				}
+			}
+			if got := s.Config().LogLevel; got != "debug" {
+				t.Errorf("log level = %s, want the level set through Update", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// watchDebounce groups the events of an editor or a key import into a single reload
+const watchDebounce = 500 * time.Millisecond
+
+// Watch reloads the config file, the policy file and the keystore when they change on disk or on
+// SIGHUP, until ctx is done. A config which fails to load is logged and the current one kept.
+func (s *Server) Watch(ctx context.Context, configPath string) error {
+	logger := s.logger.With("method", "Watch")
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Error(ctx, "failed to create file watcher", "error", err)
+		return errors.Wrap(err, "failed to create file watcher")
+	}
+	defer watcher.Close()
+
+	// Editors and config management replace the file, so its directory is watched
+	configPath, err = filepath.Abs(configPath)
+	if err != nil {
+		return errors.Wrap(err, "invalid config path")
+	}
+	keyStoreDir, err := filepath.Abs(s.Config().KeyStoreDir)
+	if err != nil {
+		return errors.Wrap(err, "invalid key_store_dir")
+	}
+	dirs := []string{filepath.Dir(configPath), keyStoreDir}
+	// The policy file is reloaded with the config, a new policy_file is watched after a restart
+	var policyPath string
+	if policyFile := s.Config().PolicyFile; policyFile != "" {
+		policyPath, err = filepath.Abs(policyFile)
+		if err != nil {
+			return errors.Wrap(err, "invalid policy_file")
+		}
+		dirs = append(dirs, filepath.Dir(policyPath))
+	}
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			logger.Error(ctx, "failed to watch directory", "dir", dir, "error", err)
+			return errors.Wrapf(err, "failed to watch %s", dir)
+		}
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	logger.Info(ctx, "Watching config and keystore", "config", configPath, "policyFile", policyPath, "keyStoreDir", keyStoreDir)
+
+	var reloadConfig, reloadKeys bool
+	debounce := time.NewTimer(watchDebounce)
+	debounce.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hangup:
+			logger.Info(ctx, "Received SIGHUP, reloading config and keystore")
+			s.reload(ctx, configPath, true, true)
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			switch {
+			case filepath.Clean(event.Name) == configPath, policyPath != "" && filepath.Clean(event.Name) == policyPath:
+				reloadConfig = true
+			case filepath.Dir(event.Name) == keyStoreDir:
+				reloadKeys = true
+			default:
+				continue
+			}
+			debounce.Reset(watchDebounce)
+		case <-debounce.C:
+			s.reload(ctx, configPath, reloadConfig, reloadKeys)
+			reloadConfig, reloadKeys = false, false
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logger.Error(ctx, "file watcher failed", "error", err)
+		}
+	}
+}
+
+func (s *Server) reload(ctx context.Context, configPath string, reloadConfig, reloadKeys bool) {
+	logger := s.logger.With("method", "reload")
+
+	if reloadConfig {
+		cfg, err := config.Load(configPath)
+		if err != nil {
+			logger.Error(ctx, "failed to load config, keeping the current one", "config", configPath, "error", err)
+		} else if err := s.Reload(ctx, cfg); err != nil {
+			logger.Error(ctx, "failed to apply config, keeping the current one", "config", configPath, "error", err)
+		} else {
+			logger.Info(ctx, "Reloaded config", "config", configPath)
+		}
+	}
+	if reloadKeys {
+		// ReloadKeys logs its errors
+		_ = s.ReloadKeys(ctx)
+	}
+}
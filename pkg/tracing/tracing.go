@@ -0,0 +1,118 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/0xElder/elder-wrap"
+
+// Span attributes
+const (
+	RollAppKey     = attribute.Key("elder_wrap.rollapp")
+	MethodKey      = attribute.Key("rpc.method")
+	SenderKey      = attribute.Key("elder_wrap.sender")
+	ElderSenderKey = attribute.Key("elder_wrap.elder_sender")
+	TxHashKey      = attribute.Key("elder_wrap.tx_hash")
+	ElderTxHashKey = attribute.Key("elder_wrap.elder_tx_hash")
+	BatchSizeKey   = attribute.Key("elder_wrap.batch_size")
+)
+
+// Setup installs the tracer provider exporting the spans as configured. Without an exporter the
+// spans are not recorded. The returned function flushes the buffered spans and stops the exporter.
+func Setup(ctx context.Context, cfg config.TracingConfig) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case config.TracingExporterOTLP:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithHeaders(cfg.Headers)}
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		otlpExporter, err := otlptracegrpc.New(ctx, opts...)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create OTLP trace exporter")
+		}
+		exporter = otlpExporter
+	case config.TracingExporterStdout:
+		stdoutExporter, err := stdouttrace.New()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create stdout trace exporter")
+		}
+		exporter = stdoutExporter
+	default:
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(cfg.ServiceName)),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create trace resource")
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Start starts a span named name, child of the span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// RecordError records err on the span in ctx
+func RecordError(ctx context.Context, err error) {
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// SetAttributes sets attributes on the span in ctx
+func SetAttributes(ctx context.Context, attrs ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).SetAttributes(attrs...)
+}
+
+// Extract returns ctx with the trace context propagated in the headers of an incoming request
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Inject adds the trace context of ctx to the headers of an outgoing request
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
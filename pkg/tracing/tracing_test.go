@@ -0,0 +1,62 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/0xElder/elder-wrap/pkg/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetupWithoutExporter(t *testing.T) {
+	shutdown, err := Setup(context.Background(), config.TracingConfig{Exporter: config.TracingExporterNone})
+	if err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() error = %v", err)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	ctx, parent := Start(context.Background(), "HandleRequest", RollAppKey.String("rollup1"))
+	_, child := Start(ctx, "BroadCastTxn")
+	End(child, errors.New("boom"))
+	SetAttributes(ctx, TxHashKey.String("0x01"))
+
+	header := http.Header{}
+	Inject(ctx, header)
+	if got := Extract(context.Background(), header); !sameTrace(got, ctx) {
+		t.Errorf("Extract() did not return the injected trace context, header %v", header)
+	}
+	End(parent, nil)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if spans[0].Name() != "BroadCastTxn" || spans[0].Status().Code != codes.Error {
+		t.Errorf("child span = %s %v, want BroadCastTxn with error status", spans[0].Name(), spans[0].Status())
+	}
+	if spans[0].Parent().SpanID() != spans[1].SpanContext().SpanID() {
+		t.Errorf("child span parent = %s, want %s", spans[0].Parent().SpanID(), spans[1].SpanContext().SpanID())
+	}
+	if spans[1].Status().Code == codes.Error || len(spans[1].Attributes()) != 2 {
+		t.Errorf("parent span = %v %v, want unset status and 2 attributes", spans[1].Status(), spans[1].Attributes())
+	}
+}
+
+func sameTrace(a, b context.Context) bool {
+	return trace.SpanContextFromContext(a).TraceID() == trace.SpanContextFromContext(b).TraceID()
+}